@@ -1,15 +1,18 @@
 package apiclient
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
+	"context"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"strings"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Enclave-Markets/enclave-go/models"
+	"golang.org/x/time/rate"
 )
 
 type V0AuthToken struct {
@@ -21,6 +24,9 @@ type V0AuthToken struct {
 	NewAccount    bool   `json:"newAccount"`
 }
 
+// ApiKeyArgs is an API key and its secret.
+//
+// Deprecated: authenticate with WithApiKey or WithSigner.
 type ApiKeyArgs struct {
 	KeyId     string
 	KeySecret string
@@ -31,46 +37,94 @@ type ApiClient struct {
 
 	// Can be used to authenticate requests. Either with JWT token or an API key. The API key needs to sign
 	// each request with a timestamp and signature.
-	apiKeyArgs *ApiKeyArgs
-	Headers    map[string]string
+	signer  Signer
+	jwt     *jwtSession
+	Headers map[string]string
+
+	// Empty for the main account
+	subaccountId models.SubaccountID
+
+	// The ClientPool account the client was added as, empty otherwise
+	account models.AccountID
+
+	// Empty to derive the websocket URL from ApiEndpoint
+	websocketURL string
+	tlsConfig    *tls.Config
+	httpClient   *http.Client
+
+	clock       *serverClock
+	rateLimiter *rate.Limiter
+	logging     *clientLogging
+	metrics     Metrics
+	tracer      Tracer
+
+	interceptors *interceptorChain
+
+	// Shared with every view of the client
+	shared *clientShared
+
+	// Set by WithContext, nil for context.Background
+	ctx context.Context
 }
 
+// clientShared is state read by a client and every view of it: ForSubaccount and WithContext copies, and the
+// clients of a ClientPool built from it, whenever they were created
+type clientShared struct {
+	killSwitch atomic.Pointer[KillSwitch]
+
+	// The client each account is reached through, for a kill switch to act on
+	mu       sync.Mutex
+	accounts map[models.AccountID]*killSwitchEntry
+}
+
+// WithApiKey authenticates requests by signing them with an in-memory API key secret
 func (c *ApiClient) WithApiKey(keyId, keySecret string) *ApiClient {
-	c.apiKeyArgs = &ApiKeyArgs{
-		KeyId:     keyId,
-		KeySecret: keySecret,
-	}
+	return c.WithSigner(NewHmacSigner(keyId, keySecret))
+}
+
+// WithRateLimiter makes every request wait for limiter before it is sent
+func (c *ApiClient) WithRateLimiter(limiter *rate.Limiter) *ApiClient {
+	c.rateLimiter = limiter
 	return c
 }
 
-func generateSignature(apiSecret string, timestamp string, method string, requestPath string, body string) []byte {
-	concattedString := timestamp + method + requestPath + body
-	mac := hmac.New(sha256.New, []byte(apiSecret))
-	mac.Write([]byte(concattedString))
-	return mac.Sum(nil)
+// WithSigner authenticates requests by signing them with signer
+func (c *ApiClient) WithSigner(signer Signer) *ApiClient {
+	c.signer = signer
+	return c
 }
 
-func (c *ApiClient) computeApiKeyArgs(httpVerb string, path string, request any) (string, string) {
-	if c.apiKeyArgs == nil {
-		return "", ""
+// computeApiKeyArgs returns the timestamp and hex signature for a request. Both are empty if no signer is set. The
+// same payload is signed for the main account and subaccounts: the subaccount is only sent in the
+// ENCLAVE-SUBACCOUNT header and the websocket login args.
+func (c *ApiClient) computeApiKeyArgs(httpVerb string, path string, request any) (string, string, error) {
+	if c.signer == nil {
+		return "", "", nil
 	}
 	jsonBody, err := json.Marshal(request)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to marshal request body: %s", err.Error()))
+		return "", "", fmt.Errorf("failed to marshal request body: %w", err)
 	}
 	body := string(jsonBody)
 	if body == "null" {
 		body = ""
 	}
 
-	timestamp := fmt.Sprint(time.Now().UnixMilli())
-	sig := generateSignature(c.apiKeyArgs.KeySecret, timestamp, httpVerb, path, body)
-	return timestamp, hex.EncodeToString(sig)
+	timestamp := fmt.Sprint(c.clock.now().UnixMilli())
+	sig, err := c.signer.Sign([]byte(timestamp + httpVerb + path + body))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to sign request: %w", err)
+	}
+	return timestamp, hex.EncodeToString(sig), nil
 }
 
-// getHeaders returns the headers for a request. It includes the auth headers and any extra headers set on the client.
-func (c *ApiClient) getHeaders(httpVerb string, path string, request any) map[string]string {
-	headers := c.getAuthHeaders(httpVerb, path, request)
+// getHeaders returns the headers for a request other than the auth headers: the subaccount and any extra headers set
+// on the client.
+func (c *ApiClient) getHeaders() map[string]string {
+	headers := map[string]string{}
+	if c.subaccountId != "" {
+		headers[subaccountHeader] = string(c.subaccountId)
+	}
 
 	for k, v := range c.Headers {
 		headers[k] = v
@@ -79,39 +133,148 @@ func (c *ApiClient) getHeaders(httpVerb string, path string, request any) map[st
 	return headers
 }
 
-func (c *ApiClient) getAuthHeaders(httpVerb string, path string, request any) map[string]string {
-	timestamp, sig := c.computeApiKeyArgs(httpVerb, path, request)
+func (c *ApiClient) getAuthHeaders(httpVerb string, path string, request any) (map[string]string, error) {
+	if c.jwt != nil {
+		token, err := c.jwt.current()
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{authorizationHeader: "Bearer " + token}, nil
+	}
+
+	timestamp, sig, err := c.computeApiKeyArgs(httpVerb, path, request)
+	if err != nil {
+		return nil, err
+	}
 	headers := map[string]string{}
-	if c.apiKeyArgs != nil {
-		headers["ENCLAVE-KEY-ID"] = c.apiKeyArgs.KeyId
+	if c.signer != nil {
+		headers["ENCLAVE-KEY-ID"] = c.signer.KeyId()
 		headers["ENCLAVE-TIMESTAMP"] = timestamp
 		headers["ENCLAVE-SIGN"] = sig
 	}
-	return headers
+	return headers, nil
 }
 
 func NewApiClient(apiEndpoint string) *ApiClient {
-	return &ApiClient{
-		ApiEndpoint: apiEndpoint,
-		Headers:     map[string]string{},
+	client := &ApiClient{
+		ApiEndpoint:  apiEndpoint,
+		Headers:      map[string]string{},
+		clock:        &serverClock{},
+		shared:       &clientShared{},
+		interceptors: &interceptorChain{},
 	}
+	client.shared.register(client)
+	return client
 }
 
+// NewApiClientFromEnv creates a client for a named environment: one of the built-in "sandbox" and "prod", or a
+// profile registered with RegisterEnvironment or loaded from the file named by $ENCLAVE_ENVIRONMENTS.
 func NewApiClientFromEnv(env string) (*ApiClient, error) {
-	var apiUrl string
-	switch strings.ToLower(env) {
-	case "sandbox":
-		apiUrl = "https://api-sandbox.enclave.market"
-	case "prod":
-		apiUrl = "https://api.enclave.market"
-	default:
-		return nil, fmt.Errorf("unknown env: %s", env)
+	environment, err := LookupEnvironment(env)
+	if err != nil {
+		return nil, err
+	}
+	return NewApiClientFromEnvironment(environment)
+}
+
+// NewApiClientFromEnvironment creates a client for the REST and websocket endpoints and TLS policy of env.
+func NewApiClientFromEnvironment(env Environment) (*ApiClient, error) {
+	if env.ApiEndpoint == "" {
+		return nil, fmt.Errorf("environment %s has no api endpoint", env.Name)
+	}
+
+	tlsConfig, err := env.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client := NewApiClient(env.ApiEndpoint).WithWebsocketURL(env.WebsocketURL)
+	if tlsConfig != nil {
+		client.WithTLSConfig(tlsConfig)
 	}
 
-	return &ApiClient{
-		ApiEndpoint: apiUrl,
-		Headers:     map[string]string{},
-	}, nil
+	return client, nil
+}
+
+// send performs an authenticated JSON request to path on the client's endpoint, passing it through the client's
+// interceptors. The innermost handler waits for the rate limiter and signs the request each time it is sent. If the
+// server rejects a JWT the request is retried once with a fresh token.
+func send[REQUEST_T any, REPLY_T any](client *ApiClient, method string, path string, req REQUEST_T) (res *REPLY_T, err error) {
+	ctx, span := client.startSpan(method, path, req)
+	var status int
+	defer func() { endSpan(span, status, res, err) }()
+
+	if err := client.checkKillSwitch(ctx, method, path); err != nil {
+		return nil, err
+	}
+
+	transport := func(call *Call) error {
+		call.StatusCode = 0
+		call.Response = nil
+		if client.rateLimiter != nil {
+			waitStart := time.Now()
+			if err := client.rateLimiter.Wait(call.Context); err != nil {
+				return err
+			}
+			client.getMetrics().ObserveRateLimitWait(time.Since(waitStart))
+		}
+
+		auth, err := client.getAuthHeaders(method, path, req)
+		if err != nil {
+			return err
+		}
+		for k, v := range auth {
+			call.Headers[k] = v
+		}
+
+		var requestId string
+		sent := time.Now()
+		res, err := NewHttpJsonClient[REQUEST_T, REPLY_T](client.ApiEndpoint+path).
+			SetHttpClient(client.httpClient).
+			SetHeaders(call.Headers).
+			SetResponseHook(func(resp *http.Response) {
+				call.StatusCode = resp.StatusCode
+				requestId = resp.Header.Get(requestIdHeader)
+				client.clock.observe(sent, time.Now(), resp)
+			}).
+			Do(method, req)
+		latency := time.Since(sent)
+		client.logging.logRequest(method, path, call.StatusCode, latency, requestId, req, res, err)
+		client.getMetrics().ObserveRequest(method, endpointLabel(path), call.StatusCode, latency)
+
+		if res != nil {
+			call.Response = res
+		}
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		headers := client.getHeaders()
+		if client.tracer != nil {
+			client.tracer.Inject(ctx, headers)
+		}
+
+		call := &Call{Context: ctx, Method: method, Path: path, Account: client.account, Subaccount: client.subaccountId,
+			Headers: headers, Request: req}
+		err = client.runInterceptors(call, transport)
+		status = call.StatusCode
+
+		if status == http.StatusUnauthorized && attempt == 0 && client.jwt.invalidate(bearerToken(call.Headers[authorizationHeader])) {
+			continue
+		}
+		res, _ := call.Response.(*REPLY_T)
+		return res, err
+	}
+}
+
+// unsigned returns a view of the client that sends requests without credentials or a subaccount, for public
+// endpoints
+func (client *ApiClient) unsigned() *ApiClient {
+	view := *client
+	view.signer = nil
+	view.jwt = nil
+	view.subaccountId = ""
+	return &view
 }
 
 func (client *ApiClient) WaitForEndpoint() {
@@ -127,9 +290,7 @@ func (client *ApiClient) WaitForEndpoint() {
 func (client *ApiClient) GetPublicStatus() (*models.GetPublicStatusRes, error) {
 	path := models.StatusPath
 
-	res, err := NewHttpJsonClient[any, models.GetPublicStatusRes](
-		client.ApiEndpoint + path,
-	).Get(nil)
+	res, err := send[any, models.GetPublicStatusRes](client.unsigned(), "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -139,9 +300,7 @@ func (client *ApiClient) GetPublicStatus() (*models.GetPublicStatusRes, error) {
 func (client *ApiClient) Hello() (*map[string]any, error) {
 	path := models.HelloPath
 
-	res, err := NewHttpJsonClient[any, map[string]any](
-		client.ApiEndpoint + path,
-	).Get(nil)
+	res, err := send[any, map[string]any](client.unsigned(), "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -151,9 +310,7 @@ func (client *ApiClient) Hello() (*map[string]any, error) {
 func (client *ApiClient) AuthedHello() (*models.GenericResponse[string], error) {
 	path := models.AuthedHelloPath
 
-	jsonClient := NewHttpJsonClient[any, models.GenericResponse[string]](client.ApiEndpoint + path)
-	jsonClient.SetHeaders(client.getHeaders("GET", path, nil))
-	res, err := jsonClient.Get(nil)
+	res, err := send[any, models.GenericResponse[string]](client, "GET", path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error with http request to authed hello: %s", err)
 	}
@@ -167,9 +324,7 @@ func (client *ApiClient) AuthedHello() (*models.GenericResponse[string], error)
 
 func (client *ApiClient) Markets() (*models.GenericResponse[models.V1GetMarketsResult], error) {
 	path := models.V1MarketsPath
-	res, err := NewHttpJsonClient[any, models.GenericResponse[models.V1GetMarketsResult]](
-		client.ApiEndpoint + path,
-	).SetHeaders(client.getHeaders("GET", path, nil)).Get(nil)
+	res, err := send[any, models.GenericResponse[models.V1GetMarketsResult]](client, "GET", path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error with http request to v1 markets: %w", err)
 	}
@@ -184,9 +339,7 @@ func (client *ApiClient) Markets() (*models.GenericResponse[models.V1GetMarketsR
 func (client *ApiClient) GetBalance(req models.GetBalanceReq) (*models.GenericResponse[models.V0GetBalanceRes], error) {
 	path := models.V0GetBalancePath
 
-	res, err := NewHttpJsonClient[models.GetBalanceReq, models.GenericResponse[models.V0GetBalanceRes]](
-		client.ApiEndpoint + path,
-	).SetHeaders(client.getHeaders("POST", path, req)).Post(req)
+	res, err := send[models.GetBalanceReq, models.GenericResponse[models.V0GetBalanceRes]](client, "POST", path, req)
 	if err != nil {
 		return nil, fmt.Errorf("error with http request to get balance: %w", err)
 	}
@@ -199,9 +352,7 @@ func (client *ApiClient) GetBalance(req models.GetBalanceReq) (*models.GenericRe
 }
 
 func (client *ApiClient) GetPrice(req models.GetPriceReq) (*models.GenericResponse[models.V0GetPriceRes], error) {
-	res, err := NewHttpJsonClient[models.GetPriceReq, models.GenericResponse[models.V0GetPriceRes]](
-		client.ApiEndpoint + models.V0PricePath,
-	).Post(req)
+	res, err := send[models.GetPriceReq, models.GenericResponse[models.V0GetPriceRes]](client.unsigned(), "POST", models.V0PricePath, req)
 	if err != nil {
 		return nil, fmt.Errorf("error with http request to get price: %w", err)
 	}
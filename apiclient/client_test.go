@@ -0,0 +1,139 @@
+package apiclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestPublicEndpointsAreUnsigned(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("GET "+models.StatusPath, func(fakeRequest) (int, any) { return http.StatusOK, models.GetPublicStatusRes{} })
+	ex.handle("GET "+models.HelloPath, func(fakeRequest) (int, any) { return http.StatusOK, map[string]any{} })
+	ex.handle("POST "+models.V0PricePath, func(fakeRequest) (int, any) { return ok(models.V0GetPriceRes{}) })
+	ex.handle("GET "+models.V1MarketsPath, func(fakeRequest) (int, any) { return ok(models.V1GetMarketsResult{}) })
+	client := ex.client().ForSubaccount("sub-1")
+
+	if _, err := client.GetPublicStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Hello(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetPrice(models.GetPriceReq{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Markets(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, route := range []string{"GET " + models.StatusPath, "GET " + models.HelloPath, "POST " + models.V0PricePath} {
+		for _, req := range ex.requests(route) {
+			for _, header := range []string{"ENCLAVE-KEY-ID", "ENCLAVE-SIGN", subaccountHeader} {
+				if req.Header.Get(header) != "" {
+					t.Fatalf("%s sent %s", route, header)
+				}
+			}
+		}
+	}
+	if req := ex.requests("GET " + models.V1MarketsPath)[0]; req.Header.Get("ENCLAVE-SIGN") == "" {
+		t.Fatal("authenticated endpoint sent unsigned")
+	}
+}
+
+func TestSubaccountSignsLikeTheMainAccount(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("GET "+models.V1MarketsPath, func(fakeRequest) (int, any) { return ok(models.V1GetMarketsResult{}) })
+	if _, err := ex.client().ForSubaccount("sub-1").Markets(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := ex.requests("GET " + models.V1MarketsPath)[0]
+	if req.Header.Get(subaccountHeader) != "sub-1" {
+		t.Fatalf("subaccount header %q", req.Header.Get(subaccountHeader))
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(req.Header.Get("ENCLAVE-TIMESTAMP") + "GET" + models.V1MarketsPath))
+	if got, want := req.Header.Get("ENCLAVE-SIGN"), hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Fatalf("signature %s, want %s over timestamp, verb and path only", got, want)
+	}
+
+	args, err := ex.client().ForSubaccount("sub-1").WebsocketLoginArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.SubaccountId != "sub-1" {
+		t.Fatalf("websocket login subaccount %q", args.SubaccountId)
+	}
+	mac = hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(args.TimeUnixMillis + "enclave_ws_login"))
+	if got, want := args.Sign, hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Fatalf("websocket login signature %s, want %s over timestamp and enclave_ws_login only", got, want)
+	}
+}
+
+// countingSigner counts the requests it signs
+type countingSigner struct{ signed atomic.Int32 }
+
+func (s *countingSigner) KeyId() string { return "key" }
+
+func (s *countingSigner) Sign(payload []byte) ([]byte, error) {
+	s.signed.Add(1)
+	return payload, nil
+}
+
+func TestSignedPayloadIgnoresSubaccount(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("POST "+models.V1SpotOrdersPath, func(fakeRequest) (int, any) { return ok(models.ApiOrder{}) })
+	client := ex.client().WithSigner(&countingSigner{})
+	order := models.AddOrderReq{Market: "AVAX-USDC", Side: models.Bid, Size: decimal.NewFromInt(1), Type: models.OrderTypeMarket, ClientOrderID: "c1"}
+	if _, err := client.AddSpotOrder(order); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ForSubaccount("sub-1").AddSpotOrder(order); err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(order)
+	for i, req := range ex.requests("POST " + models.V1SpotOrdersPath) {
+		// countingSigner returns the payload as the signature
+		signed, err := hex.DecodeString(req.Header.Get("ENCLAVE-SIGN"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := req.Header.Get("ENCLAVE-TIMESTAMP") + "POST" + models.V1SpotOrdersPath + string(body); string(signed) != want {
+			t.Fatalf("request %d signed %q, want %q", i, signed, want)
+		}
+	}
+}
+
+func TestOrderPathsEscapeIDs(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("GET "+models.V1PerpsOrdersPath+"/*", func(fakeRequest) (int, any) { return ok(models.ApiOrder{}) })
+	ex.handle("GET "+models.V1SpotOrdersPath+"/*", func(fakeRequest) (int, any) { return ok(models.ApiOrder{}) })
+	client := ex.client()
+
+	if _, err := client.GetPerpsOrderByClientID("a/b?c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetSpotOrderByClientID("a/b?c"); err != nil {
+		t.Fatal(err)
+	}
+
+	for route, want := range map[string]string{
+		"GET " + models.V1PerpsOrdersPath + "/*": models.V1PerpsOrdersPath + "/client:a%2Fb%3Fc",
+		"GET " + models.V1SpotOrdersPath + "/*":  models.V1SpotOrdersPath + "/client:a%2Fb%3Fc",
+	} {
+		requests := ex.requests(route)
+		if len(requests) != 1 || requests[0].RawPath != want {
+			t.Fatalf("%s: requests %+v, want one to %s", route, requests, want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package apiclient
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// serverClock estimates the offset between the server's clock and the local clock from response Date headers.
+//
+// A Date header only has second resolution, so each response bounds the offset to an interval: the server time was
+// at least Date when the response arrived, and less than Date+1s when the request was sent. The estimate is the
+// midpoint of the intersection of these intervals, which narrows as more responses are seen.
+type serverClock struct {
+	mu      sync.Mutex
+	enabled bool
+	sampled bool
+	lo, hi  time.Duration
+}
+
+func (sc *serverClock) observe(sent, received time.Time, resp *http.Response) {
+	if sc == nil {
+		return
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+
+	lo := date.Sub(received)
+	hi := date.Add(time.Second).Sub(sent)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	// Start over if this is the first sample or the local clock has jumped since the last ones
+	if !sc.sampled || lo > sc.hi || hi < sc.lo {
+		sc.lo, sc.hi, sc.sampled = lo, hi, true
+		return
+	}
+	sc.lo = max(sc.lo, lo)
+	sc.hi = min(sc.hi, hi)
+}
+
+func (sc *serverClock) offset() time.Duration {
+	if sc == nil {
+		return 0
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if !sc.sampled {
+		return 0
+	}
+	return sc.lo + (sc.hi-sc.lo)/2
+}
+
+// now returns the estimated server time if compensation is enabled, otherwise the local time
+func (sc *serverClock) now() time.Time {
+	if sc == nil {
+		return time.Now()
+	}
+	sc.mu.Lock()
+	enabled := sc.enabled
+	sc.mu.Unlock()
+
+	if !enabled {
+		return time.Now()
+	}
+	return time.Now().Add(sc.offset())
+}
+
+// WithClockSkewCompensation signs requests with the estimated server time instead of the local time, so a drifting
+// host clock doesn't cause authentication failures. The estimate is refined from every response; call
+// SyncServerTime to take a measurement before the first signed request.
+func (c *ApiClient) WithClockSkewCompensation() *ApiClient {
+	if c.clock == nil {
+		c.clock = &serverClock{}
+	}
+	c.clock.mu.Lock()
+	c.clock.enabled = true
+	c.clock.mu.Unlock()
+	return c
+}
+
+// SyncServerTime measures the server clock offset from a request to the status endpoint
+func (c *ApiClient) SyncServerTime() error {
+	_, err := c.GetPublicStatus()
+	return err
+}
+
+// ClockSkew returns the estimated offset of the server's clock from the local clock, positive when the server is
+// ahead. It is zero until a response has been received.
+func (c *ApiClient) ClockSkew() time.Duration {
+	return c.clock.offset()
+}
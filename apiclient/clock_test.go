@@ -0,0 +1,131 @@
+package apiclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func dateResponse(date time.Time) *http.Response {
+	return &http.Response{Header: http.Header{"Date": []string{date.UTC().Format(http.TimeFormat)}}}
+}
+
+func TestServerClockNarrowsOffset(t *testing.T) {
+	sc := &serverClock{}
+	if got := sc.offset(); got != 0 {
+		t.Fatalf("offset before any sample = %v, want 0", got)
+	}
+
+	local := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	// The server is 10.4s ahead: a request sent at local 0s sees Date 10s, one sent at local 0.7s sees Date 11s
+	sc.observe(local, local.Add(100*time.Millisecond), dateResponse(local.Add(10*time.Second)))
+	sc.observe(local.Add(700*time.Millisecond), local.Add(800*time.Millisecond), dateResponse(local.Add(11*time.Second)))
+
+	if sc.lo != 10200*time.Millisecond || sc.hi != 11*time.Second {
+		t.Fatalf("offset bounds = [%v, %v], want [10.2s, 11s]", sc.lo, sc.hi)
+	}
+	if got := sc.offset(); got != 10600*time.Millisecond {
+		t.Fatalf("offset = %v, want 10.6s", got)
+	}
+
+	// A sample that can't overlap the current bounds means the local clock jumped, so the estimate starts over
+	sc.observe(local, local.Add(100*time.Millisecond), dateResponse(local.Add(-time.Hour)))
+	if got := sc.offset(); got > -time.Hour+time.Second || got < -time.Hour-time.Second {
+		t.Fatalf("offset after a clock jump = %v, want about -1h", got)
+	}
+
+	// Responses without a usable Date header are ignored
+	before := sc.offset()
+	sc.observe(local, local, &http.Response{Header: http.Header{}})
+	if got := sc.offset(); got != before {
+		t.Fatalf("offset changed to %v after a response without a Date header", got)
+	}
+}
+
+// skewedServer answers every request with a Date header skew ahead of the local clock and records the
+// ENCLAVE-TIMESTAMP of each signed request
+type skewedServer struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	timestamps []int64
+}
+
+func newSkewedServer(t *testing.T, skew time.Duration) *skewedServer {
+	t.Helper()
+
+	s := &skewedServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ts := r.Header.Get("ENCLAVE-TIMESTAMP"); ts != "" {
+			millis, _ := strconv.ParseInt(ts, 10, 64)
+			s.mu.Lock()
+			s.timestamps = append(s.timestamps, millis)
+			s.mu.Unlock()
+		}
+		w.Header().Set("Date", time.Now().Add(skew).UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"success":true,"result":{}}`))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *skewedServer) lastTimestamp(t *testing.T) time.Time {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.timestamps) == 0 {
+		t.Fatal("no signed request was received")
+	}
+	return time.UnixMilli(s.timestamps[len(s.timestamps)-1])
+}
+
+func assertNear(t *testing.T, what string, got, want time.Time) {
+	t.Helper()
+	if d := got.Sub(want); d < -2*time.Second || d > 2*time.Second {
+		t.Fatalf("%s = %v, want within 2s of %v", what, got, want)
+	}
+}
+
+func TestClockSkewCompensation(t *testing.T) {
+	const skew = time.Hour
+	srv := newSkewedServer(t, skew)
+	client := NewApiClient(srv.URL).WithApiKey("key", "secret").WithClockSkewCompensation()
+
+	if err := client.SyncServerTime(); err != nil {
+		t.Fatalf("SyncServerTime: %v", err)
+	}
+	if got := client.ClockSkew(); got < skew-2*time.Second || got > skew+2*time.Second {
+		t.Fatalf("ClockSkew() = %v, want about %v", got, skew)
+	}
+
+	client.GetSpotOrder("o1")
+	assertNear(t, "REST timestamp", srv.lastTimestamp(t), time.Now().Add(skew))
+
+	args, err := client.WebsocketLoginArgs()
+	if err != nil {
+		t.Fatalf("WebsocketLoginArgs: %v", err)
+	}
+	millis, err := strconv.ParseInt(args.TimeUnixMillis, 10, 64)
+	if err != nil {
+		t.Fatalf("bad login time %q: %v", args.TimeUnixMillis, err)
+	}
+	assertNear(t, "websocket login time", time.UnixMilli(millis), time.Now().Add(skew))
+}
+
+func TestClockSkewIsMeasuredButNotAppliedByDefault(t *testing.T) {
+	const skew = time.Hour
+	srv := newSkewedServer(t, skew)
+	client := NewApiClient(srv.URL).WithApiKey("key", "secret")
+
+	client.GetSpotOrder("o1")
+	if got := client.ClockSkew(); got < skew-2*time.Second || got > skew+2*time.Second {
+		t.Fatalf("ClockSkew() = %v, want about %v", got, skew)
+	}
+
+	client.GetSpotOrder("o1")
+	assertNear(t, "REST timestamp", srv.lastTimestamp(t), time.Now())
+}
@@ -0,0 +1,527 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+)
+
+// BackpressurePolicy decides what happens when a handler falls behind the websocket
+type BackpressurePolicy int
+
+const (
+	// Block the read loop until the handler has room. Nothing is lost, but every other handler waits too.
+	Block BackpressurePolicy = iota
+
+	// Discard the oldest queued event to make room for the new one
+	DropOldest
+
+	// Keep only the latest queued event per market, e.g. for top of book where only the current state matters
+	ConflateLatest
+)
+
+const DefaultHandlerQueueSize = 1024
+
+// Event is a websocket update for one market
+type Event struct {
+	Channel ChannelType
+
+	// Empty if the update could not be attributed to a market
+	Market models.Market
+
+	// A single element of the update, e.g. *models.ApiFill for fills channels. If the update could not be split
+	// by market, the whole decoded update.
+	Data any
+}
+
+type HandlerOptions struct {
+	Policy BackpressurePolicy
+
+	// Events queued before the policy applies. Defaults to DefaultHandlerQueueSize.
+	QueueSize int
+}
+
+// Dispatcher runs the websocket read loop and delivers updates to the handlers registered for each channel and
+// market. Each handler runs on its own goroutine with its own queue, so a slow handler only affects others if its
+// policy is Block. The connection is re-established and subscriptions restored if it drops.
+type Dispatcher struct {
+	client *ApiClient
+	logger *slog.Logger
+
+	// Time between pings. Reads time out after three intervals without a message.
+	PingInterval time.Duration
+
+	// Held while subscriptions are sent, so a connection's restored subscriptions and later changes to them are
+	// written in order. mu is never held while writing.
+	subscribing sync.Mutex
+
+	mu       sync.Mutex
+	conn     *WebsocketConn
+	handlers map[ChannelType][]*HandlerRegistration
+
+	// Subscribed markets by channel. The empty market stands for the whole channel.
+	subscriptions map[ChannelType]map[models.Market]bool
+	onMessage     []func(*WebSocketAPIResponse)
+	onConnection  []func(connected bool)
+}
+
+const DefaultPingInterval = 15 * time.Second
+
+func (client *ApiClient) NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		client:        client,
+		logger:        client.logging.websocketLogger(),
+		PingInterval:  DefaultPingInterval,
+		handlers:      map[ChannelType][]*HandlerRegistration{},
+		subscriptions: map[ChannelType]map[models.Market]bool{},
+	}
+}
+
+// HandlerRegistration is a handler added with Handle
+type HandlerRegistration struct {
+	dispatcher *Dispatcher
+	channel    ChannelType
+	market     models.Market
+	queue      *handlerQueue
+	fn         func(Event)
+}
+
+// Handle calls fn with every update on channel for market, or for all markets if market is empty. fn is never
+// called concurrently with itself.
+func (d *Dispatcher) Handle(channel ChannelType, market models.Market, opts HandlerOptions, fn func(Event)) *HandlerRegistration {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultHandlerQueueSize
+	}
+	reg := &HandlerRegistration{
+		dispatcher: d,
+		channel:    channel,
+		market:     market,
+		queue:      newHandlerQueue(opts),
+		fn:         fn,
+	}
+	go reg.run()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[channel] = append(d.handlers[channel], reg)
+	return reg
+}
+
+// OnMessage calls fn from the read loop with every message that isn't an update, e.g. errors and subscription
+// confirmations
+func (d *Dispatcher) OnMessage(fn func(*WebSocketAPIResponse)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onMessage = append(d.onMessage, fn)
+}
+
+// OnConnectionChange calls fn whenever the dispatcher connects or its connection drops, e.g. to pull quotes while
+// disconnected
+func (d *Dispatcher) OnConnectionChange(fn func(connected bool)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onConnection = append(d.onConnection, fn)
+}
+
+func (d *Dispatcher) notifyConnection(connected bool) {
+	d.mu.Lock()
+	onConnection := d.onConnection
+	d.mu.Unlock()
+
+	for _, fn := range onConnection {
+		fn(connected)
+	}
+}
+
+// Remove stops delivering events to the handler. Queued events are discarded.
+func (r *HandlerRegistration) Remove() {
+	d := r.dispatcher
+	d.mu.Lock()
+	regs := d.handlers[r.channel]
+	for i, reg := range regs {
+		if reg == r {
+			d.handlers[r.channel] = append(regs[:i:i], regs[i+1:]...)
+			break
+		}
+	}
+	d.mu.Unlock()
+
+	r.queue.close()
+}
+
+// Dropped returns the number of events discarded by the handler's backpressure policy
+func (r *HandlerRegistration) Dropped() uint64 {
+	return r.queue.dropped.Load()
+}
+
+func (r *HandlerRegistration) run() {
+	for {
+		event, ok := r.queue.pop()
+		if !ok {
+			return
+		}
+		r.fn(event)
+	}
+}
+
+// Subscribe subscribes to channel for markets, or for every market if markets is empty. The subscription is
+// restored whenever the connection is re-established. If the dispatcher isn't connected yet it is sent once Run
+// connects.
+func (d *Dispatcher) Subscribe(channel ChannelType, markets ...models.Market) error {
+	d.subscribing.Lock()
+	defer d.subscribing.Unlock()
+
+	d.mu.Lock()
+	if d.subscriptions[channel] == nil {
+		d.subscriptions[channel] = map[models.Market]bool{}
+	}
+	if len(markets) == 0 {
+		d.subscriptions[channel][""] = true
+	}
+	for _, market := range markets {
+		d.subscriptions[channel][market] = true
+	}
+	conn := d.conn
+	d.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return conn.SendMessage(WebSocketAPIRequest{Op: Subscribe, Channel: channel, Markets: markets})
+}
+
+// Unsubscribe removes markets from the subscription to channel, or the whole subscription if markets is empty
+func (d *Dispatcher) Unsubscribe(channel ChannelType, markets ...models.Market) error {
+	d.subscribing.Lock()
+	defer d.subscribing.Unlock()
+
+	d.mu.Lock()
+	for _, market := range markets {
+		delete(d.subscriptions[channel], market)
+	}
+	// An empty set would be restored as a subscription to the whole channel
+	if len(markets) == 0 || len(d.subscriptions[channel]) == 0 {
+		delete(d.subscriptions, channel)
+	}
+	conn := d.conn
+	d.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return conn.SendMessage(WebSocketAPIRequest{Op: Unsubscribe, Channel: channel, Markets: markets})
+}
+
+// Send writes a message on the current connection
+func (d *Dispatcher) Send(req WebSocketAPIRequest) error {
+	d.mu.Lock()
+	conn := d.conn
+	d.mu.Unlock()
+
+	if conn == nil {
+		return errors.New("dispatcher is not connected")
+	}
+	return conn.SendMessage(req)
+}
+
+// Run connects and dispatches messages until ctx is done, reconnecting with exponential backoff if the connection
+// drops. It returns an error if the first connection attempt fails.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	conn, err := d.connect()
+	if err != nil {
+		return err
+	}
+
+	backoff := time.Second
+	for {
+		d.serve(ctx, conn)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+
+			d.logger.Info("websocket reconnecting")
+			conn, err = d.connect()
+			if err == nil {
+				d.client.getMetrics().IncWebsocketReconnects()
+				backoff = time.Second
+				break
+			}
+			d.logger.Warn("websocket reconnect failed", "error", err, "retryIn", backoff)
+			backoff = min(backoff*2, time.Minute)
+		}
+	}
+}
+
+// connect opens a connection and restores every subscription on it. Subscribe and Unsubscribe wait until it is
+// done, and Send and dispatch don't wait for it at all.
+func (d *Dispatcher) connect() (*WebsocketConn, error) {
+	conn, err := d.client.NewWebsocketConnection()
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(3 * d.PingInterval)
+
+	d.subscribing.Lock()
+	defer d.subscribing.Unlock()
+
+	d.mu.Lock()
+	var reqs []WebSocketAPIRequest
+	for channel, markets := range d.subscriptions {
+		req := WebSocketAPIRequest{Op: Subscribe, Channel: channel}
+		// A subscription to the whole channel is sent without markets
+		if !markets[""] {
+			for market := range markets {
+				req.Markets = append(req.Markets, market)
+			}
+		}
+		reqs = append(reqs, req)
+	}
+	d.mu.Unlock()
+
+	for _, req := range reqs {
+		if err := conn.SendMessage(req); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to restore subscription to %s: %w", req.Channel, err)
+		}
+	}
+
+	d.mu.Lock()
+	d.conn = conn
+	d.mu.Unlock()
+	return conn, nil
+}
+
+// serve reads from conn until it fails or ctx is done
+func (d *Dispatcher) serve(ctx context.Context, conn *WebsocketConn) {
+	d.notifyConnection(true)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(d.PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				_ = conn.WriteCloseMessage()
+				conn.Close()
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := conn.SendMessage(WebSocketAPIRequest{Op: Ping}); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	defer func() {
+		d.mu.Lock()
+		d.conn = nil
+		d.mu.Unlock()
+		conn.Close()
+		d.notifyConnection(false)
+	}()
+
+	for {
+		res, err := conn.ReadMessage()
+		// A message that can't be decoded is logged by ReadMessage and skipped: only transport errors reconnect
+		if errors.Is(err, ErrUndecodableMessage) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		d.dispatch(res)
+	}
+}
+
+func (d *Dispatcher) dispatch(res *WebSocketAPIResponse) {
+	d.mu.Lock()
+	handlers := d.handlers[res.Channel]
+	onMessage := d.onMessage
+	d.mu.Unlock()
+
+	if res.Type != Update {
+		for _, fn := range onMessage {
+			fn(res)
+		}
+		return
+	}
+
+	for _, event := range splitUpdate(res) {
+		for _, reg := range handlers {
+			if reg.market == "" || reg.market == event.Market {
+				reg.queue.push(event)
+			}
+		}
+	}
+}
+
+// splitUpdate turns an update into one event per element, attributed to the element's market
+func splitUpdate(res *WebSocketAPIResponse) []Event {
+	var items []any
+	switch data := res.Data.(type) {
+	case []*models.ApiBookSnapshot:
+		for _, item := range data {
+			items = append(items, item)
+		}
+	case []*models.ApiFill:
+		for _, item := range data {
+			items = append(items, item)
+		}
+	case []*models.ApiPosition:
+		for _, item := range data {
+			items = append(items, item)
+		}
+	case []*models.GetMarkPriceRes:
+		for _, item := range data {
+			items = append(items, item)
+		}
+	default:
+		return []Event{{Channel: res.Channel, Data: res.Data}}
+	}
+
+	events := make([]Event, 0, len(items))
+	for _, item := range items {
+		events = append(events, Event{Channel: res.Channel, Market: marketOf(item), Data: item})
+	}
+	return events
+}
+
+func marketOf(item any) models.Market {
+	switch item := item.(type) {
+	case *models.ApiBookSnapshot:
+		return item.Market
+	case *models.ApiFill:
+		return item.Market
+	case *models.ApiPosition:
+		return item.Market
+	case *models.GetMarkPriceRes:
+		return item.Market
+	default:
+		return ""
+	}
+}
+
+// handlerQueue buffers events for one handler according to its backpressure policy
+type handlerQueue struct {
+	policy BackpressurePolicy
+	size   int
+
+	mu     sync.Mutex
+	events []Event
+	// ConflateLatest keeps the latest event per market, delivered in the order markets were first queued
+	latest  map[models.Market]Event
+	order   []models.Market
+	closed  bool
+	ready   chan struct{}
+	space   chan struct{}
+	dropped atomic.Uint64
+}
+
+func newHandlerQueue(opts HandlerOptions) *handlerQueue {
+	return &handlerQueue{
+		policy: opts.Policy,
+		size:   opts.QueueSize,
+		latest: map[models.Market]Event{},
+		ready:  make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+	}
+}
+
+func (q *handlerQueue) push(event Event) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+
+		switch {
+		case q.policy == ConflateLatest:
+			if _, ok := q.latest[event.Market]; ok {
+				q.dropped.Add(1)
+			} else {
+				q.order = append(q.order, event.Market)
+			}
+			q.latest[event.Market] = event
+		case len(q.events) < q.size:
+			q.events = append(q.events, event)
+		case q.policy == DropOldest:
+			q.events = append(q.events[1:], event)
+			q.dropped.Add(1)
+		default:
+			// Block until the handler makes room
+			q.mu.Unlock()
+			<-q.space
+			continue
+		}
+		signal(q.ready)
+		q.mu.Unlock()
+		return
+	}
+}
+
+// pop waits for the next event. It returns false once the queue is closed.
+func (q *handlerQueue) pop() (Event, bool) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return Event{}, false
+		}
+		if len(q.order) > 0 {
+			market := q.order[0]
+			q.order = q.order[1:]
+			event := q.latest[market]
+			delete(q.latest, market)
+			q.mu.Unlock()
+			return event, true
+		}
+		if len(q.events) > 0 {
+			event := q.events[0]
+			q.events = q.events[1:]
+			signal(q.space)
+			q.mu.Unlock()
+			return event, true
+		}
+		q.mu.Unlock()
+		<-q.ready
+	}
+}
+
+func (q *handlerQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.events = nil
+	// Signals are only sent while holding mu, so closing here can't race with them
+	close(q.ready)
+	close(q.space)
+}
+
+// signal wakes one waiter on ch without blocking. Callers hold the queue's mu.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
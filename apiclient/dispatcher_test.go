@@ -0,0 +1,254 @@
+package apiclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+)
+
+func marketEvent(market models.Market, n int) Event {
+	return Event{Channel: TopOfBooksSpot(), Market: market, Data: n}
+}
+
+// drain pops every queued event without blocking
+func drain(q *handlerQueue) []Event {
+	var events []Event
+	for {
+		q.mu.Lock()
+		empty := len(q.events) == 0 && len(q.order) == 0
+		q.mu.Unlock()
+		if empty {
+			return events
+		}
+		event, _ := q.pop()
+		events = append(events, event)
+	}
+}
+
+func TestHandlerQueueDropOldest(t *testing.T) {
+	q := newHandlerQueue(HandlerOptions{Policy: DropOldest, QueueSize: 2})
+	for i := 1; i <= 4; i++ {
+		q.push(marketEvent("AVAX-USDC", i))
+	}
+
+	events := drain(q)
+	if len(events) != 2 || events[0].Data != 3 || events[1].Data != 4 {
+		t.Fatalf("events = %v, want the newest two", events)
+	}
+	if n := q.dropped.Load(); n != 2 {
+		t.Fatalf("dropped %d, want 2", n)
+	}
+}
+
+func TestHandlerQueueConflateLatest(t *testing.T) {
+	q := newHandlerQueue(HandlerOptions{Policy: ConflateLatest, QueueSize: 1})
+	q.push(marketEvent("AVAX-USDC", 1))
+	q.push(marketEvent("BTC-USDC", 2))
+	q.push(marketEvent("AVAX-USDC", 3))
+
+	events := drain(q)
+	if len(events) != 2 || events[0].Market != "AVAX-USDC" || events[0].Data != 3 || events[1].Data != 2 {
+		t.Fatalf("events = %v, want the latest per market in first-queued order", events)
+	}
+	if n := q.dropped.Load(); n != 1 {
+		t.Fatalf("dropped %d, want 1", n)
+	}
+}
+
+func TestHandlerQueueBlock(t *testing.T) {
+	q := newHandlerQueue(HandlerOptions{Policy: Block, QueueSize: 1})
+	q.push(marketEvent("AVAX-USDC", 1))
+
+	pushed := make(chan struct{})
+	go func() {
+		q.push(marketEvent("AVAX-USDC", 2))
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("push into a full queue did not block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if event, _ := q.pop(); event.Data != 1 {
+		t.Fatalf("popped %v, want 1", event.Data)
+	}
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("push did not resume after the handler made room")
+	}
+	if event, _ := q.pop(); event.Data != 2 {
+		t.Fatalf("popped %v, want 2", event.Data)
+	}
+	if n := q.dropped.Load(); n != 0 {
+		t.Fatalf("dropped %d, want 0", n)
+	}
+}
+
+func TestHandlerQueueCloseReleasesWaiters(t *testing.T) {
+	q := newHandlerQueue(HandlerOptions{Policy: Block, QueueSize: 1})
+	q.push(marketEvent("AVAX-USDC", 1))
+
+	done := make(chan struct{})
+	go func() {
+		q.push(marketEvent("AVAX-USDC", 2))
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("close did not release a blocked push")
+	}
+	if _, ok := q.pop(); ok {
+		t.Fatal("pop returned an event from a closed queue")
+	}
+}
+
+func TestDispatcherRoutesByChannelAndMarket(t *testing.T) {
+	d := NewApiClient("https://localhost").NewDispatcher()
+
+	avax := make(chan Event, 10)
+	all := make(chan Event, 10)
+	fills := make(chan Event, 10)
+	d.Handle(TopOfBooksSpot(), "AVAX-USDC", HandlerOptions{}, func(e Event) { avax <- e })
+	d.Handle(TopOfBooksSpot(), "", HandlerOptions{}, func(e Event) { all <- e })
+	removed := d.Handle(FillsSpot(), "", HandlerOptions{}, func(e Event) { fills <- e })
+	removed.Remove()
+
+	var messages []*WebSocketAPIResponse
+	d.OnMessage(func(res *WebSocketAPIResponse) { messages = append(messages, res) })
+
+	d.dispatch(&WebSocketAPIResponse{Type: Update, Channel: TopOfBooksSpot(), Data: []*models.ApiBookSnapshot{
+		{Market: "AVAX-USDC"}, {Market: "BTC-USDC"},
+	}})
+	d.dispatch(&WebSocketAPIResponse{Type: Update, Channel: FillsSpot(), Data: []*models.ApiFill{{Market: "AVAX-USDC"}}})
+	d.dispatch(&WebSocketAPIResponse{Type: Subscribed, Channel: TopOfBooksSpot()})
+
+	receive := func(ch chan Event) models.Market {
+		t.Helper()
+		select {
+		case e := <-ch:
+			return e.Market
+		case <-time.After(time.Second):
+			t.Fatal("no event delivered")
+			return ""
+		}
+	}
+	if got := receive(avax); got != "AVAX-USDC" {
+		t.Fatalf("market handler got %s", got)
+	}
+	if got1, got2 := receive(all), receive(all); got1 != "AVAX-USDC" || got2 != "BTC-USDC" {
+		t.Fatalf("channel handler got %s, %s", got1, got2)
+	}
+
+	select {
+	case e := <-avax:
+		t.Fatalf("market handler got an event for %s", e.Market)
+	case e := <-fills:
+		t.Fatalf("removed handler got an event for %s", e.Market)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if len(messages) != 1 || messages[0].Type != Subscribed {
+		t.Fatalf("OnMessage got %v, want the subscription confirmation", messages)
+	}
+}
+
+func TestDispatcherRestoresSubscriptionsAfterReconnecting(t *testing.T) {
+	ws := newFakeWebsocket(t)
+	d := NewApiClient(ws.srv.URL).WithApiKey("key", "secret").NewDispatcher()
+	if err := d.Subscribe(TopOfBooksSpot(), "AVAX-USDC"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx) }()
+
+	ws.next(t, Login, "", time.Second)
+	if req := ws.next(t, Subscribe, TopOfBooksSpot(), time.Second); len(req.Markets) != 1 || req.Markets[0] != "AVAX-USDC" {
+		t.Fatalf("subscribed to %v", req.Markets)
+	}
+
+	ws.dropConnections()
+	ws.next(t, Login, "", 5*time.Second)
+	if req := ws.next(t, Subscribe, TopOfBooksSpot(), time.Second); len(req.Markets) != 1 || req.Markets[0] != "AVAX-USDC" {
+		t.Fatalf("resubscribed to %v", req.Markets)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after the context was cancelled")
+	}
+}
+
+func TestDispatcherUnsubscribeIsNotRestoredAsTheWholeChannel(t *testing.T) {
+	ws := newFakeWebsocket(t)
+	d := NewApiClient(ws.srv.URL).WithApiKey("key", "secret").NewDispatcher()
+	d.Subscribe(TopOfBooksSpot(), "AVAX-USDC")
+	d.Subscribe(FillsSpot())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+	ws.next(t, Login, "", time.Second)
+	ws.next(t, Subscribe, TopOfBooksSpot(), time.Second)
+
+	if err := d.Unsubscribe(TopOfBooksSpot(), "AVAX-USDC"); err != nil {
+		t.Fatal(err)
+	}
+	if req := ws.next(t, Unsubscribe, TopOfBooksSpot(), time.Second); len(req.Markets) != 1 || req.Markets[0] != "AVAX-USDC" {
+		t.Fatalf("unsubscribed from %v", req.Markets)
+	}
+
+	ws.dropConnections()
+	ws.next(t, Login, "", 5*time.Second)
+	if req := ws.next(t, Subscribe, FillsSpot(), time.Second); len(req.Markets) != 0 {
+		t.Fatalf("resubscribed to fills for %v, want the whole channel", req.Markets)
+	}
+	select {
+	case req := <-ws.recv:
+		if req.Op == Subscribe {
+			t.Fatalf("resubscribed to %s %v after unsubscribing from its last market", req.Channel, req.Markets)
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDispatcherSkipsUndecodableUpdates(t *testing.T) {
+	ws := newFakeWebsocket(t)
+	d := NewApiClient(ws.srv.URL).WithApiKey("key", "secret").NewDispatcher()
+	fills := make(chan Event, 10)
+	d.Handle(FillsSpot(), "", HandlerOptions{}, func(e Event) { fills <- e })
+	d.Subscribe(FillsSpot())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+	ws.next(t, Login, "", time.Second)
+	ws.next(t, Subscribe, FillsSpot(), time.Second)
+
+	ws.broadcast(map[string]any{"type": string(Update), "channel": FillsSpot(), "data": "not fills"})
+	ws.broadcast(map[string]any{"type": string(Update), "channel": FillsSpot(), "data": []models.ApiFill{{Market: "AVAX-USDC"}}})
+	select {
+	case e := <-fills:
+		if e.Market != "AVAX-USDC" {
+			t.Fatalf("got a fill for %s", e.Market)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the update after an undecodable one wasn't delivered")
+	}
+	select {
+	case req := <-ws.recv:
+		t.Fatalf("sent %s %s after an undecodable update, want the connection kept", req.Op, req.Channel)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
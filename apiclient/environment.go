@@ -0,0 +1,170 @@
+package apiclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// TLSPolicy controls certificate verification for an Environment
+type TLSPolicy string
+
+const (
+	// Always verify the server certificate. This is the default.
+	TLSPolicyVerify TLSPolicy = "verify"
+	// Never verify the server certificate. Only use against local stacks.
+	TLSPolicyInsecure TLSPolicy = "insecure"
+)
+
+// Environment is a named set of endpoints that a client can target
+type Environment struct {
+	Name        string `json:"name"`
+	ApiEndpoint string `json:"apiEndpoint"`
+
+	// Defaults to the ApiEndpoint with a ws or wss scheme and /ws appended to its path
+	WebsocketURL string `json:"websocketUrl,omitempty"`
+
+	TLSPolicy TLSPolicy `json:"tlsPolicy,omitempty"`
+
+	// Path to a PEM file of root CAs to trust in addition to the system pool
+	CABundle string `json:"caBundle,omitempty"`
+
+	// Paths to a PEM certificate and key to present for mutual TLS
+	ClientCertificate string `json:"clientCertificate,omitempty"`
+	ClientKey         string `json:"clientKey,omitempty"`
+}
+
+// EnvironmentsFileEnvVar names a JSON file of environments loaded when an environment is looked up. Loading is
+// retried on each lookup until it succeeds.
+const EnvironmentsFileEnvVar = "ENCLAVE_ENVIRONMENTS"
+
+var (
+	environmentsMu sync.RWMutex
+	environments   = map[string]Environment{
+		"sandbox": {Name: "sandbox", ApiEndpoint: "https://api-sandbox.enclave.market", TLSPolicy: TLSPolicyVerify},
+		"prod":    {Name: "prod", ApiEndpoint: "https://api.enclave.market", TLSPolicy: TLSPolicyVerify},
+	}
+	loadEnvironmentsFromEnvMu sync.Mutex
+	loadedEnvironmentsFromEnv bool
+)
+
+// RegisterEnvironment adds env to the environments known to NewApiClientFromEnv, replacing any with the same name.
+func RegisterEnvironment(env Environment) error {
+	if env.Name == "" {
+		return fmt.Errorf("environment has no name")
+	}
+	if env.ApiEndpoint == "" {
+		return fmt.Errorf("environment %s has no api endpoint", env.Name)
+	}
+	switch env.TLSPolicy {
+	case "", TLSPolicyVerify, TLSPolicyInsecure:
+	default:
+		return fmt.Errorf("environment %s has unknown tls policy: %s", env.Name, env.TLSPolicy)
+	}
+
+	environmentsMu.Lock()
+	defer environmentsMu.Unlock()
+	environments[strings.ToLower(env.Name)] = env
+	return nil
+}
+
+// LoadEnvironments registers every environment in a JSON file that maps names to environments:
+//
+//	{
+//	  "local":   {"apiEndpoint": "https://localhost:8443", "tlsPolicy": "insecure"},
+//	  "staging": {"apiEndpoint": "https://api.staging.example", "caBundle": "/etc/ssl/staging-ca.pem"}
+//	}
+func LoadEnvironments(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read environments file: %w", err)
+	}
+
+	var envs map[string]Environment
+	if err := json.Unmarshal(data, &envs); err != nil {
+		return fmt.Errorf("failed to parse environments file %s: %w", path, err)
+	}
+
+	for name, env := range envs {
+		env.Name = name
+		if err := RegisterEnvironment(env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnvironmentsFileError loads the file named by $ENCLAVE_ENVIRONMENTS if it hasn't loaded yet, and returns the
+// error if it fails. It is nil once the file has loaded or if the variable isn't set.
+func EnvironmentsFileError() error {
+	loadEnvironmentsFromEnvMu.Lock()
+	defer loadEnvironmentsFromEnvMu.Unlock()
+	if loadedEnvironmentsFromEnv {
+		return nil
+	}
+	path := os.Getenv(EnvironmentsFileEnvVar)
+	if path == "" {
+		return nil
+	}
+	if err := LoadEnvironments(path); err != nil {
+		return err
+	}
+	loadedEnvironmentsFromEnv = true
+	return nil
+}
+
+// LookupEnvironment returns the environment registered under name. The built-in and registered environments can be
+// looked up even if the file named by $ENCLAVE_ENVIRONMENTS fails to load; the load error is returned only for
+// names that aren't found, and by EnvironmentsFileError.
+func LookupEnvironment(name string) (Environment, error) {
+	loadErr := EnvironmentsFileError()
+
+	environmentsMu.RLock()
+	defer environmentsMu.RUnlock()
+	env, ok := environments[strings.ToLower(name)]
+	if !ok {
+		if loadErr != nil {
+			return Environment{}, fmt.Errorf("unknown env: %s: %w", name, loadErr)
+		}
+		return Environment{}, fmt.Errorf("unknown env: %s", name)
+	}
+	return env, nil
+}
+
+// TLSConfig builds the TLS configuration for the environment. It returns nil when the defaults apply.
+func (env Environment) TLSConfig() (*tls.Config, error) {
+	if env.TLSPolicy != TLSPolicyInsecure && env.CABundle == "" && env.ClientCertificate == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{InsecureSkipVerify: env.TLSPolicy == TLSPolicyInsecure}
+
+	if env.CABundle != "" {
+		pem, err := os.ReadFile(env.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca bundle for environment %s: %w", env.Name, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca bundle %s", env.CABundle)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if env.ClientCertificate != "" {
+		cert, err := tls.LoadX509KeyPair(env.ClientCertificate, env.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate for environment %s: %w", env.Name, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,36 @@
+package apiclient
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupEnvironmentRetriesEnvironmentsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "environments.json")
+	t.Setenv(EnvironmentsFileEnvVar, path)
+
+	if _, err := LookupEnvironment("sandbox"); err != nil {
+		t.Fatalf("built-in environment with a missing file: %v", err)
+	}
+	if EnvironmentsFileError() == nil {
+		t.Fatal("expected the missing file to be reported")
+	}
+	if _, err := LookupEnvironment("file-env"); err == nil {
+		t.Fatal("expected an error for an environment that isn't loaded")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"file-env": {"apiEndpoint": "https://localhost:8443"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env, err := LookupEnvironment("file-env")
+	if err != nil {
+		t.Fatalf("environment from a file that loaded on retry: %v", err)
+	}
+	if env.ApiEndpoint != "https://localhost:8443" {
+		t.Fatalf("api endpoint %s", env.ApiEndpoint)
+	}
+	if err := EnvironmentsFileError(); err != nil {
+		t.Fatal(err)
+	}
+}
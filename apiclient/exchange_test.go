@@ -0,0 +1,232 @@
+package apiclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+)
+
+// fakeRequest is a REST call received by a fakeExchange
+type fakeRequest struct {
+	Method string
+	Path   string
+	// The path as sent, before unescaping
+	RawPath string
+	Query   string
+	Header  http.Header
+	Body    []byte
+}
+
+// decode unmarshals the request body into v
+func (r fakeRequest) decode(t *testing.T, v any) {
+	t.Helper()
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		t.Fatalf("bad request body %s: %v", r.Body, err)
+	}
+}
+
+type fakeHandler func(req fakeRequest) (int, any)
+
+// fakeExchange serves canned REST responses. Routes are "METHOD /path", and a path ending in * matches any path
+// with that prefix. Unrouted calls get a 404.
+type fakeExchange struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu     sync.Mutex
+	routes map[string]fakeHandler
+	calls  []fakeRequest
+}
+
+func newFakeExchange(t *testing.T) *fakeExchange {
+	f := &fakeExchange{t: t, routes: map[string]fakeHandler{}}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeExchange) client() *ApiClient {
+	return NewApiClient(f.srv.URL).WithApiKey("key", "secret")
+}
+
+func (f *fakeExchange) handle(route string, fn fakeHandler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.routes[route] = fn
+}
+
+func (f *fakeExchange) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	req := fakeRequest{Method: r.Method, Path: r.URL.Path, RawPath: r.URL.EscapedPath(), Query: r.URL.RawQuery, Header: r.Header, Body: body}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, req)
+	fn := f.route(req)
+	f.mu.Unlock()
+
+	status, res := http.StatusNotFound, any(models.GenericResponse[any]{Error: "not found"})
+	if fn != nil {
+		status, res = fn(req)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// route finds the handler for req, called with f.mu held
+func (f *fakeExchange) route(req fakeRequest) fakeHandler {
+	key := req.Method + " " + req.Path
+	if fn, ok := f.routes[key]; ok {
+		return fn
+	}
+	var best fakeHandler
+	bestLen := -1
+	for route, fn := range f.routes {
+		prefix, ok := strings.CutSuffix(route, "*")
+		if ok && strings.HasPrefix(key, prefix) && len(prefix) > bestLen {
+			best, bestLen = fn, len(prefix)
+		}
+	}
+	return best
+}
+
+// requests returns the calls received for route, which may end in * like a route
+func (f *fakeExchange) requests(route string) []fakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prefix, wildcard := strings.CutSuffix(route, "*")
+	var matched []fakeRequest
+	for _, req := range f.calls {
+		key := req.Method + " " + req.Path
+		if key == route || (wildcard && strings.HasPrefix(key, prefix)) {
+			matched = append(matched, req)
+		}
+	}
+	return matched
+}
+
+func ok[T any](result T) (int, any) {
+	return http.StatusOK, models.GenericResponse[T]{Success: true, Result: result}
+}
+
+func notFound() (int, any) {
+	return http.StatusNotFound, models.GenericResponse[any]{Error: "not found"}
+}
+
+// acceptSpotOrders accepts every spot order as open, numbering their order IDs
+func acceptSpotOrders(t *testing.T, ex *fakeExchange) {
+	var mu sync.Mutex
+	n := 0
+	ex.handle("POST "+models.V1SpotOrdersPath, func(req fakeRequest) (int, any) {
+		var add models.AddOrderReq
+		req.decode(t, &add)
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		return ok(models.ApiOrder{OrderID: models.OrderID(fmt.Sprintf("o%d", n)), ClientOrderID: add.ClientOrderID,
+			Market: add.Market, Side: add.Side, OrderQuantity: add.Size, Type: add.Type, State: models.Open})
+	})
+}
+
+// book returns a snapshot of market with one bid and one ask level
+func book(market models.Market, bid, ask string) *models.ApiBookSnapshot {
+	return &models.ApiBookSnapshot{
+		Market: market,
+		Bids:   []models.BookLevel{{Price: decimal.RequireFromString(bid)}},
+		Asks:   []models.BookLevel{{Price: decimal.RequireFromString(ask)}},
+	}
+}
+
+// fakeWebsocket accepts websocket connections, confirms logins and subscriptions, and records every request it
+// receives on recv
+type fakeWebsocket struct {
+	srv  *httptest.Server
+	recv chan WebSocketAPIRequest
+
+	mu    sync.Mutex
+	conns []*websocket.Conn
+}
+
+func newFakeWebsocket(t *testing.T) *fakeWebsocket {
+	f := &fakeWebsocket{recv: make(chan WebSocketAPIRequest, 100)}
+	upgrader := websocket.Upgrader{}
+	f.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns = append(f.conns, conn)
+		f.mu.Unlock()
+		defer conn.Close()
+
+		for {
+			var req WebSocketAPIRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			f.recv <- req
+			switch req.Op {
+			case Login:
+				f.write(conn, map[string]string{"type": string(LoggedIn)})
+			case Subscribe:
+				f.write(conn, map[string]any{"type": string(Subscribed), "channel": req.Channel, "data": req})
+			}
+		}
+	}))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// write sends v on conn, serialized with every other write
+func (f *fakeWebsocket) write(conn *websocket.Conn, v any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	conn.WriteJSON(v)
+}
+
+// broadcast sends v on every open connection
+func (f *fakeWebsocket) broadcast(v any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.WriteJSON(v)
+	}
+}
+
+// dropConnections closes every open connection from the server side
+func (f *fakeWebsocket) dropConnections() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+// next waits for the next request matching op and channel, skipping any others
+func (f *fakeWebsocket) next(t *testing.T, op WSRequestType, channel ChannelType, within time.Duration) WebSocketAPIRequest {
+	t.Helper()
+	deadline := time.After(within)
+	for {
+		select {
+		case req := <-f.recv:
+			if req.Op == op && req.Channel == channel {
+				return req
+			}
+		case <-deadline:
+			t.Fatalf("no %s %s within %s", op, channel, within)
+			return WebSocketAPIRequest{}
+		}
+	}
+}
@@ -0,0 +1,475 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+// ParentOrder is an order too large to send at once, executed by an algorithm as a series of child orders
+type ParentOrder struct {
+	Product Product
+	Market  models.Market
+	Side    models.BidAsk
+
+	// Total size in base currency
+	Size decimal.Decimal
+
+	// Time over which the order is executed
+	Duration time.Duration
+
+	// Child orders are immediate-or-cancel limit orders at this price, or market orders if it is zero
+	LimitPrice decimal.Decimal
+}
+
+type ExecutionOptions struct {
+	// TWAP: number of equal child orders. Defaults to one per ten seconds of Duration.
+	Slices int
+
+	// Fraction of the volume passed to ObserveVolume since the last child order that the next may trade, e.g. 0.1.
+	// Required for VWAP, which trades exactly this fraction; an optional cap for TWAP.
+	ParticipationRate decimal.Decimal
+
+	// VWAP: time between child orders. Defaults to ten seconds.
+	Interval time.Duration
+
+	// Largest child order. Zero means no cap.
+	MaxChildSize decimal.Decimal
+
+	// Child sizes are rounded down to a multiple of this, e.g. the market's BaseIncrement
+	SizeIncrement decimal.Decimal
+}
+
+const defaultExecutionInterval = 10 * time.Second
+
+type ExecutionState int
+
+const (
+	ExecutionRunning ExecutionState = iota
+	ExecutionPaused
+	ExecutionCancelled
+	ExecutionDone
+)
+
+func (s ExecutionState) String() string {
+	switch s {
+	case ExecutionRunning:
+		return "running"
+	case ExecutionPaused:
+		return "paused"
+	case ExecutionCancelled:
+		return "cancelled"
+	case ExecutionDone:
+		return "done"
+	default:
+		return "unknown"
+	}
+}
+
+// ExecutionReport summarizes the child orders of an execution. Each child's fills are the larger of those passed
+// to OnFill and those the exchange last reported for the order.
+type ExecutionReport struct {
+	Parent   ParentOrder
+	State    ExecutionState
+	Children []*models.ApiOrder
+	Errors   []error
+
+	Filled       decimal.Decimal
+	FilledCost   decimal.Decimal
+	Fees         decimal.Decimal
+	AveragePrice decimal.Decimal
+	Remaining    decimal.Decimal
+}
+
+// Execution is a running TWAP or VWAP algorithm
+type Execution struct {
+	client *ApiClient
+	parent ParentOrder
+	opts   ExecutionOptions
+
+	// nextSize returns the size of the child order for tick, before caps
+	nextSize func(e *Execution, tick int) decimal.Decimal
+	interval time.Duration
+	ticks    int
+
+	mu       sync.Mutex
+	state    ExecutionState
+	report   ExecutionReport
+	children map[models.OrderID]*executionChild
+	order    []models.OrderID
+	volume   decimal.Decimal
+	cancel   chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// executionChild is one child order of an execution
+type executionChild struct {
+	size decimal.Decimal
+
+	// The order as last acknowledged or looked up, nil until then
+	order *models.ApiOrder
+
+	// Totals of the fills passed to OnFill
+	filled decimal.Decimal
+	cost   decimal.Decimal
+	fees   decimal.Decimal
+
+	// The order is in a final state, or was never placed, so it won't fill any more
+	resolved bool
+}
+
+// fills returns the child's filled quantity, cost and fees
+func (c *executionChild) fills() (decimal.Decimal, decimal.Decimal, decimal.Decimal) {
+	if c.order != nil && c.order.FilledQuantity.GreaterThanOrEqual(c.filled) {
+		return c.order.FilledQuantity, c.order.FilledCost, c.order.Fee
+	}
+	return c.filled, c.cost, c.fees
+}
+
+// inFlight returns how much more of the child may still fill
+func (c *executionChild) inFlight() decimal.Decimal {
+	if c.resolved {
+		return decimal.Zero
+	}
+	filled, _, _ := c.fills()
+	return decimal.Max(c.size.Sub(filled), decimal.Zero)
+}
+
+// StartTWAP executes parent in equal slices spread evenly over its duration. Quantity left unfilled by a slice is
+// added to the next one once the slice is known to be done, so slices that are still unresolved are never sent
+// again.
+func (c *ApiClient) StartTWAP(ctx context.Context, parent ParentOrder, opts ExecutionOptions) (*Execution, error) {
+	if err := validateParent(parent); err != nil {
+		return nil, err
+	}
+	if opts.Slices <= 0 {
+		opts.Slices = max(int(parent.Duration/defaultExecutionInterval), 1)
+	}
+
+	e := newExecution(c, parent, opts)
+	e.ticks = opts.Slices
+	e.interval = parent.Duration / time.Duration(opts.Slices)
+	e.nextSize = func(e *Execution, tick int) decimal.Decimal {
+		target := parent.Size.Mul(decimal.NewFromInt(int64(tick + 1))).Div(decimal.NewFromInt(int64(opts.Slices)))
+		return target.Sub(e.report.Filled).Sub(e.inFlight())
+	}
+	go e.run(ctx)
+	return e, nil
+}
+
+// StartVWAP executes parent in proportion to market volume, trading ParticipationRate of the volume passed to
+// ObserveVolume in each interval. Whatever is left when the duration ends is reported as Remaining.
+func (c *ApiClient) StartVWAP(ctx context.Context, parent ParentOrder, opts ExecutionOptions) (*Execution, error) {
+	if err := validateParent(parent); err != nil {
+		return nil, err
+	}
+	if !opts.ParticipationRate.IsPositive() || opts.ParticipationRate.GreaterThan(decimal.NewFromInt(1)) {
+		return nil, fmt.Errorf("bad participation rate %s: must be above 0 and at most 1", opts.ParticipationRate)
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultExecutionInterval
+	}
+
+	e := newExecution(c, parent, opts)
+	e.interval = opts.Interval
+	e.ticks = max(int(parent.Duration/opts.Interval), 1)
+	e.nextSize = func(e *Execution, tick int) decimal.Decimal {
+		return e.volume.Mul(opts.ParticipationRate)
+	}
+	go e.run(ctx)
+	return e, nil
+}
+
+func validateParent(parent ParentOrder) error {
+	if !parent.Size.IsPositive() {
+		return fmt.Errorf("bad parent order: size %s must be positive", parent.Size)
+	}
+	if parent.Duration <= 0 {
+		return fmt.Errorf("bad parent order: duration %s must be positive", parent.Duration)
+	}
+	return nil
+}
+
+func newExecution(client *ApiClient, parent ParentOrder, opts ExecutionOptions) *Execution {
+	return &Execution{
+		client:   client,
+		parent:   parent,
+		opts:     opts,
+		report:   ExecutionReport{Parent: parent, Remaining: parent.Size},
+		children: map[models.OrderID]*executionChild{},
+		cancel:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// ObserveVolume adds traded market volume, in base currency, for VWAP to follow
+func (e *Execution) ObserveVolume(size decimal.Decimal) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.volume = e.volume.Add(size)
+}
+
+// OnFill counts fills of the child orders, which are told apart from other orders by their client order IDs
+func (e *Execution) OnFill(fill *models.ApiFill) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	child, ok := e.children[fill.ClientOrderID]
+	if !ok {
+		return
+	}
+	child.filled = child.filled.Add(fill.Size)
+	child.cost = child.cost.Add(fill.Cost)
+	child.fees = child.fees.Add(fill.Fee)
+	e.tally()
+}
+
+// HandleEvent passes fills from a dispatcher event to OnFill
+func (e *Execution) HandleEvent(event Event) {
+	if fill, ok := event.Data.(*models.ApiFill); ok {
+		e.OnFill(fill)
+	}
+}
+
+// Attach feeds the execution from d's fills channel for its market, which still needs to be subscribed
+func (e *Execution) Attach(d *Dispatcher) *HandlerRegistration {
+	channel := FillsSpot()
+	if e.parent.Product == ProductPerps {
+		channel = FillsPerps()
+	}
+	return d.Handle(channel, e.parent.Market, HandlerOptions{Policy: Block}, e.HandleEvent)
+}
+
+// Pause stops sending child orders until Resume. The schedule stops with them: intervals that pass while paused
+// don't count, so the remaining slices are sent after Resume and the execution runs for longer than its Duration.
+// Volume observed while paused is not traded.
+func (e *Execution) Pause() {
+	e.setState(ExecutionRunning, ExecutionPaused)
+}
+
+func (e *Execution) Resume() {
+	e.setState(ExecutionPaused, ExecutionRunning)
+}
+
+func (e *Execution) setState(from, to ExecutionState) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.state == from {
+		e.state = to
+	}
+}
+
+// Cancel stops the execution. Child orders already sent are not cancelled.
+func (e *Execution) Cancel() {
+	e.stopOnce.Do(func() { close(e.cancel) })
+}
+
+// Wait blocks until the execution has finished and returns its report
+func (e *Execution) Wait() *ExecutionReport {
+	<-e.done
+	return e.Report()
+}
+
+// Report returns the progress so far
+func (e *Execution) Report() *ExecutionReport {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	report := e.report
+	report.State = e.state
+	report.Children = append([]*models.ApiOrder(nil), e.report.Children...)
+	report.Errors = append([]error(nil), e.report.Errors...)
+	if report.Filled.IsPositive() {
+		report.AveragePrice = report.FilledCost.Div(report.Filled)
+	}
+	return &report
+}
+
+func (e *Execution) run(ctx context.Context) {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for tick, first := 0, true; tick < e.ticks; first = false {
+		if !first {
+			select {
+			case <-ctx.Done():
+				e.finish(ExecutionCancelled, ctx.Err())
+				return
+			case <-e.cancel:
+				e.resolve(ctx)
+				e.finish(ExecutionCancelled, nil)
+				return
+			case <-ticker.C:
+			}
+		}
+
+		e.resolve(ctx)
+		if e.paused() {
+			continue
+		}
+		req, ok := e.childOrder(tick)
+		tick++
+		if !ok {
+			continue
+		}
+		order, err := e.send(ctx, req)
+		e.record(req, order, err)
+		if e.Report().Remaining.Sign() <= 0 {
+			break
+		}
+	}
+	e.resolve(ctx)
+	e.finish(ExecutionDone, nil)
+}
+
+// resolve looks up the child orders that may still fill, so their final fills are known before the next child
+// order is sized. A child that can't be looked up stays in flight.
+func (e *Execution) resolve(ctx context.Context) {
+	e.mu.Lock()
+	var ids []models.OrderID
+	for _, id := range e.order {
+		if !e.children[id].resolved {
+			ids = append(ids, id)
+		}
+	}
+	e.mu.Unlock()
+
+	client := e.client.WithContext(ctx)
+	for _, id := range ids {
+		order, err := client.getOrderByClientID(e.parent.Product, id)
+		if err != nil && !IsNotFound(err) {
+			e.mu.Lock()
+			e.report.Errors = append(e.report.Errors, err)
+			e.mu.Unlock()
+			continue
+		}
+
+		e.mu.Lock()
+		child := e.children[id]
+		if err == nil {
+			e.setOrder(child, order)
+		} else {
+			// Never placed, or no longer known to the exchange: only the fills already seen count
+			child.resolved = true
+		}
+		e.tally()
+		e.mu.Unlock()
+	}
+}
+
+// paused reports whether the execution is paused, and discards the volume observed while it is
+func (e *Execution) paused() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.state != ExecutionPaused {
+		return false
+	}
+	e.volume = decimal.Zero
+	return true
+}
+
+// childOrder returns the order to send for tick, if any
+func (e *Execution) childOrder(tick int) (models.AddOrderReq, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	size := decimal.Min(e.nextSize(e, tick), e.report.Remaining.Sub(e.inFlight()))
+	if e.opts.MaxChildSize.IsPositive() {
+		size = decimal.Min(size, e.opts.MaxChildSize)
+	}
+	if e.opts.ParticipationRate.IsPositive() {
+		size = decimal.Min(size, e.volume.Mul(e.opts.ParticipationRate))
+		e.volume = decimal.Zero
+	}
+	if e.opts.SizeIncrement.IsPositive() {
+		size = size.Div(e.opts.SizeIncrement).Floor().Mul(e.opts.SizeIncrement)
+	}
+	if !size.IsPositive() {
+		return models.AddOrderReq{}, false
+	}
+
+	req := models.AddOrderReq{
+		Side:          e.parent.Side,
+		Size:          size,
+		Market:        e.parent.Market,
+		ClientOrderID: NewClientOrderID("exec-"),
+		Type:          models.OrderTypeMarket,
+	}
+	if e.parent.LimitPrice.IsPositive() {
+		req.Type = models.OrderTypeLimit
+		req.Price = e.parent.LimitPrice
+		req.TimeInForce = models.OrderTimeInForceImmediateOrCancel
+	}
+	// Record the child first: its fills can arrive before it is acknowledged
+	e.children[req.ClientOrderID] = &executionChild{size: size}
+	e.order = append(e.order, req.ClientOrderID)
+	return req, true
+}
+
+// inFlight returns how much the child orders may still fill, called with e.mu held
+func (e *Execution) inFlight() decimal.Decimal {
+	total := decimal.Zero
+	for _, child := range e.children {
+		total = total.Add(child.inFlight())
+	}
+	return total
+}
+
+func (e *Execution) send(ctx context.Context, req models.AddOrderReq) (*models.ApiOrder, error) {
+	return e.client.WithContext(ctx).addOrder(e.parent.Product, req)
+}
+
+func (e *Execution) record(req models.AddOrderReq, order *models.ApiOrder, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	child := e.children[req.ClientOrderID]
+	switch {
+	case err == nil:
+		e.setOrder(child, order)
+	case definitelyNotPlaced(err):
+		child.resolved = true
+	}
+	// Otherwise the order may have been placed and stays in flight until it is looked up
+	if err != nil {
+		e.report.Errors = append(e.report.Errors, err)
+	}
+	e.tally()
+}
+
+// setOrder records the latest state of a child order, called with e.mu held
+func (e *Execution) setOrder(child *executionChild, order *models.ApiOrder) {
+	child.order = order
+	child.resolved = order.State != models.New && order.State != models.Open
+}
+
+// tally totals the children's fills into the report, called with e.mu held
+func (e *Execution) tally() {
+	e.report.Children = e.report.Children[:0]
+	e.report.Filled, e.report.FilledCost, e.report.Fees = decimal.Zero, decimal.Zero, decimal.Zero
+	for _, id := range e.order {
+		child := e.children[id]
+		if child.order != nil {
+			e.report.Children = append(e.report.Children, child.order)
+		}
+		filled, cost, fees := child.fills()
+		e.report.Filled = e.report.Filled.Add(filled)
+		e.report.FilledCost = e.report.FilledCost.Add(cost)
+		e.report.Fees = e.report.Fees.Add(fees)
+	}
+	e.report.Remaining = e.parent.Size.Sub(e.report.Filled)
+}
+
+func (e *Execution) finish(state ExecutionState, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.state = state
+	if err != nil && !errors.Is(err, context.Canceled) {
+		e.report.Errors = append(e.report.Errors, err)
+	}
+}
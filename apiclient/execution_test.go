@@ -0,0 +1,167 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestTWAPSizesChildrenFromUnresolvedQuantity(t *testing.T) {
+	tests := []struct {
+		name string
+		// Status and fraction of its size that a child reports as filled when it is looked up after being
+		// acknowledged unfilled. Any status but 200 means the lookup fails.
+		lookupStatus int
+		lookupFilled string
+		wantSizes    []string
+		wantFilled   string
+	}{
+		{name: "children fill after the ack", lookupStatus: http.StatusOK, lookupFilled: "1",
+			wantSizes: []string{"2.5", "2.5", "2.5", "2.5"}, wantFilled: "10"},
+		{name: "children don't fill", lookupStatus: http.StatusOK, lookupFilled: "0",
+			wantSizes: []string{"2.5", "5", "7.5", "10"}, wantFilled: "0"},
+		{name: "children half fill", lookupStatus: http.StatusOK, lookupFilled: "0.5",
+			wantSizes: []string{"2.5", "3.75", "4.375", "4.6875"}, wantFilled: "7.65625"},
+		{name: "children can't be looked up", lookupStatus: http.StatusInternalServerError,
+			wantSizes: []string{"2.5", "2.5", "2.5", "2.5"}, wantFilled: "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newFakeExchange(t)
+			var mu sync.Mutex
+			placed := map[models.OrderID]decimal.Decimal{}
+			ex.handle("POST "+models.V1SpotOrdersPath, func(req fakeRequest) (int, any) {
+				var add models.AddOrderReq
+				req.decode(t, &add)
+				mu.Lock()
+				defer mu.Unlock()
+				placed[add.ClientOrderID] = add.Size
+				// Immediate-or-cancel orders are acknowledged before they have matched
+				return ok(models.ApiOrder{ClientOrderID: add.ClientOrderID, OrderQuantity: add.Size, State: models.New})
+			})
+			prefix := models.V1SpotOrdersPath + "/" + models.V1SpotClientOrderIDPrefix
+			ex.handle("GET "+prefix+"*", func(req fakeRequest) (int, any) {
+				if tt.lookupStatus != http.StatusOK {
+					return tt.lookupStatus, models.GenericResponse[any]{}
+				}
+				id := models.OrderID(strings.TrimPrefix(req.Path, prefix))
+				mu.Lock()
+				defer mu.Unlock()
+				filled := placed[id].Mul(decimal.RequireFromString(tt.lookupFilled))
+				return ok(models.ApiOrder{ClientOrderID: id, State: models.Canceled, FilledQuantity: filled})
+			})
+
+			exec, err := ex.client().StartTWAP(context.Background(), ParentOrder{Product: ProductSpot, Market: "AVAX-USDC",
+				Side: models.Bid, Size: decimal.NewFromInt(10), Duration: 20 * time.Millisecond, LimitPrice: decimal.NewFromInt(40)},
+				ExecutionOptions{Slices: 4})
+			if err != nil {
+				t.Fatal(err)
+			}
+			report := exec.Wait()
+
+			requests := ex.requests("POST " + models.V1SpotOrdersPath)
+			if len(requests) != len(tt.wantSizes) {
+				t.Fatalf("sent %d children, want %d", len(requests), len(tt.wantSizes))
+			}
+			for i, req := range requests {
+				var add models.AddOrderReq
+				req.decode(t, &add)
+				if add.ClientOrderID == "" {
+					t.Fatalf("child %d has no client order ID", i)
+				}
+				if !add.Size.Equal(decimal.RequireFromString(tt.wantSizes[i])) {
+					t.Fatalf("child %d has size %s, want %s", i, add.Size, tt.wantSizes[i])
+				}
+			}
+			if !report.Filled.Equal(decimal.RequireFromString(tt.wantFilled)) {
+				t.Fatalf("filled %s, want %s", report.Filled, tt.wantFilled)
+			}
+		})
+	}
+}
+
+func TestExecutionOnFill(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("POST "+models.V1SpotOrdersPath, func(req fakeRequest) (int, any) {
+		var add models.AddOrderReq
+		req.decode(t, &add)
+		return ok(models.ApiOrder{ClientOrderID: add.ClientOrderID, State: models.New})
+	})
+	exec, err := ex.client().StartTWAP(context.Background(), ParentOrder{Product: ProductSpot, Market: "AVAX-USDC",
+		Side: models.Ask, Size: decimal.NewFromInt(4), Duration: time.Hour}, ExecutionOptions{Slices: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exec.Cancel()
+
+	var child models.AddOrderReq
+	for deadline := time.Now().Add(time.Second); ; {
+		if requests := ex.requests("POST " + models.V1SpotOrdersPath); len(requests) > 0 {
+			requests[0].decode(t, &child)
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no child order sent")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for deadline := time.Now().Add(time.Second); len(exec.Report().Children) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("child order not acknowledged")
+		}
+	}
+
+	exec.OnFill(&models.ApiFill{ClientOrderID: "someone-else", Size: decimal.NewFromInt(1)})
+	exec.OnFill(&models.ApiFill{ClientOrderID: child.ClientOrderID, Size: decimal.NewFromInt(2), Cost: decimal.NewFromInt(80)})
+	report := exec.Report()
+	if !report.Filled.Equal(decimal.NewFromInt(2)) || !report.AveragePrice.Equal(decimal.NewFromInt(40)) {
+		t.Fatalf("filled %s at %s, want 2 at 40", report.Filled, report.AveragePrice)
+	}
+}
+
+func TestTWAPPauseStopsTheSchedule(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("POST "+models.V1SpotOrdersPath, func(req fakeRequest) (int, any) {
+		var add models.AddOrderReq
+		req.decode(t, &add)
+		return ok(models.ApiOrder{ClientOrderID: add.ClientOrderID, OrderQuantity: add.Size, FilledQuantity: add.Size,
+			State: models.FullyFilled})
+	})
+	exec, err := ex.client().StartTWAP(context.Background(), ParentOrder{Product: ProductSpot, Market: "AVAX-USDC",
+		Side: models.Bid, Size: decimal.NewFromInt(4), Duration: 200 * time.Millisecond}, ExecutionOptions{Slices: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exec.Cancel()
+
+	for deadline := time.Now().Add(time.Second); len(ex.requests("POST "+models.V1SpotOrdersPath)) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("no child order sent")
+		}
+	}
+	exec.Pause()
+	// Long enough for the whole schedule to have passed
+	time.Sleep(300 * time.Millisecond)
+	if n := len(ex.requests("POST " + models.V1SpotOrdersPath)); n > 2 {
+		t.Fatalf("sent %d children while paused", n)
+	}
+	exec.Resume()
+
+	report := exec.Wait()
+	if report.State != ExecutionDone || !report.Filled.Equal(decimal.NewFromInt(4)) {
+		t.Fatalf("%s with %s filled, want done with 4", report.State, report.Filled)
+	}
+	for i, req := range ex.requests("POST " + models.V1SpotOrdersPath) {
+		var add models.AddOrderReq
+		req.decode(t, &add)
+		if !add.Size.Equal(decimal.NewFromInt(1)) {
+			t.Fatalf("child %d has size %s, want every slice sent on schedule at 1", i, add.Size)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+// ProductFill is a fill with the product it was made on
+type ProductFill struct {
+	Product Product `json:"product"`
+	*models.ApiFill
+}
+
+// MarshalJSON encodes the fill's fields after the product. It is needed because models.ApiFill's own MarshalJSON
+// would otherwise be promoted and leave the product out.
+func (f ProductFill) MarshalJSON() ([]byte, error) {
+	product, err := json.Marshal(f.Product)
+	if err != nil {
+		return nil, err
+	}
+	if f.ApiFill == nil {
+		return []byte(`{"product":` + string(product) + `}`), nil
+	}
+	fill, err := json.Marshal(f.ApiFill)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(`{"product":`+string(product)+`,`), fill[1:]...), nil
+}
+
+// UnmarshalJSON decodes a fill written by MarshalJSON
+func (f *ProductFill) UnmarshalJSON(data []byte) error {
+	var product struct {
+		Product Product `json:"product"`
+	}
+	if err := json.Unmarshal(data, &product); err != nil {
+		return err
+	}
+	fill := &models.ApiFill{}
+	if err := json.Unmarshal(data, fill); err != nil {
+		return err
+	}
+	f.Product, f.ApiFill = product.Product, fill
+	return nil
+}
+
+// NetFee returns the fee less any rebate. It is negative when the rebate is larger.
+func (f ProductFill) NetFee() decimal.Decimal {
+	return netFee(f.ApiFill)
+}
+
+func netFee(fill *models.ApiFill) decimal.Decimal {
+	if fill.FeeRebate == nil {
+		return fill.Fee
+	}
+	return fill.Fee.Sub(*fill.FeeRebate)
+}
+
+// FillHistory fetches every spot and perps fill matching params, following cursors, and returns them oldest
+// first. params.Cursor is ignored.
+func (c *ApiClient) FillHistory(ctx context.Context, params models.FillParams) ([]ProductFill, error) {
+	client := c.WithContext(ctx)
+	params.Cursor = ""
+
+	spot, err := listAllFills(client.GetSpotFills, params)
+	if err != nil {
+		return nil, err
+	}
+	perps, err := listAllFills(client.GetPerpsFills, params)
+	if err != nil {
+		return nil, err
+	}
+
+	fills := make([]ProductFill, 0, len(spot)+len(perps))
+	for _, fill := range spot {
+		fills = append(fills, ProductFill{Product: ProductSpot, ApiFill: fill})
+	}
+	for _, fill := range perps {
+		fills = append(fills, ProductFill{Product: ProductPerps, ApiFill: fill})
+	}
+	sort.SliceStable(fills, func(i, j int) bool { return fills[i].CreatedAt.Before(fills[j].CreatedAt) })
+	return fills, nil
+}
+
+var fillCSVHeader = []string{
+	"time", "product", "market", "fillId", "orderId", "clientOrderId", "side", "price", "size", "cost", "fee",
+	"feeRebate", "netFee", "realizedPnl",
+}
+
+// WriteFillsCSV writes fills as CSV with a header row. Times are RFC3339 in UTC, and missing rebates and PnL are
+// empty.
+func WriteFillsCSV(w io.Writer, fills []ProductFill) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(fillCSVHeader); err != nil {
+		return err
+	}
+	for _, fill := range fills {
+		err := cw.Write([]string{
+			fill.CreatedAt.UTC().Format(time.RFC3339Nano),
+			string(fill.Product),
+			string(fill.Market),
+			string(fill.FillID),
+			string(fill.OrderID),
+			string(fill.ClientOrderID),
+			fill.SideString(),
+			fill.Price.String(),
+			fill.Size.String(),
+			fill.Cost.String(),
+			fill.Fee.String(),
+			optionalDecimal(fill.FeeRebate),
+			fill.NetFee().String(),
+			optionalDecimal(fill.RealizedPNL),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteFillsJSONL writes fills as JSON lines, one fill per line
+func WriteFillsJSONL(w io.Writer, fills []ProductFill) error {
+	enc := json.NewEncoder(w)
+	for _, fill := range fills {
+		if err := enc.Encode(fill); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func optionalDecimal(d *decimal.Decimal) string {
+	if d == nil {
+		return ""
+	}
+	return d.String()
+}
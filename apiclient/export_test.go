@@ -0,0 +1,32 @@
+package apiclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+)
+
+func TestWriteFillsJSONLKeepsProductAndSide(t *testing.T) {
+	fills := []ProductFill{
+		lotFill(ProductPerps, "f1", models.Bid, "1", "10"),
+		{Product: ProductSpot, ApiFill: &models.ApiFill{FillID: "f2", Market: "AVAX-USDC", UnknownSide: "short"}},
+	}
+	var buf bytes.Buffer
+	if err := WriteFillsJSONL(&buf, fills); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := json.NewDecoder(&buf)
+	for _, want := range fills {
+		var got ProductFill
+		if err := dec.Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Product != want.Product || got.FillID != want.FillID || got.SideString() != want.SideString() {
+			t.Errorf("decoded %s fill %s with side %s, want %s fill %s with side %s", got.Product, got.FillID,
+				got.SideString(), want.Product, want.FillID, want.SideString())
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package apiclient
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+// FundingSnapshot is a market's funding rates and the account's position in it at one point in time
+type FundingSnapshot struct {
+	Time   time.Time
+	Market models.Market
+
+	Rate            decimal.Decimal
+	NextRate        decimal.Decimal
+	NextFundingTime time.Time
+
+	// Signed position quantity and the funding reported on it since it was last neutral
+	Position   decimal.Decimal
+	NetFunding decimal.Decimal
+}
+
+// PositionFunding is the funding attributed to one position, from when the market's position left zero until it
+// returned to zero
+type PositionFunding struct {
+	Market   models.Market
+	OpenedAt time.Time
+
+	// Zero while the position is open
+	ClosedAt time.Time
+
+	// The latest signed quantity, or the last one before the position closed
+	Quantity decimal.Decimal
+
+	// Funding reported by the exchange on the position, its NetFundingSinceNeutral
+	Funding decimal.Decimal
+}
+
+// Open reports whether the position is still open
+func (p PositionFunding) Open() bool {
+	return p.ClosedAt.IsZero()
+}
+
+// DefaultMaxFundingSnapshots is how many snapshots a FundingTracker keeps per market unless SetMaxSnapshots
+// changes it: a week of snapshots taken once a minute
+const DefaultMaxFundingSnapshots = 7 * 24 * 60
+
+// DefaultMaxClosedPositions is how many closed positions a FundingTracker keeps unless SetMaxClosedPositions
+// changes it
+const DefaultMaxClosedPositions = 10000
+
+// FundingTracker records funding snapshots over time and attributes funding PnL to each position. Positions are
+// fed from the positions channel or by Snapshot. The funding attributed to a position is the exchange's
+// NetFundingSinceNeutral, the only source of funding paid or received on a position.
+type FundingTracker struct {
+	client  *ApiClient
+	markets map[models.Market]bool
+
+	mu           sync.Mutex
+	snapshots    map[models.Market][]FundingSnapshot
+	maxSnapshots int
+	open         map[models.Market]*PositionFunding
+	closed       []*PositionFunding
+	maxClosed    int
+
+	// Funding of the closed positions dropped beyond maxClosed, by market, still counted by FundingPnL
+	dropped map[models.Market]decimal.Decimal
+
+	err error
+}
+
+// NewFundingTracker tracks funding on markets, or on every perps market if none are given
+func (c *ApiClient) NewFundingTracker(markets ...models.Market) *FundingTracker {
+	t := &FundingTracker{
+		client:       c,
+		snapshots:    map[models.Market][]FundingSnapshot{},
+		maxSnapshots: DefaultMaxFundingSnapshots,
+		open:         map[models.Market]*PositionFunding{},
+		maxClosed:    DefaultMaxClosedPositions,
+		dropped:      map[models.Market]decimal.Decimal{},
+	}
+	if len(markets) > 0 {
+		t.markets = map[models.Market]bool{}
+		for _, market := range markets {
+			t.markets[market] = true
+		}
+	}
+	return t
+}
+
+// SetMaxSnapshots keeps at most n snapshots per market, dropping the oldest first. n must be positive.
+func (t *FundingTracker) SetMaxSnapshots(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("bad funding snapshot limit: %d must be positive", n)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.maxSnapshots = n
+	for market := range t.snapshots {
+		t.trim(market)
+	}
+	return nil
+}
+
+// trim drops the oldest snapshots of market beyond the limit, called with t.mu held
+func (t *FundingTracker) trim(market models.Market) {
+	if snapshots := t.snapshots[market]; len(snapshots) > t.maxSnapshots {
+		t.snapshots[market] = snapshots[len(snapshots)-t.maxSnapshots:]
+	}
+}
+
+// SetMaxClosedPositions keeps at most n closed positions, dropping the oldest first. FundingPnL still counts the
+// funding of dropped positions. n must be positive.
+func (t *FundingTracker) SetMaxClosedPositions(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("bad closed position limit: %d must be positive", n)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.maxClosed = n
+	t.trimClosed()
+	return nil
+}
+
+// close records a position as closed at now, called with t.mu held
+func (t *FundingTracker) close(position *PositionFunding, now time.Time) {
+	position.ClosedAt = now
+	t.closed = append(t.closed, position)
+	t.trimClosed()
+}
+
+// trimClosed drops the oldest closed positions beyond the limit, called with t.mu held
+func (t *FundingTracker) trimClosed() {
+	if excess := len(t.closed) - t.maxClosed; excess > 0 {
+		for _, position := range t.closed[:excess] {
+			t.dropped[position.Market] = t.dropped[position.Market].Add(position.Funding)
+		}
+		t.closed = append([]*PositionFunding(nil), t.closed[excess:]...)
+	}
+}
+
+func (t *FundingTracker) tracks(market models.Market) bool {
+	return t.markets == nil || t.markets[market]
+}
+
+// OnPosition updates the funding of the market's position, opening a new one when the quantity leaves zero and
+// closing it when the quantity returns to zero
+func (t *FundingTracker) OnPosition(position *models.ApiPosition) {
+	if !t.tracks(position.Market) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onPosition(position, time.Now())
+}
+
+// onPosition is called with t.mu held. A position that flips sign between two updates is closed and a new one
+// opened at the update that shows the flip. NetFundingSinceNeutral resets when the position passes through zero, so
+// the new position only has the funding since the flip, and the old one keeps the funding of its last update: any
+// funding paid between that update and the flip is attributed to neither.
+func (t *FundingTracker) onPosition(position *models.ApiPosition, now time.Time) {
+	quantity := position.SignedQuantity()
+	current, ok := t.open[position.Market]
+
+	if quantity.IsZero() {
+		if ok {
+			// The exchange may report the funding as already reset once the position is neutral; keep the last value
+			if !position.NetFundingSinceNeutral.IsZero() {
+				current.Funding = position.NetFundingSinceNeutral
+			}
+			t.close(current, now)
+			delete(t.open, position.Market)
+		}
+		return
+	}
+
+	if ok && quantity.Sign() != current.Quantity.Sign() {
+		// Flipped through zero between updates
+		t.close(current, now)
+		ok = false
+	}
+	if !ok {
+		current = &PositionFunding{Market: position.Market, OpenedAt: now}
+		t.open[position.Market] = current
+	}
+	current.Quantity = quantity
+	current.Funding = position.NetFundingSinceNeutral
+}
+
+// HandleEvent passes perps position updates to OnPosition
+func (t *FundingTracker) HandleEvent(event Event) {
+	if position, ok := event.Data.(*models.ApiPosition); ok {
+		t.OnPosition(position)
+	}
+}
+
+// Attach feeds the tracker from d's perps positions channel, which still needs to be subscribed
+func (t *FundingTracker) Attach(d *Dispatcher) {
+	d.Handle(PerpsPositions(), "", HandlerOptions{Policy: Block}, t.HandleEvent)
+}
+
+// Snapshot reads the perps contracts and positions, updates the positions, and records a snapshot for every
+// tracked market. If any tracked contract can't be parsed nothing is updated or recorded.
+func (t *FundingTracker) Snapshot(ctx context.Context) error {
+	client := t.client.WithContext(ctx)
+	contracts, err := client.GetPerpsContracts()
+	if err != nil {
+		return err
+	}
+	positions, err := client.GetPerpsPositions()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var snapshots []FundingSnapshot
+	for _, contract := range contracts.Result {
+		if !t.tracks(contract.Market) {
+			continue
+		}
+		snapshot, err := fundingSnapshot(contract, now)
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	reported := map[models.Market]bool{}
+	for i := range positions.Result {
+		position := &positions.Result[i]
+		if !t.tracks(position.Market) {
+			continue
+		}
+		reported[position.Market] = true
+		t.onPosition(position, now)
+	}
+	// Closed positions are left out of the positions list
+	for market := range t.open {
+		if !reported[market] {
+			t.onPosition(&models.ApiPosition{Market: market}, now)
+		}
+	}
+
+	for _, snapshot := range snapshots {
+		if current, ok := t.open[snapshot.Market]; ok {
+			snapshot.Position, snapshot.NetFunding = current.Quantity, current.Funding
+		}
+		t.snapshots[snapshot.Market] = append(t.snapshots[snapshot.Market], snapshot)
+		t.trim(snapshot.Market)
+	}
+	return nil
+}
+
+func fundingSnapshot(contract models.PerpsContract, now time.Time) (FundingSnapshot, error) {
+	snapshot := FundingSnapshot{Time: now, Market: contract.Market}
+	var err error
+	if contract.FundingRate != "" {
+		if snapshot.Rate, err = decimal.NewFromString(contract.FundingRate); err != nil {
+			return snapshot, fmt.Errorf("invalid funding rate of %s: %w", contract.Market, err)
+		}
+	}
+	if contract.NextFundingRate != "" {
+		if snapshot.NextRate, err = decimal.NewFromString(contract.NextFundingRate); err != nil {
+			return snapshot, fmt.Errorf("invalid next funding rate of %s: %w", contract.Market, err)
+		}
+	}
+	snapshot.NextFundingTime = parseFundingTime(contract.NextFundingRateTimestamp)
+	return snapshot, nil
+}
+
+// parseFundingTime accepts unix milliseconds or RFC3339, returning the zero time for anything else
+func parseFundingTime(s string) time.Time {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms)
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+// Run takes a snapshot every interval until ctx is done. Failed snapshots are reported by Err.
+func (t *FundingTracker) Run(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("bad funding snapshot interval: %s must be positive", interval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := t.Snapshot(ctx)
+		t.mu.Lock()
+		t.err = err
+		t.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Err returns the error of the last snapshot taken by Run, if it failed
+func (t *FundingTracker) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Snapshots returns the snapshots kept for market, oldest first
+func (t *FundingTracker) Snapshots(market models.Market) []FundingSnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]FundingSnapshot(nil), t.snapshots[market]...)
+}
+
+// Positions returns the funding of every tracked position, closed ones first in the order they closed. Only the
+// newest closed positions are kept, up to the SetMaxClosedPositions limit.
+func (t *FundingTracker) Positions() []PositionFunding {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	positions := make([]PositionFunding, 0, len(t.closed)+len(t.open))
+	for _, position := range t.closed {
+		positions = append(positions, *position)
+	}
+	for _, position := range t.open {
+		positions = append(positions, *position)
+	}
+	return positions
+}
+
+// FundingPnL returns the funding of every position tracked in market, open and closed, as reported by the exchange
+// in each position's NetFundingSinceNeutral. It includes closed positions dropped from Positions.
+func (t *FundingTracker) FundingPnL(market models.Market) decimal.Decimal {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	total := t.dropped[market]
+	for _, position := range t.closed {
+		if position.Market == market {
+			total = total.Add(position.Funding)
+		}
+	}
+	if current, ok := t.open[market]; ok {
+		total = total.Add(current.Funding)
+	}
+	return total
+}
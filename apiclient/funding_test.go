@@ -0,0 +1,122 @@
+package apiclient
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestFundingTrackerRunRequiresPositiveInterval(t *testing.T) {
+	tracker := NewApiClient("http://localhost").NewFundingTracker()
+	if err := tracker.Run(context.Background(), 0); err == nil {
+		t.Fatal("expected an error for a zero interval")
+	}
+}
+
+func TestFundingTrackerKeepsMaxSnapshots(t *testing.T) {
+	ex := newFakeExchange(t)
+	var rate atomic.Int64
+	ex.handle("GET "+models.V1PerpsContractsPath, func(fakeRequest) (int, any) {
+		return ok([]models.PerpsContract{{Market: "BTC-USD.P", FundingRate: strconv.FormatInt(rate.Add(1), 10)}})
+	})
+	ex.handle("GET "+models.V1PerpsPositionsPath, func(fakeRequest) (int, any) { return ok([]models.ApiPosition{}) })
+	tracker := ex.client().NewFundingTracker()
+	if err := tracker.SetMaxSnapshots(0); err == nil {
+		t.Fatal("expected an error for a zero limit")
+	}
+	if err := tracker.SetMaxSnapshots(3); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := tracker.Snapshot(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshots := tracker.Snapshots("BTC-USD.P")
+	if len(snapshots) != 3 || !snapshots[0].Rate.Equal(decimal.NewFromInt(3)) || !snapshots[2].Rate.Equal(decimal.NewFromInt(5)) {
+		t.Fatalf("snapshots %+v, want the newest 3", snapshots)
+	}
+
+	if err := tracker.SetMaxSnapshots(1); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots := tracker.Snapshots("BTC-USD.P"); len(snapshots) != 1 || !snapshots[0].Rate.Equal(decimal.NewFromInt(5)) {
+		t.Fatalf("snapshots %+v after lowering the limit, want the newest", snapshots)
+	}
+}
+
+func TestFundingSnapshotIsAllOrNothing(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("GET "+models.V1PerpsContractsPath, func(fakeRequest) (int, any) {
+		return ok([]models.PerpsContract{
+			{Market: "BTC-USD.P", FundingRate: "0.0001"},
+			{Market: "ETH-USD.P", FundingRate: "not a rate"},
+		})
+	})
+	ex.handle("GET "+models.V1PerpsPositionsPath, func(fakeRequest) (int, any) { return ok([]models.ApiPosition{}) })
+	tracker := ex.client().NewFundingTracker()
+
+	if err := tracker.Snapshot(context.Background()); err == nil {
+		t.Fatal("expected an error for the unparseable rate")
+	}
+	if snapshots := tracker.Snapshots("BTC-USD.P"); len(snapshots) != 0 {
+		t.Fatalf("recorded %d snapshots after a failed snapshot", len(snapshots))
+	}
+
+	ex.handle("GET "+models.V1PerpsContractsPath, func(fakeRequest) (int, any) {
+		return ok([]models.PerpsContract{{Market: "BTC-USD.P", FundingRate: "0.0001"}})
+	})
+	if err := tracker.Snapshot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	snapshots := tracker.Snapshots("BTC-USD.P")
+	if len(snapshots) != 1 || !snapshots[0].Rate.Equal(decimal.RequireFromString("0.0001")) {
+		t.Fatalf("snapshots %+v", snapshots)
+	}
+}
+
+func TestFundingTrackerKeepsMaxClosedPositions(t *testing.T) {
+	tracker := NewApiClient("http://localhost").NewFundingTracker()
+	if err := tracker.SetMaxClosedPositions(0); err == nil {
+		t.Fatal("expected an error for a zero limit")
+	}
+	if err := tracker.SetMaxClosedPositions(2); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(1); i <= 4; i++ {
+		tracker.OnPosition(&models.ApiPosition{Market: "BTC-USD.P", Direction: "long", NetQuantity: decimal.NewFromInt(1),
+			NetFundingSinceNeutral: decimal.NewFromInt(i)})
+		tracker.OnPosition(&models.ApiPosition{Market: "BTC-USD.P"})
+	}
+	positions := tracker.Positions()
+	if len(positions) != 2 || !positions[0].Funding.Equal(decimal.NewFromInt(3)) || !positions[1].Funding.Equal(decimal.NewFromInt(4)) {
+		t.Fatalf("positions %+v, want the newest 2", positions)
+	}
+	if pnl := tracker.FundingPnL("BTC-USD.P"); !pnl.Equal(decimal.NewFromInt(10)) {
+		t.Fatalf("funding PnL %s, want 10 including the dropped positions", pnl)
+	}
+}
+
+func TestFundingTrackerSplitsFlippedPosition(t *testing.T) {
+	tracker := NewApiClient("http://localhost").NewFundingTracker()
+	tracker.OnPosition(&models.ApiPosition{Market: "BTC-USD.P", Direction: "long", NetQuantity: decimal.NewFromInt(1),
+		NetFundingSinceNeutral: decimal.NewFromInt(-2)})
+	// Flipped to short between updates: the exchange reports the funding since the flip
+	tracker.OnPosition(&models.ApiPosition{Market: "BTC-USD.P", Direction: "short", NetQuantity: decimal.NewFromInt(1),
+		NetFundingSinceNeutral: decimal.NewFromInt(1)})
+
+	positions := tracker.Positions()
+	if len(positions) != 2 || positions[0].Open() || !positions[0].Funding.Equal(decimal.NewFromInt(-2)) ||
+		!positions[1].Open() || !positions[1].Quantity.IsNegative() || !positions[1].Funding.Equal(decimal.NewFromInt(1)) {
+		t.Fatalf("positions %+v, want the closed long and the open short", positions)
+	}
+	if pnl := tracker.FundingPnL("BTC-USD.P"); !pnl.Equal(decimal.NewFromInt(-1)) {
+		t.Fatalf("funding PnL %s, want -1", pnl)
+	}
+}
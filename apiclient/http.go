@@ -13,8 +13,13 @@ import (
 )
 
 type HttpJsonClient[REQUEST_T any, REPLY_T any] struct {
-	ApiEndpoint   string
-	headers       map[string]string
+	ApiEndpoint string
+	headers     map[string]string
+	httpClient  *http.Client
+	onResponse  func(resp *http.Response)
+
+	// IsCSVResponse returns the response body as is instead of decoding it as JSON, for a REPLY_T of []byte. The
+	// exchange API has no CSV endpoints, so fill exports are written by the client from the JSON fills instead.
 	IsCSVResponse bool
 }
 
@@ -37,6 +42,18 @@ func (cl *HttpJsonClient[REQUEST_T, REPLY_T]) SetHeaders(headers map[string]stri
 	return cl
 }
 
+// SetHttpClient sets the http.Client used to send requests. A nil client uses http.DefaultClient.
+func (cl *HttpJsonClient[REQUEST_T, REPLY_T]) SetHttpClient(httpClient *http.Client) *HttpJsonClient[REQUEST_T, REPLY_T] {
+	cl.httpClient = httpClient
+	return cl
+}
+
+// SetResponseHook sets a function called with every http response before its body is read
+func (cl *HttpJsonClient[REQUEST_T, REPLY_T]) SetResponseHook(onResponse func(resp *http.Response)) *HttpJsonClient[REQUEST_T, REPLY_T] {
+	cl.onResponse = onResponse
+	return cl
+}
+
 func (cl *HttpJsonClient[REQUEST_T, REPLY_T]) Post(request REQUEST_T) (*REPLY_T, error) {
 	return cl.Do("POST", request)
 }
@@ -51,6 +68,22 @@ func (cl *HttpJsonClient[REQUEST_T, REPLY_T]) Delete(request REQUEST_T) (*REPLY_
 
 var ErrEmptyResponseBody = fmt.Errorf("response body is empty")
 
+// StatusError is returned for responses with an unsuccessful HTTP status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("response: status=%d, body=%s", e.StatusCode, e.Body)
+}
+
+// IsNotFound reports whether err is from a response with status 404
+func IsNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
 func (cl *HttpJsonClient[REQUEST_T, REPLY_T]) Do(method string, request REQUEST_T) (*REPLY_T, error) {
 	jsonStr, err := JsonSerializer[REQUEST_T]{}.ToJsonString(request)
 	if err != nil {
@@ -81,12 +114,21 @@ func (cl *HttpJsonClient[REQUEST_T, REPLY_T]) Do(method string, request REQUEST_
 		req.Header.Set(k, v)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	httpClient := cl.httpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if cl.onResponse != nil {
+		cl.onResponse(resp)
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -94,7 +136,7 @@ func (cl *HttpJsonClient[REQUEST_T, REPLY_T]) Do(method string, request REQUEST_
 
 	if !(resp.StatusCode == 200 || resp.StatusCode == 201 || resp.StatusCode == 202) {
 		reply, err := JsonSerializer[REPLY_T]{}.FromJsonString(string(body))
-		err_text := fmt.Errorf("response: status=%d, body=%s", resp.StatusCode, body)
+		err_text := &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
 		if err != nil {
 			return nil, err_text
 		}
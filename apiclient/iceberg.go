@@ -0,0 +1,348 @@
+package apiclient
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+// IcebergOrder is a limit order of which only DisplaySize is shown on the book at a time
+type IcebergOrder struct {
+	Product Product
+	Market  models.Market
+	Side    models.BidAsk
+	Price   decimal.Decimal
+
+	// Total size, including the hidden remainder
+	Size        decimal.Decimal
+	DisplaySize decimal.Decimal
+
+	PostOnly bool
+}
+
+// Iceberg places the visible part of an IcebergOrder and replenishes it from the hidden remainder as fills arrive.
+// Orders are placed and cancelled without holding the iceberg's lock.
+type Iceberg struct {
+	client *ApiClient
+	order  IcebergOrder
+
+	// Held while a slice is being placed or cancelled, so Cancel never misses a slice that is being placed
+	sending sync.Mutex
+
+	mu          sync.Mutex
+	started     bool
+	current     models.OrderID
+	currentSize decimal.Decimal
+
+	// Fills of the visible slice passed to OnFill, and the filled quantity the exchange last reported for it. The
+	// two overlap, so the slice has filled the larger of them.
+	currentFill  decimal.Decimal
+	currentAcked decimal.Decimal
+
+	// Filled by earlier slices
+	previous decimal.Decimal
+	stopped  bool
+	err      error
+	done     chan struct{}
+}
+
+// NewIceberg returns an iceberg for order without placing anything. Attach it, or otherwise pass it fills with
+// OnFill, before calling Start, so fills of a first slice that trades immediately aren't missed.
+func (c *ApiClient) NewIceberg(order IcebergOrder) (*Iceberg, error) {
+	if !order.DisplaySize.IsPositive() || order.DisplaySize.GreaterThan(order.Size) {
+		return nil, fmt.Errorf("bad iceberg: display size %s must be positive and at most the size %s", order.DisplaySize, order.Size)
+	}
+	return &Iceberg{client: c, order: order, done: make(chan struct{})}, nil
+}
+
+// Start places the first visible slice. If it isn't known whether the slice was placed, it is cancelled. If the
+// slice isn't placed, or is cancelled, rejected or isn't left on the book, e.g. a post-only slice that would have
+// crossed, the iceberg stops and Start returns the error.
+func (i *Iceberg) Start() error {
+	i.sending.Lock()
+	defer i.sending.Unlock()
+	i.mu.Lock()
+	if i.started || i.stopped {
+		i.mu.Unlock()
+		return errors.New("iceberg already started or cancelled")
+	}
+	i.started = true
+	id := i.nextSlice()
+	i.mu.Unlock()
+
+	placed, err := i.place(id)
+	if err != nil && !definitelyNotPlaced(err) {
+		if cancelErr := i.client.cancelOrderByClientID(i.order.Product, id); cancelErr != nil && !IsNotFound(cancelErr) {
+			err = errors.Join(err, fmt.Errorf("iceberg slice %s may be on the book: %w", id, cancelErr))
+		}
+	}
+
+	i.mu.Lock()
+	if err == nil {
+		i.onOrder(placed)
+	} else if !i.stopped {
+		i.current = ""
+		i.stop(err)
+	}
+	if i.stopped {
+		err = i.err
+	}
+	i.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	i.replenish()
+	return nil
+}
+
+// nextSlice records the next visible slice and returns its client order ID. It is recorded before it is placed
+// because its fills can arrive before the order is acknowledged. It is called with i.mu held.
+func (i *Iceberg) nextSlice() models.OrderID {
+	i.current = NewClientOrderID("ice-")
+	i.currentSize = decimal.Min(i.order.DisplaySize, i.order.Size.Sub(i.previous))
+	i.currentFill = decimal.Zero
+	i.currentAcked = decimal.Zero
+	return i.current
+}
+
+// currentFilled returns how much of the visible slice has filled, called with i.mu held
+func (i *Iceberg) currentFilled() decimal.Decimal {
+	return decimal.Max(i.currentFill, i.currentAcked)
+}
+
+// sliceFilled reports whether the visible slice is completely filled, called with i.mu held
+func (i *Iceberg) sliceFilled() bool {
+	return !i.stopped && i.current != "" && !i.currentFilled().LessThan(i.currentSize)
+}
+
+// place places the slice recorded by nextSlice, called with i.sending held and i.mu not held
+func (i *Iceberg) place(id models.OrderID) (*models.ApiOrder, error) {
+	i.mu.Lock()
+	req := models.AddOrderReq{
+		Side:          i.order.Side,
+		Price:         i.order.Price,
+		Size:          i.currentSize,
+		Market:        i.order.Market,
+		ClientOrderID: id,
+		Type:          models.OrderTypeLimit,
+		PostOnly:      i.order.PostOnly,
+	}
+	i.mu.Unlock()
+
+	return i.client.addOrder(i.order.Product, req)
+}
+
+// replenish places the next slice for as long as the visible one is completely filled, and stops the iceberg once
+// its whole size has filled. If a slice is definitely not placed the iceberg stops with the error. If it isn't
+// known whether it was placed, it is looked up as by Resolve. It is called with i.sending held and i.mu not held.
+func (i *Iceberg) replenish() {
+	for {
+		i.mu.Lock()
+		if !i.sliceFilled() {
+			i.mu.Unlock()
+			return
+		}
+		i.previous = i.previous.Add(i.currentFilled())
+		i.currentFill, i.currentAcked = decimal.Zero, decimal.Zero
+		if !i.previous.LessThan(i.order.Size) {
+			i.stop(nil)
+			i.mu.Unlock()
+			return
+		}
+		id := i.nextSlice()
+		i.mu.Unlock()
+
+		placed, err := i.place(id)
+
+		i.mu.Lock()
+		switch {
+		case err == nil:
+			i.err = nil
+			i.onOrder(placed)
+		case definitelyNotPlaced(err):
+			i.current = ""
+			i.stop(fmt.Errorf("failed to replenish iceberg: %w", err))
+		default:
+			i.err = fmt.Errorf("failed to replenish iceberg: %w", err)
+		}
+		unknown := err != nil && !i.stopped
+		i.mu.Unlock()
+
+		if unknown {
+			i.resolve()
+		}
+	}
+}
+
+// OnFill counts fills of the visible slice and places the next one once it is completely filled
+func (i *Iceberg) OnFill(fill *models.ApiFill) {
+	i.mu.Lock()
+	if i.stopped || fill.ClientOrderID != i.current {
+		i.mu.Unlock()
+		return
+	}
+	i.currentFill = i.currentFill.Add(fill.Size)
+	filled := i.sliceFilled()
+	i.mu.Unlock()
+
+	if filled {
+		i.sending.Lock()
+		defer i.sending.Unlock()
+		i.replenish()
+	}
+}
+
+// OnOrder handles an update of the visible slice, including its filled quantity. A slice that was cancelled or
+// rejected, e.g. a post-only slice that would have crossed or one cancelled outside the iceberg, stops the iceberg
+// with an error instead of leaving it waiting for fills that won't arrive. Fills of the slice that arrive after it
+// stopped aren't counted. Attach only feeds fills, so updates come from the caller, e.g. a channel decoded with
+// RegisterChannelDecoder.
+func (i *Iceberg) OnOrder(order *models.ApiOrder) {
+	i.mu.Lock()
+	i.onOrder(order)
+	filled := i.sliceFilled()
+	i.mu.Unlock()
+
+	if filled {
+		i.sending.Lock()
+		defer i.sending.Unlock()
+		i.replenish()
+	}
+}
+
+// onOrder is called with i.mu held
+func (i *Iceberg) onOrder(order *models.ApiOrder) {
+	if i.stopped || order.ClientOrderID != i.current {
+		return
+	}
+	if orderClosed(order) {
+		i.current = ""
+		i.stop(fmt.Errorf("iceberg slice %s was %s", order.ClientOrderID, order.State))
+		return
+	}
+	// The exchange knows the slice, so an error placing it no longer applies
+	i.err = nil
+	acked := order.FilledQuantity
+	if order.State == models.FullyFilled {
+		acked = i.currentSize
+	}
+	i.currentAcked = decimal.Max(i.currentAcked, acked)
+}
+
+// Resolve looks up a visible slice whose placement failed with an unknown outcome, which Err reports. A slice the
+// exchange knows is handled as by OnOrder; one it doesn't know was never placed, and the iceberg stops with the
+// error. Resolve is called after every such failure, and only needs calling again if the lookup itself failed.
+func (i *Iceberg) Resolve() error {
+	i.sending.Lock()
+	defer i.sending.Unlock()
+	err := i.resolve()
+	i.replenish()
+	return err
+}
+
+// resolve is called with i.sending held and i.mu not held
+func (i *Iceberg) resolve() error {
+	i.mu.Lock()
+	id, pending := i.current, i.err
+	stopped := i.stopped
+	i.mu.Unlock()
+	if stopped || pending == nil || id == "" {
+		return nil
+	}
+
+	order, err := i.client.getOrderByClientID(i.order.Product, id)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	switch {
+	case i.stopped || id != i.current:
+	case IsNotFound(err):
+		i.current = ""
+		i.stop(fmt.Errorf("iceberg slice %s was not placed: %w", id, pending))
+	case err != nil:
+		return fmt.Errorf("failed to look up iceberg slice %s: %w", id, err)
+	default:
+		i.onOrder(order)
+	}
+	return nil
+}
+
+// HandleEvent passes fills and order updates from a dispatcher event to OnFill and OnOrder
+func (i *Iceberg) HandleEvent(event Event) {
+	switch data := event.Data.(type) {
+	case *models.ApiFill:
+		i.OnFill(data)
+	case *models.ApiOrder:
+		i.OnOrder(data)
+	}
+}
+
+// Attach feeds the iceberg from d's fills channel for its market, which still needs to be subscribed
+func (i *Iceberg) Attach(d *Dispatcher) *HandlerRegistration {
+	channel := FillsSpot()
+	if i.order.Product == ProductPerps {
+		channel = FillsPerps()
+	}
+	return d.Handle(channel, i.order.Market, HandlerOptions{Policy: Block}, i.HandleEvent)
+}
+
+// Cancel cancels the visible slice and stops replenishing. A slice that is being placed is cancelled once the
+// place has returned.
+func (i *Iceberg) Cancel() error {
+	i.sending.Lock()
+	defer i.sending.Unlock()
+	i.mu.Lock()
+	if i.stopped {
+		i.mu.Unlock()
+		return nil
+	}
+	current := i.current
+	i.stop(nil)
+	i.mu.Unlock()
+
+	if current == "" {
+		return nil
+	}
+	return i.client.cancelOrderByClientID(i.order.Product, current)
+}
+
+// stop is called with i.mu held
+func (i *Iceberg) stop(err error) {
+	i.stopped = true
+	i.err = err
+	close(i.done)
+}
+
+// Done is closed when the iceberg is completely filled or cancelled, or a slice was not placed or was cancelled or
+// rejected by the exchange
+func (i *Iceberg) Done() <-chan struct{} {
+	return i.done
+}
+
+// Err returns why replenishing failed, if it did
+func (i *Iceberg) Err() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.err
+}
+
+func (i *Iceberg) Filled() decimal.Decimal {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.filled()
+}
+
+func (i *Iceberg) Remaining() decimal.Decimal {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.order.Size.Sub(i.filled())
+}
+
+// filled is called with i.mu held
+func (i *Iceberg) filled() decimal.Decimal {
+	return i.previous.Add(i.currentFilled())
+}
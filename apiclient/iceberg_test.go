@@ -0,0 +1,303 @@
+package apiclient
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestIcebergReplenishes(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        string
+		displaySize string
+		fills       []string
+		wantSlices  []string
+		wantDone    bool
+	}{
+		{name: "first slice only", size: "10", displaySize: "4", fills: []string{"1"}, wantSlices: []string{"4"}},
+		{name: "replenishes after a slice fills", size: "10", displaySize: "4", fills: []string{"1", "3"}, wantSlices: []string{"4", "4"}},
+		{name: "last slice is the remainder", size: "10", displaySize: "4", fills: []string{"4", "4"}, wantSlices: []string{"4", "4", "2"}},
+		{name: "done when filled", size: "10", displaySize: "4", fills: []string{"4", "4", "2"}, wantSlices: []string{"4", "4", "2"}, wantDone: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newFakeExchange(t)
+			orders := newFakeSpotOrders(t, ex)
+			iceberg := startIceberg(t, ex.client(), IcebergOrder{Product: ProductSpot, Market: "AVAX-USDC", Side: models.Bid,
+				Price: decimal.NewFromInt(40), Size: decimal.RequireFromString(tt.size), DisplaySize: decimal.RequireFromString(tt.displaySize)})
+
+			for _, size := range tt.fills {
+				placed := orders.orders()
+				iceberg.OnFill(fillOf(placed[len(placed)-1].ClientOrderID, size))
+			}
+
+			placed := orders.orders()
+			if len(placed) != len(tt.wantSlices) {
+				t.Fatalf("placed %d slices, want %d", len(placed), len(tt.wantSlices))
+			}
+			for i, want := range tt.wantSlices {
+				if !placed[i].Size.Equal(decimal.RequireFromString(want)) {
+					t.Fatalf("slice %d has size %s, want %s", i, placed[i].Size, want)
+				}
+			}
+			select {
+			case <-iceberg.Done():
+				if !tt.wantDone {
+					t.Fatal("iceberg done early")
+				}
+			default:
+				if tt.wantDone {
+					t.Fatal("iceberg not done")
+				}
+			}
+		})
+	}
+}
+
+func TestIcebergIgnoresOtherFills(t *testing.T) {
+	ex := newFakeExchange(t)
+	orders := newFakeSpotOrders(t, ex)
+	iceberg := startIceberg(t, ex.client(), IcebergOrder{Product: ProductSpot, Market: "AVAX-USDC", Side: models.Ask,
+		Price: decimal.NewFromInt(40), Size: decimal.NewFromInt(2), DisplaySize: decimal.NewFromInt(1)})
+	iceberg.OnFill(fillOf("someone-else", "1"))
+	if !iceberg.Filled().IsZero() || len(orders.orders()) != 1 {
+		t.Fatal("counted a fill of another order")
+	}
+}
+
+func TestIcebergReplenishErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		wantStopped bool
+	}{
+		{name: "placed despite the error", status: http.StatusInternalServerError},
+		{name: "rejected", status: http.StatusBadRequest, wantStopped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newFakeExchange(t)
+			orders := newFakeSpotOrders(t, ex)
+			iceberg := startIceberg(t, ex.client(), IcebergOrder{Product: ProductSpot, Market: "AVAX-USDC", Side: models.Bid,
+				Price: decimal.NewFromInt(40), Size: decimal.NewFromInt(4), DisplaySize: decimal.NewFromInt(2)})
+			first := orders.orders()[0].ClientOrderID
+
+			sending, release := make(chan struct{}), make(chan struct{})
+			var replenish models.AddOrderReq
+			ex.handle("POST "+models.V1SpotOrdersPath, func(req fakeRequest) (int, any) {
+				req.decode(t, &replenish)
+				close(sending)
+				<-release
+				return tt.status, models.GenericResponse[any]{Error: "failed"}
+			})
+			filled := make(chan struct{})
+			go func() {
+				iceberg.OnFill(fillOf(first, "2"))
+				close(filled)
+			}()
+
+			// The lock isn't held while the slice is being placed
+			<-sending
+			counted := make(chan decimal.Decimal)
+			go func() { counted <- iceberg.Filled() }()
+			select {
+			case n := <-counted:
+				if !n.Equal(decimal.NewFromInt(2)) {
+					t.Fatalf("filled %s, want 2", n)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Filled blocked while a slice was being placed")
+			}
+			close(release)
+			<-filled
+
+			// A slice found on the book after an error is followed as if it was placed
+			if err := iceberg.Err(); (err != nil) != tt.wantStopped {
+				t.Fatalf("Err() = %v, want an error only if the slice was rejected", err)
+			}
+			select {
+			case <-iceberg.Done():
+				if !tt.wantStopped {
+					t.Fatal("stopped although the slice may be on the book")
+				}
+			default:
+				if tt.wantStopped {
+					t.Fatal("not stopped after the slice was rejected")
+				}
+			}
+
+			// A slice that was placed despite the error is still tracked and is cancelled
+			iceberg.OnFill(fillOf(replenish.ClientOrderID, "1"))
+			if err := iceberg.Cancel(); err != nil {
+				t.Fatalf("Cancel: %v", err)
+			}
+			if tt.wantStopped {
+				if !iceberg.Filled().Equal(decimal.NewFromInt(2)) || orders.wasCancelled(replenish.ClientOrderID) {
+					t.Fatal("a rejected slice was tracked")
+				}
+				return
+			}
+			if !iceberg.Filled().Equal(decimal.NewFromInt(3)) {
+				t.Fatalf("filled %s, want the fill of the unconfirmed slice counted", iceberg.Filled())
+			}
+			if !orders.wasCancelled(replenish.ClientOrderID) {
+				t.Fatal("the unconfirmed slice was not cancelled")
+			}
+		})
+	}
+}
+
+func TestIcebergResolvesUnknownSlice(t *testing.T) {
+	tests := []struct {
+		name        string
+		lookup      int
+		wantStopped bool
+	}{
+		{name: "not placed", lookup: http.StatusNotFound, wantStopped: true},
+		{name: "lookup fails", lookup: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newFakeExchange(t)
+			orders := newFakeSpotOrders(t, ex)
+			iceberg := startIceberg(t, ex.client(), IcebergOrder{Product: ProductSpot, Market: "AVAX-USDC", Side: models.Bid,
+				Price: decimal.NewFromInt(40), Size: decimal.NewFromInt(4), DisplaySize: decimal.NewFromInt(2)})
+			first := orders.orders()[0].ClientOrderID
+
+			ex.handle("POST "+models.V1SpotOrdersPath, func(req fakeRequest) (int, any) {
+				return http.StatusInternalServerError, models.GenericResponse[any]{Error: "failed"}
+			})
+			ex.handle("GET "+models.V1SpotOrdersPath+"/"+models.V1SpotClientOrderIDPrefix+"*", func(req fakeRequest) (int, any) {
+				return tt.lookup, models.GenericResponse[any]{Error: "failed"}
+			})
+			iceberg.OnFill(fillOf(first, "2"))
+
+			if iceberg.Err() == nil {
+				t.Fatal("no replenish error reported")
+			}
+			select {
+			case <-iceberg.Done():
+				if !tt.wantStopped {
+					t.Fatal("stopped although the slice may be on the book")
+				}
+				return
+			default:
+				if tt.wantStopped {
+					t.Fatal("waiting for fills of a slice that was never placed")
+				}
+			}
+
+			// Once the slice can be looked up it is followed as if it was placed
+			newFakeSpotOrders(t, ex)
+			if err := iceberg.Resolve(); err != nil {
+				t.Fatalf("Resolve: %v", err)
+			}
+			if err := iceberg.Err(); err != nil {
+				t.Fatalf("Err() = %v after the slice was found", err)
+			}
+		})
+	}
+}
+
+func TestIcebergStopsWhenSliceIsGone(t *testing.T) {
+	ex := newFakeExchange(t)
+	orders := newFakeSpotOrders(t, ex)
+	iceberg := startIceberg(t, ex.client(), IcebergOrder{Product: ProductSpot, Market: "AVAX-USDC", Side: models.Bid,
+		Price: decimal.NewFromInt(40), Size: decimal.NewFromInt(4), DisplaySize: decimal.NewFromInt(2)})
+	first := orders.orders()[0].ClientOrderID
+
+	iceberg.OnOrder(&models.ApiOrder{ClientOrderID: "someone-else", State: models.Canceled})
+	iceberg.OnOrder(&models.ApiOrder{ClientOrderID: first, State: models.Open})
+	select {
+	case <-iceberg.Done():
+		t.Fatal("stopped by an update that isn't a cancel of the slice")
+	default:
+	}
+
+	iceberg.OnOrder(&models.ApiOrder{ClientOrderID: first, State: models.Canceled})
+	select {
+	case <-iceberg.Done():
+	default:
+		t.Fatal("waiting for fills of a cancelled slice")
+	}
+	if iceberg.Err() == nil {
+		t.Fatal("no error for the cancelled slice")
+	}
+}
+
+func TestIcebergStartPostOnlyCrossed(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("POST "+models.V1SpotOrdersPath, func(req fakeRequest) (int, any) {
+		var add models.AddOrderReq
+		req.decode(t, &add)
+		return ok(models.ApiOrder{ClientOrderID: add.ClientOrderID, State: models.Canceled})
+	})
+	iceberg, err := ex.client().NewIceberg(IcebergOrder{Product: ProductSpot, Market: "AVAX-USDC", Side: models.Bid,
+		Price: decimal.NewFromInt(40), Size: decimal.NewFromInt(4), DisplaySize: decimal.NewFromInt(2), PostOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := iceberg.Start(); err == nil {
+		t.Fatal("started an iceberg whose first slice was cancelled")
+	}
+	select {
+	case <-iceberg.Done():
+	default:
+		t.Fatal("iceberg not stopped")
+	}
+}
+
+func TestIcebergFirstSliceFillsImmediately(t *testing.T) {
+	ex := newFakeExchange(t)
+	orders := newFakeSpotOrders(t, ex)
+	placed := 0
+	ex.handle("POST "+models.V1SpotOrdersPath, func(req fakeRequest) (int, any) {
+		var add models.AddOrderReq
+		req.decode(t, &add)
+		orders.mu.Lock()
+		orders.placed = append(orders.placed, add)
+		orders.mu.Unlock()
+		placed++
+		// The first slice is marketable and fills completely as it is placed
+		if placed == 1 {
+			return ok(models.ApiOrder{ClientOrderID: add.ClientOrderID, State: models.FullyFilled, FilledQuantity: add.Size})
+		}
+		return ok(models.ApiOrder{ClientOrderID: add.ClientOrderID, State: models.Open})
+	})
+	iceberg := startIceberg(t, ex.client(), IcebergOrder{Product: ProductSpot, Market: "AVAX-USDC", Side: models.Bid,
+		Price: decimal.NewFromInt(40), Size: decimal.NewFromInt(4), DisplaySize: decimal.NewFromInt(2)})
+
+	slices := orders.orders()
+	if len(slices) != 2 {
+		t.Fatalf("placed %d slices, want the next one placed after the first filled on placement", len(slices))
+	}
+	// The first slice's fill, delivered after the acknowledgement, isn't counted twice
+	iceberg.OnFill(fillOf(slices[0].ClientOrderID, "2"))
+	if !iceberg.Filled().Equal(decimal.NewFromInt(2)) {
+		t.Fatalf("filled %s, want 2", iceberg.Filled())
+	}
+
+	iceberg.OnFill(fillOf(slices[1].ClientOrderID, "2"))
+	select {
+	case <-iceberg.Done():
+	default:
+		t.Fatal("iceberg not done after filling its size")
+	}
+}
+
+// startIceberg creates and starts an iceberg, failing the test if it can't be started
+func startIceberg(t *testing.T, client *ApiClient, order IcebergOrder) *Iceberg {
+	t.Helper()
+	iceberg, err := client.NewIceberg(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := iceberg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return iceberg
+}
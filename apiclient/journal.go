@@ -0,0 +1,375 @@
+package apiclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+)
+
+type IntentState string
+
+const (
+	// Written before the order is sent. An intent still pending after a restart may or may not have been placed.
+	IntentPending IntentState = "pending"
+
+	// The exchange acknowledged the order
+	IntentPlaced IntentState = "placed"
+
+	// The order was definitely not placed: rejected by the exchange or stopped before it was sent
+	IntentNotPlaced IntentState = "notPlaced"
+)
+
+// OrderIntent is an order the process meant to send, keyed by its client order ID
+type OrderIntent struct {
+	ClientOrderID models.OrderID     `json:"clientOrderId"`
+	Product       Product            `json:"product"`
+	Request       models.AddOrderReq `json:"request"`
+	State         IntentState        `json:"state"`
+	OrderID       models.OrderID     `json:"orderId,omitempty"`
+	Error         string             `json:"error,omitempty"`
+	UpdatedAt     time.Time          `json:"updatedAt"`
+}
+
+// OrderJournal durably records order intents. Records are appended; the latest one per client order ID wins.
+type OrderJournal interface {
+	Append(intent OrderIntent) error
+	Load() (map[models.OrderID]OrderIntent, error)
+}
+
+// FileJournal is an OrderJournal in a JSON lines file, synced to disk after every record
+type FileJournal struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenFileJournal opens or creates a journal. A partly written last line, left by a crash, is removed so the next
+// record starts on a line of its own; it was never synced, so its Append never returned.
+func OpenFileJournal(path string) (*FileJournal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open order journal: %w", err)
+	}
+	if err := trimTornLine(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to repair order journal: %w", err)
+	}
+	return &FileJournal{file: file}, nil
+}
+
+// trimTornLine truncates file after its last newline
+func trimTornLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4096)
+	end := info.Size()
+	for end > 0 {
+		n := min(int64(len(buf)), end)
+		if _, err := file.ReadAt(buf[:n], end-n); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			end = end - n + int64(i) + 1
+			break
+		}
+		end -= n
+	}
+	if end == info.Size() {
+		return nil
+	}
+	return file.Truncate(end)
+}
+
+func (j *FileJournal) Append(intent OrderIntent) error {
+	line, err := json.Marshal(intent)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write order journal: %w", err)
+	}
+	return j.file.Sync()
+}
+
+// Load reads the latest record of every intent. A partly written last line, left by a crash, is ignored; a corrupt
+// line anywhere else is an error.
+func (j *FileJournal) Load() (map[models.OrderID]OrderIntent, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.load()
+}
+
+// load is called with j.mu held
+func (j *FileJournal) load() (map[models.OrderID]OrderIntent, error) {
+	if _, err := j.file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	intents := map[models.OrderID]OrderIntent{}
+	scanner := bufio.NewScanner(j.file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var corrupt error
+	for line := 1; scanner.Scan(); line++ {
+		if corrupt != nil {
+			return nil, corrupt
+		}
+		var intent OrderIntent
+		if err := json.Unmarshal(scanner.Bytes(), &intent); err != nil {
+			corrupt = fmt.Errorf("corrupt order journal line %d: %w", line, err)
+			continue
+		}
+		intents[intent.ClientOrderID] = intent
+	}
+	return intents, scanner.Err()
+}
+
+// Compact rewrites the journal keeping only pending intents. Appends wait until it is done.
+func (j *FileJournal) Compact() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	intents, err := j.load()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.file.Name()), ".journal-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	for _, intent := range intents {
+		if intent.State != IntentPending {
+			continue
+		}
+		line, err := json.Marshal(intent)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err := tmp.Write(append(line, '\n')); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), j.file.Name()); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(j.file.Name(), os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file = file
+	return nil
+}
+
+func (j *FileJournal) Close() error {
+	return j.file.Close()
+}
+
+// OrderSubmitter sends orders through a journal so that an order whose outcome is unknown, e.g. after a timeout
+// or a crash, can be resolved by its client order ID instead of being sent twice
+type OrderSubmitter struct {
+	client  *ApiClient
+	journal OrderJournal
+}
+
+func (c *ApiClient) NewOrderSubmitter(journal OrderJournal) *OrderSubmitter {
+	return &OrderSubmitter{client: c, journal: journal}
+}
+
+// Submit records the intent, sends the order and records the outcome. Orders without a client order ID are given
+// one. If the outcome is unknown the intent stays pending and Submit returns the error; call Resolve before
+// sending the order again. The request may still be in flight when Submit returns, so Resolve keeps the intent
+// pending until ResolveGrace has passed: give requests a context deadline well within it.
+func (s *OrderSubmitter) Submit(product Product, req models.AddOrderReq) (*models.ApiOrder, error) {
+	if req.ClientOrderID == "" {
+		req.ClientOrderID = NewClientOrderID("")
+	}
+	intent := OrderIntent{ClientOrderID: req.ClientOrderID, Product: product, Request: req, State: IntentPending, UpdatedAt: time.Now()}
+	if err := s.journal.Append(intent); err != nil {
+		return nil, err
+	}
+
+	order, err := s.client.addOrder(product, req)
+	switch {
+	case err == nil:
+		intent.State, intent.OrderID = IntentPlaced, order.OrderID
+	case definitelyNotPlaced(err):
+		intent.State, intent.Error = IntentNotPlaced, err.Error()
+	default:
+		return nil, fmt.Errorf("order %s may have been placed: %w", req.ClientOrderID, err)
+	}
+
+	intent.UpdatedAt = time.Now()
+	if jerr := s.journal.Append(intent); jerr != nil && err == nil {
+		err = jerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// ResolveGrace is how long after an intent was recorded Resolve treats an order it can't find as possibly still in
+// flight, rather than not placed
+const ResolveGrace = 5 * time.Minute
+
+// ErrOutcomeUnknown is returned by Resolve for an intent whose order can't be found yet but may still be placed
+var ErrOutcomeUnknown = errors.New("order outcome unknown")
+
+// Resolve looks up a pending intent on the exchange by client order ID and records whether it was placed. An
+// order that can't be found but has fills is also placed. One with neither is not placed and can be sent again,
+// but only once ResolveGrace has passed since the intent was recorded; until then it stays pending and Resolve
+// returns ErrOutcomeUnknown. Intents that aren't pending are returned as they are.
+//
+// The exchange can't tell an order that was never placed from one that was cancelled without fills and is no
+// longer found by its client order ID, so an old intent whose order was cancelled is reported not placed too.
+// Resolve pending intents soon after a restart, and don't resend ones older than the exchange keeps orders for.
+func (s *OrderSubmitter) Resolve(intent OrderIntent) (OrderIntent, error) {
+	if intent.State != IntentPending {
+		return intent, nil
+	}
+
+	var order *models.GenericResponse[models.ApiOrder]
+	var err error
+	if intent.Product == ProductPerps {
+		order, err = s.client.GetPerpsOrderByClientID(models.ClientOrderID(intent.ClientOrderID))
+	} else {
+		order, err = s.client.GetSpotOrderByClientID(intent.ClientOrderID)
+	}
+
+	switch {
+	case err == nil:
+		intent.State, intent.OrderID = IntentPlaced, order.Result.OrderID
+	case IsNotFound(err):
+		// A fully filled order may no longer be found, so only fills rule out that it was placed
+		orderID, found, ferr := s.findFill(intent)
+		if ferr != nil {
+			return intent, ferr
+		}
+		switch {
+		case found:
+			intent.State, intent.OrderID = IntentPlaced, orderID
+		case time.Since(intent.UpdatedAt) < ResolveGrace:
+			return intent, fmt.Errorf("order %s not found within %s of being sent: %w", intent.ClientOrderID, ResolveGrace, ErrOutcomeUnknown)
+		default:
+			intent.State = IntentNotPlaced
+		}
+	default:
+		return intent, err
+	}
+
+	intent.UpdatedAt = time.Now()
+	return intent, s.journal.Append(intent)
+}
+
+// intentFillSlack is how long before an intent was recorded its fills are looked for, to allow for clock skew
+const intentFillSlack = time.Minute
+
+// findFill looks for a fill of the intent's order and returns the order ID it was filled under
+func (s *OrderSubmitter) findFill(intent OrderIntent) (models.OrderID, bool, error) {
+	if intent.Product != ProductPerps {
+		fills, err := s.client.GetSpotFillsByClientOrderID(intent.ClientOrderID)
+		if IsNotFound(err) {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		if len(fills.Result) > 0 {
+			return fills.Result[0].OrderID, true, nil
+		}
+		return "", false, nil
+	}
+
+	// Perps fills can't be queried by client order ID, so scan the market's fills since the intent was recorded
+	since := intent.UpdatedAt.Add(-intentFillSlack)
+	fills, err := listAllFills(s.client.GetPerpsFills, models.FillParams{Market: string(intent.Request.Market), StartTime: &since})
+	if err != nil {
+		return "", false, err
+	}
+	for _, fill := range fills {
+		if fill.ClientOrderID == intent.ClientOrderID {
+			return fill.OrderID, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+type RecoveryReport struct {
+	// Pending intents found to have been placed
+	Placed []OrderIntent
+
+	// Pending intents found not to have been placed. They can be sent again with Submit.
+	NotPlaced []OrderIntent
+
+	// Pending intents that couldn't be looked up, or may still be in flight, with the errors
+	Unresolved []OrderIntent
+	Errors     []error
+}
+
+// Recover resolves every intent left pending in the journal, e.g. on startup after a crash
+func (s *OrderSubmitter) Recover(ctx context.Context) (*RecoveryReport, error) {
+	intents, err := s.journal.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	submitter := &OrderSubmitter{client: s.client.WithContext(ctx), journal: s.journal}
+	report := &RecoveryReport{}
+	for _, intent := range intents {
+		if intent.State != IntentPending {
+			continue
+		}
+		resolved, err := submitter.Resolve(intent)
+		switch {
+		case err != nil:
+			report.Unresolved = append(report.Unresolved, intent)
+			report.Errors = append(report.Errors, fmt.Errorf("order %s: %w", intent.ClientOrderID, err))
+		case resolved.State == IntentPlaced:
+			report.Placed = append(report.Placed, resolved)
+		default:
+			report.NotPlaced = append(report.NotPlaced, resolved)
+		}
+	}
+	return report, nil
+}
+
+// listAllFills follows cursors until every page of fills matching params has been fetched, returning them oldest
+// first
+func listAllFills(list func(models.FillParams) (*models.V1PageRes[models.ApiFill], error), params models.FillParams) ([]*models.ApiFill, error) {
+	var fills []*models.ApiFill
+	for {
+		res, err := list(params)
+		if err != nil {
+			return nil, err
+		}
+		fills = append(fills, res.Result...)
+		if res.PageInfo.NextCursor == "" {
+			break
+		}
+		params.Cursor = res.PageInfo.NextCursor
+	}
+	sort.SliceStable(fills, func(i, j int) bool { return fills[i].CreatedAt.Before(fills[j].CreatedAt) })
+	return fills, nil
+}
@@ -0,0 +1,268 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+func openTestJournal(t *testing.T) *FileJournal {
+	t.Helper()
+	journal, err := OpenFileJournal(filepath.Join(t.TempDir(), "orders.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { journal.Close() })
+	return journal
+}
+
+func TestFileJournalCompactKeepsConcurrentAppends(t *testing.T) {
+	journal := openTestJournal(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := models.OrderID(fmt.Sprintf("order-%d", i))
+			if err := journal.Append(OrderIntent{ClientOrderID: id, State: IntentPending}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		if i%20 == 0 {
+			if err := journal.Compact(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	wg.Wait()
+	if err := journal.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	intents, err := journal.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(intents) != 200 {
+		t.Fatalf("%d pending intents after compaction, want 200", len(intents))
+	}
+}
+
+func TestFileJournalCompactDropsResolvedIntents(t *testing.T) {
+	journal := openTestJournal(t)
+	journal.Append(OrderIntent{ClientOrderID: "a", State: IntentPending})
+	journal.Append(OrderIntent{ClientOrderID: "b", State: IntentPending})
+	journal.Append(OrderIntent{ClientOrderID: "a", State: IntentPlaced})
+	if err := journal.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	journal.Append(OrderIntent{ClientOrderID: "c", State: IntentPending})
+
+	intents, err := journal.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := intents["a"]; ok || len(intents) != 2 {
+		t.Fatalf("intents after compaction = %v, want b and c", intents)
+	}
+}
+
+func TestOrderSubmitterSubmit(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		success   bool
+		wantState IntentState
+		wantErr   bool
+	}{
+		{name: "placed", status: http.StatusOK, success: true, wantState: IntentPlaced},
+		{name: "rejected in the body", status: http.StatusOK, success: false, wantState: IntentNotPlaced, wantErr: true},
+		{name: "bad request", status: http.StatusBadRequest, wantState: IntentNotPlaced, wantErr: true},
+		{name: "request timeout", status: http.StatusRequestTimeout, wantState: IntentPending, wantErr: true},
+		{name: "server error", status: http.StatusInternalServerError, wantState: IntentPending, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newFakeExchange(t)
+			ex.handle("POST "+models.V1SpotOrdersPath, func(req fakeRequest) (int, any) {
+				return tt.status, models.GenericResponse[models.ApiOrder]{Success: tt.success, Result: models.ApiOrder{OrderID: "o1"}}
+			})
+			journal := openTestJournal(t)
+			submitter := ex.client().NewOrderSubmitter(journal)
+
+			_, err := submitter.Submit(ProductSpot, models.AddOrderReq{Market: "AVAX-USDC", Size: decimal.NewFromInt(1)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			intents, _ := journal.Load()
+			if len(intents) != 1 {
+				t.Fatalf("%d intents, want 1", len(intents))
+			}
+			for _, intent := range intents {
+				if intent.State != tt.wantState {
+					t.Fatalf("state = %s, want %s", intent.State, tt.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderSubmitterResolve(t *testing.T) {
+	const id = models.OrderID("cid-1")
+	tests := []struct {
+		name      string
+		product   Product
+		order     func() (int, any)
+		fills     []*models.ApiFill
+		recent    bool
+		wantState IntentState
+		wantErr   bool
+	}{
+		{
+			name: "spot order found", product: ProductSpot,
+			order:     func() (int, any) { return ok(models.ApiOrder{OrderID: "o1", ClientOrderID: id}) },
+			wantState: IntentPlaced,
+		},
+		{
+			name: "spot order gone but filled", product: ProductSpot, order: notFound,
+			fills:     []*models.ApiFill{{OrderID: "o1", ClientOrderID: id}},
+			wantState: IntentPlaced,
+		},
+		{name: "spot order never placed", product: ProductSpot, order: notFound, wantState: IntentNotPlaced},
+		{name: "spot order may be in flight", product: ProductSpot, order: notFound, recent: true, wantState: IntentPending, wantErr: true},
+		{
+			name: "perps order found", product: ProductPerps,
+			order:     func() (int, any) { return ok(models.ApiOrder{OrderID: "o1", ClientOrderID: id}) },
+			wantState: IntentPlaced,
+		},
+		{
+			name: "perps order gone but filled", product: ProductPerps, order: notFound,
+			fills:     []*models.ApiFill{{OrderID: "o2", ClientOrderID: "other"}, {OrderID: "o1", ClientOrderID: id}},
+			wantState: IntentPlaced,
+		},
+		{
+			name: "perps order never placed", product: ProductPerps, order: notFound,
+			fills:     []*models.ApiFill{{OrderID: "o2", ClientOrderID: "other"}},
+			wantState: IntentNotPlaced,
+		},
+		{
+			name: "perps order may be in flight", product: ProductPerps, order: notFound, recent: true,
+			wantState: IntentPending, wantErr: true,
+		},
+		{
+			name: "lookup fails", product: ProductPerps,
+			order:     func() (int, any) { return http.StatusInternalServerError, models.GenericResponse[any]{} },
+			wantState: IntentPending, wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newFakeExchange(t)
+			ex.handle("GET "+models.V1SpotOrdersPath+"/client:"+string(id), func(fakeRequest) (int, any) { return tt.order() })
+			ex.handle("GET "+models.V1SpotOrdersPath+"/client:"+string(id)+"/fills", func(fakeRequest) (int, any) {
+				if len(tt.fills) == 0 {
+					return notFound()
+				}
+				return ok(tt.fills)
+			})
+			ex.handle("GET "+models.V1PerpsOrdersPath+"/client:"+string(id), func(fakeRequest) (int, any) { return tt.order() })
+			ex.handle("GET "+models.V1PerpsFillsPath, func(fakeRequest) (int, any) {
+				return http.StatusOK, models.V1PageRes[models.ApiFill]{Result: tt.fills}
+			})
+
+			submitter := ex.client().NewOrderSubmitter(openTestJournal(t))
+			recorded := time.Now().Add(-2 * ResolveGrace)
+			if tt.recent {
+				recorded = time.Now()
+			}
+			intent := OrderIntent{ClientOrderID: id, Product: tt.product, State: IntentPending, UpdatedAt: recorded,
+				Request: models.AddOrderReq{Market: "AVAX-USDC"}}
+			resolved, err := submitter.Resolve(intent)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if resolved.State != tt.wantState {
+				t.Fatalf("state = %s, want %s", resolved.State, tt.wantState)
+			}
+			if resolved.State == IntentPlaced && resolved.OrderID != "o1" {
+				t.Fatalf("order ID = %s, want o1", resolved.OrderID)
+			}
+		})
+	}
+}
+
+func TestOrderSubmitterResolveAfterTimeout(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("POST "+models.V1SpotOrdersPath, func(req fakeRequest) (int, any) {
+		return http.StatusGatewayTimeout, models.GenericResponse[any]{Error: "timeout"}
+	})
+	ex.handle("GET "+models.V1SpotOrdersPath+"/client:*", func(fakeRequest) (int, any) { return notFound() })
+	journal := openTestJournal(t)
+	submitter := ex.client().NewOrderSubmitter(journal)
+
+	if _, err := submitter.Submit(ProductSpot, models.AddOrderReq{Market: "AVAX-USDC", Size: decimal.NewFromInt(1)}); err == nil {
+		t.Fatal("Submit succeeded after a timeout")
+	}
+	report, err := submitter.Recover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The order may still reach the book, so it isn't offered for sending again
+	if len(report.NotPlaced) != 0 || len(report.Unresolved) != 1 || !errors.Is(report.Errors[0], ErrOutcomeUnknown) {
+		t.Fatalf("report = %+v, want the intent unresolved", report)
+	}
+	intents, _ := journal.Load()
+	for _, intent := range intents {
+		if intent.State != IntentPending {
+			t.Fatalf("state = %s, want %s", intent.State, IntentPending)
+		}
+	}
+}
+
+func TestFileJournalLoad(t *testing.T) {
+	record := `{"clientOrderId":"cid-1","state":"pending"}`
+	tests := []struct {
+		name     string
+		contents string
+		wantErr  bool
+	}{
+		{name: "complete", contents: record + "\n"},
+		{name: "torn last line", contents: record + "\n" + `{"clientOrderId":"cid-2","st`},
+		{name: "corrupt line", contents: record + "\n" + "garbage\n" + record + "\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "orders.jsonl")
+			if err := os.WriteFile(path, []byte(tt.contents), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			journal, err := OpenFileJournal(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer journal.Close()
+
+			// A record appended after a torn line starts on a line of its own
+			if err := journal.Append(OrderIntent{ClientOrderID: "cid-3", State: IntentPending}); err != nil {
+				t.Fatal(err)
+			}
+			intents, err := journal.Load()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(intents) != 2 {
+				t.Fatalf("%d intents, want cid-1 and cid-3", len(intents))
+			}
+		})
+	}
+}
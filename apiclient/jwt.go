@@ -0,0 +1,88 @@
+package apiclient
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource obtains a new JWT session token, for example by signing in with a wallet
+type TokenSource interface {
+	FetchToken() (*V0AuthToken, error)
+}
+
+// TokenSourceFunc adapts a function to a TokenSource
+type TokenSourceFunc func() (*V0AuthToken, error)
+
+func (f TokenSourceFunc) FetchToken() (*V0AuthToken, error) {
+	return f()
+}
+
+// StaticToken returns a TokenSource that always returns token, for tokens obtained out of band
+func StaticToken(token V0AuthToken) TokenSource {
+	return TokenSourceFunc(func() (*V0AuthToken, error) {
+		return &token, nil
+	})
+}
+
+// Tokens are refreshed this long before they expire
+const DefaultJWTRefreshMargin = time.Minute
+
+const authorizationHeader = "Authorization"
+
+// jwtSession caches the current token and fetches a new one when it is about to expire
+type jwtSession struct {
+	source TokenSource
+	clock  *serverClock
+
+	mu    sync.Mutex
+	token *V0AuthToken
+}
+
+// current returns a token that is valid for at least the refresh margin
+func (s *jwtSession) current() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	refreshAt := s.clock.now().Add(DefaultJWTRefreshMargin).Unix()
+	if s.token != nil && refreshAt < s.token.Expiration {
+		return s.token.Token, nil
+	}
+
+	token, err := s.source.FetchToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch jwt: %w", err)
+	}
+	if token == nil || token.Token == "" {
+		return "", fmt.Errorf("token source returned an empty jwt")
+	}
+	s.token = token
+	return token.Token, nil
+}
+
+// invalidate drops token if it is still the current one, so the next call fetches a new one. It returns false if
+// there is no session, so callers know a retry won't help.
+func (s *jwtSession) invalidate(token string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != nil && s.token.Token == token {
+		s.token = nil
+	}
+	return true
+}
+
+// WithJWT authenticates requests with JWT session tokens from source instead of signing them with an API key.
+// Tokens are refreshed shortly before they expire, and once more if the server rejects one.
+func (c *ApiClient) WithJWT(source TokenSource) *ApiClient {
+	c.jwt = &jwtSession{source: source, clock: c.clock}
+	return c
+}
+
+// bearerToken extracts the token from an Authorization header value
+func bearerToken(header string) string {
+	return strings.TrimPrefix(header, "Bearer ")
+}
@@ -0,0 +1,119 @@
+package apiclient
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+)
+
+// countingTokens issues tok1, tok2, ... each valid for ttl
+type countingTokens struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	fetched int
+}
+
+func (s *countingTokens) FetchToken() (*V0AuthToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetched++
+	return &V0AuthToken{Token: fmt.Sprintf("tok%d", s.fetched), Expiration: time.Now().Add(s.ttl).Unix()}, nil
+}
+
+func (s *countingTokens) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetched
+}
+
+func authorizations(ex *fakeExchange, route string) []string {
+	var headers []string
+	for _, req := range ex.requests(route) {
+		headers = append(headers, req.Header.Get(authorizationHeader))
+	}
+	return headers
+}
+
+func TestJWTIsCachedUntilItNearsExpiry(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("GET "+models.V1MarketsPath, func(fakeRequest) (int, any) {
+		return ok(models.V1GetMarketsResult{})
+	})
+
+	long := &countingTokens{ttl: time.Hour}
+	client := NewApiClient(ex.srv.URL).WithJWT(long)
+	client.Markets()
+	client.Markets()
+	if n := long.count(); n != 1 {
+		t.Fatalf("fetched %d tokens for a long lived session, want 1", n)
+	}
+
+	short := &countingTokens{ttl: DefaultJWTRefreshMargin / 2}
+	client = NewApiClient(ex.srv.URL).WithJWT(short)
+	client.Markets()
+	client.Markets()
+	if n := short.count(); n != 2 {
+		t.Fatalf("fetched %d tokens for tokens inside the refresh margin, want 2", n)
+	}
+
+	got := authorizations(ex, "GET "+models.V1MarketsPath)
+	want := []string{"Bearer tok1", "Bearer tok1", "Bearer tok1", "Bearer tok2"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("Authorization headers = %v, want %v", got, want)
+	}
+}
+
+func TestJWTRetriesOnceOnAuthFailure(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("GET "+models.V1MarketsPath, func(req fakeRequest) (int, any) {
+		if req.Header.Get(authorizationHeader) == "Bearer tok1" {
+			return http.StatusUnauthorized, models.GenericResponse[any]{Error: "expired"}
+		}
+		return ok(models.V1GetMarketsResult{})
+	})
+
+	tokens := &countingTokens{ttl: time.Hour}
+	client := NewApiClient(ex.srv.URL).WithJWT(tokens)
+	if _, err := client.Markets(); err != nil {
+		t.Fatalf("Markets: %v", err)
+	}
+	got := authorizations(ex, "GET "+models.V1MarketsPath)
+	if want := []string{"Bearer tok1", "Bearer tok2"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("Authorization headers = %v, want %v", got, want)
+	}
+}
+
+func TestJWTDoesNotRetryTwice(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("GET "+models.V1MarketsPath, func(fakeRequest) (int, any) {
+		return http.StatusUnauthorized, models.GenericResponse[any]{Error: "unauthorized"}
+	})
+
+	tokens := &countingTokens{ttl: time.Hour}
+	client := NewApiClient(ex.srv.URL).WithJWT(tokens)
+	if _, err := client.Markets(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := len(ex.requests("GET " + models.V1MarketsPath)); n != 2 {
+		t.Fatalf("sent %d requests, want 2", n)
+	}
+}
+
+func TestJWTWebsocketLoginArgs(t *testing.T) {
+	client := NewApiClient("https://localhost").WithJWT(StaticToken(V0AuthToken{
+		Token:      "tok",
+		Expiration: time.Now().Add(time.Hour).Unix(),
+	}))
+	args, err := client.ForSubaccount("sub").WebsocketLoginArgs()
+	if err != nil {
+		t.Fatalf("WebsocketLoginArgs: %v", err)
+	}
+	if !args.IsJWTLogin() || args.Token != "tok" || args.SubaccountId != "sub" || args.Sign != "" {
+		t.Fatalf("login args = %+v, want a JWT login for sub", args)
+	}
+}
@@ -0,0 +1,355 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+)
+
+var ErrKillSwitchEngaged = errors.New("kill switch engaged: new orders are blocked")
+
+// ErrNoCredentials is reported by a kill switch for an account it can't act on because its client has no
+// credentials
+var ErrNoCredentials = errors.New("no credentials: orders can't be cancelled or checked")
+
+// KillSwitch stops all trading activity on a client in one call. Once engaged, the client and every view of it
+// refuse to send new spot or perps orders until the switch is released.
+type KillSwitch struct {
+	client  *ApiClient
+	engaged atomic.Bool
+}
+
+type KillSwitchOptions struct {
+	// Close every perps position with reduce-only market orders after cancelling
+	FlattenPositions bool
+}
+
+// KillSwitchAccount identifies an account a kill switch blocks: a ClientPool account, or an empty Account for the
+// client the pool or switch was created from, and optionally one of its subaccounts
+type KillSwitchAccount struct {
+	Account    models.AccountID
+	Subaccount models.SubaccountID
+}
+
+func (a KillSwitchAccount) String() string {
+	account := string(a.Account)
+	if account == "" {
+		account = "main"
+	}
+	if a.Subaccount != "" {
+		account += "/" + string(a.Subaccount)
+	}
+	return account
+}
+
+// KillSwitchReport is what Engage did on each account the switch blocks
+type KillSwitchReport struct {
+	Accounts []*KillSwitchAccountReport
+
+	// Accounts where a step failed or something was left open. Empty if the switch worked.
+	Uncovered []KillSwitchAccount
+}
+
+// Err returns an error if any step failed or anything was left open on any account
+func (r *KillSwitchReport) Err() error {
+	var errs []error
+	for _, account := range r.Accounts {
+		if err := account.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("account %s: %w", account.KillSwitchAccount, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// KillSwitchAccountReport is what Engage did on one account and what was still open afterwards
+type KillSwitchAccountReport struct {
+	KillSwitchAccount
+
+	CancelledMarkets []models.Market
+	FlattenOrders    []*models.ApiOrder
+
+	// Orders and positions found when verifying. Empty if the switch worked.
+	RemainingSpotOrders  []*models.ApiOrder
+	RemainingPerpsOrders []*models.ApiOrder
+	RemainingPositions   []models.ApiPosition
+
+	Errors []error
+}
+
+// Err returns an error if any step failed or anything was left open
+func (r *KillSwitchAccountReport) Err() error {
+	err := errors.Join(r.Errors...)
+	remaining := len(r.RemainingSpotOrders) + len(r.RemainingPerpsOrders)
+	if remaining > 0 {
+		err = errors.Join(err, fmt.Errorf("%d orders still open", remaining))
+	}
+	if len(r.RemainingPositions) > 0 {
+		err = errors.Join(err, fmt.Errorf("%d positions still open", len(r.RemainingPositions)))
+	}
+	return err
+}
+
+// NewKillSwitch adds a kill switch to the client's shared state. It applies to the client and every view of it,
+// whether created before or after: ForSubaccount and WithContext copies and the clients of a ClientPool built from
+// it. A client has one kill switch, so calling NewKillSwitch again returns the existing one.
+func (c *ApiClient) NewKillSwitch() *KillSwitch {
+	if c.shared == nil {
+		c.shared = &clientShared{}
+		c.shared.register(c)
+	}
+	k := &KillSwitch{client: c}
+	if !c.shared.killSwitch.CompareAndSwap(nil, k) {
+		return c.shared.killSwitch.Load()
+	}
+	return k
+}
+
+// killSwitchEntry is an account a kill switch acts on: the account's own client and the subaccounts views of it
+// have been created for
+type killSwitchEntry struct {
+	client      *ApiClient
+	subaccounts map[models.SubaccountID]bool
+}
+
+// register records the account of c. A client without a subaccount becomes the client the account is reached
+// through, replacing any earlier one. A subaccount view only records its subaccount, so creating views repeatedly
+// doesn't grow the registry, and credentials added to the account's client later still apply to it.
+func (s *clientShared) register(c *ApiClient) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.accounts == nil {
+		s.accounts = map[models.AccountID]*killSwitchEntry{}
+	}
+	entry, ok := s.accounts[c.account]
+	if !ok {
+		entry = &killSwitchEntry{subaccounts: map[models.SubaccountID]bool{}}
+		s.accounts[c.account] = entry
+	}
+	if c.subaccountId == "" {
+		entry.client = c
+		return
+	}
+	entry.subaccounts[c.subaccountId] = true
+	if entry.client == nil {
+		entry.client = c.subaccountView("")
+	}
+}
+
+// unregister forgets a ClientPool account and its subaccounts
+func (s *clientShared) unregister(account models.AccountID) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.accounts, account)
+}
+
+// accountClients returns a client for every registered account and subaccount that has credentials, and the
+// accounts that have none, both sorted by account. The main account is left out if it has neither credentials nor
+// subaccounts, as it is then only the base of a ClientPool and can't have placed orders.
+func (s *clientShared) accountClients() ([]*ApiClient, []KillSwitchAccount) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var clients []*ApiClient
+	var skipped []KillSwitchAccount
+	for account, entry := range s.accounts {
+		subaccounts := make([]models.SubaccountID, 0, len(entry.subaccounts))
+		for id := range entry.subaccounts {
+			subaccounts = append(subaccounts, id)
+		}
+		if entry.client.signer == nil && entry.client.jwt == nil {
+			if account == "" && len(subaccounts) == 0 {
+				continue
+			}
+			skipped = append(skipped, KillSwitchAccount{Account: account})
+			for _, id := range subaccounts {
+				skipped = append(skipped, KillSwitchAccount{Account: account, Subaccount: id})
+			}
+			continue
+		}
+		clients = append(clients, entry.client)
+		for _, id := range subaccounts {
+			clients = append(clients, entry.client.subaccountView(id))
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return lessKillSwitchAccount(clients[i].killSwitchAccount(), clients[j].killSwitchAccount())
+	})
+	sort.Slice(skipped, func(i, j int) bool { return lessKillSwitchAccount(skipped[i], skipped[j]) })
+	return clients, skipped
+}
+
+func lessKillSwitchAccount(a, b KillSwitchAccount) bool {
+	if a.Account != b.Account {
+		return a.Account < b.Account
+	}
+	return a.Subaccount < b.Subaccount
+}
+
+func (c *ApiClient) killSwitchAccount() KillSwitchAccount {
+	return KillSwitchAccount{Account: c.account, Subaccount: c.subaccountId}
+}
+
+type killSwitchBypass struct{}
+
+// checkKillSwitch returns ErrKillSwitchEngaged for an order sent while the client's kill switch is engaged
+func (c *ApiClient) checkKillSwitch(ctx context.Context, method string, path string) error {
+	if c.shared == nil {
+		return nil
+	}
+	k := c.shared.killSwitch.Load()
+	if k != nil && k.engaged.Load() && method == "POST" && isOrderPath(path) && ctx.Value(killSwitchBypass{}) == nil {
+		return ErrKillSwitchEngaged
+	}
+	return nil
+}
+
+func isOrderPath(path string) bool {
+	path, _, _ = strings.Cut(path, "?")
+	switch path {
+	case models.V1SpotOrdersPath, models.V1SpotBatchOrdersPath, models.V1PerpsOrdersPath, models.V1PerpsBatchOrdersPath:
+		return true
+	}
+	return false
+}
+
+func (k *KillSwitch) Engaged() bool {
+	return k.engaged.Load()
+}
+
+// Release allows new orders again
+func (k *KillSwitch) Release() {
+	k.engaged.Store(false)
+}
+
+// Engage blocks new orders, then on every account the switch blocks cancels every spot order and the perps orders on
+// every market in the contracts list, optionally flattens perps positions, and lists orders and positions to verify
+// nothing is left open. Accounts are handled concurrently, and every step runs even if an earlier one fails. An
+// account without credentials is reported with ErrNoCredentials. New orders stay blocked until Release is called.
+func (k *KillSwitch) Engage(ctx context.Context, opts KillSwitchOptions) *KillSwitchReport {
+	k.engaged.Store(true)
+
+	clients, skipped := k.client.shared.accountClients()
+	report := &KillSwitchReport{Accounts: make([]*KillSwitchAccountReport, len(clients), len(clients)+len(skipped))}
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *ApiClient) {
+			defer wg.Done()
+			report.Accounts[i] = k.engageAccount(ctx, client, opts)
+		}(i, client)
+	}
+	wg.Wait()
+	for _, account := range skipped {
+		report.Accounts = append(report.Accounts, &KillSwitchAccountReport{KillSwitchAccount: account, Errors: []error{ErrNoCredentials}})
+	}
+
+	for _, account := range report.Accounts {
+		if account.Err() != nil {
+			report.Uncovered = append(report.Uncovered, account.KillSwitchAccount)
+		}
+	}
+	return report
+}
+
+func (k *KillSwitch) engageAccount(ctx context.Context, account *ApiClient, opts KillSwitchOptions) *KillSwitchAccountReport {
+	client := account.WithContext(ctx)
+	report := &KillSwitchAccountReport{KillSwitchAccount: account.killSwitchAccount()}
+
+	if err := client.CancelAllSpotOrders(); err != nil {
+		report.Errors = append(report.Errors, err)
+	}
+
+	contracts, err := client.GetPerpsContracts()
+	if err != nil {
+		report.Errors = append(report.Errors, err)
+	} else {
+		for _, contract := range contracts.Result {
+			if err := client.CancelAllPerpsOrdersOnMarket(contract.Market); err != nil {
+				report.Errors = append(report.Errors, fmt.Errorf("market %s: %w", contract.Market, err))
+				continue
+			}
+			report.CancelledMarkets = append(report.CancelledMarkets, contract.Market)
+		}
+	}
+
+	if opts.FlattenPositions {
+		flatten(account.WithContext(context.WithValue(ctx, killSwitchBypass{}, true)), report)
+	}
+
+	verify(client, opts, report)
+	return report
+}
+
+// flatten closes every open position with a reduce-only market order. client must bypass the switch.
+func flatten(client *ApiClient, report *KillSwitchAccountReport) {
+	positions, err := client.GetPerpsPositions()
+	if err != nil {
+		report.Errors = append(report.Errors, err)
+		return
+	}
+
+	for _, position := range positions.Result {
+		quantity := position.SignedQuantity()
+		if quantity.IsZero() {
+			continue
+		}
+		req := models.AddOrderReq{
+			Side:        models.Bid,
+			Size:        quantity.Abs(),
+			Market:      position.Market,
+			Type:        models.OrderTypeMarket,
+			TimeInForce: models.OrderTimeInForceImmediateOrCancel,
+			ReduceOnly:  true,
+		}
+		if quantity.IsPositive() {
+			req.Side = models.Ask
+		}
+		res, err := client.AddPerpsOrder(req)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Errorf("flatten %s: %w", position.Market, err))
+			continue
+		}
+		report.FlattenOrders = append(report.FlattenOrders, &res.Result)
+	}
+}
+
+func verify(client *ApiClient, opts KillSwitchOptions, report *KillSwitchAccountReport) {
+	open := models.OrderParams{Status: models.Open.String()}
+
+	spot, err := listAllOrders(client.GetSpotOrders, open)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Errorf("verify spot orders: %w", err))
+	}
+	report.RemainingSpotOrders = spot
+
+	perps, err := listAllOrders(client.GetPerpsOrders, open)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Errorf("verify perps orders: %w", err))
+	}
+	report.RemainingPerpsOrders = perps
+
+	if !opts.FlattenPositions {
+		return
+	}
+	positions, err := client.GetPerpsPositions()
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Errorf("verify positions: %w", err))
+		return
+	}
+	for _, position := range positions.Result {
+		if !position.NetQuantity.IsZero() {
+			report.RemainingPositions = append(report.RemainingPositions, position)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+	"golang.org/x/time/rate"
+)
+
+func TestKillSwitchCoversEveryView(t *testing.T) {
+	ex := newFakeExchange(t)
+	acceptSpotOrders(t, ex)
+	ex.handle("DELETE "+models.V1SpotOrdersPath, func(fakeRequest) (int, any) { return ok[any](nil) })
+	ex.handle("GET "+models.V1PerpsContractsPath, func(fakeRequest) (int, any) { return ok([]models.PerpsContract{}) })
+	noOrders := func(fakeRequest) (int, any) { return http.StatusOK, models.V1PageRes[models.ApiOrder]{} }
+	ex.handle("GET "+models.V1SpotOrdersPath, noOrders)
+	ex.handle("GET "+models.V1PerpsOrdersPath, noOrders)
+	client := ex.client()
+
+	// Views created before the switch
+	sub := client.ForSubaccount("sub-1")
+	withContext := client.WithContext(context.Background())
+	pool := NewClientPool(client, rate.Inf, 1)
+	pooled := pool.AddApiKey("account-1", "key", "secret")
+
+	ks := client.NewKillSwitch()
+	if again := sub.NewKillSwitch(); again != ks {
+		t.Fatal("a view created a second kill switch")
+	}
+	ks.Engage(context.Background(), KillSwitchOptions{})
+
+	order := models.AddOrderReq{Market: "AVAX-USDC", Size: decimal.NewFromInt(1), Price: decimal.NewFromInt(40), Type: models.OrderTypeLimit}
+	views := map[string]*ApiClient{"client": client, "subaccount": sub, "context": withContext, "pool": pooled,
+		"later view": client.ForSubaccount("sub-2")}
+	for name, view := range views {
+		if _, err := view.AddSpotOrder(order); !errors.Is(err, ErrKillSwitchEngaged) {
+			t.Fatalf("%s: err = %v, want the kill switch", name, err)
+		}
+	}
+
+	ks.Release()
+	if _, err := sub.AddSpotOrder(order); err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+}
+
+func TestKillSwitchEngagesEveryAccount(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("DELETE "+models.V1SpotOrdersPath, func(req fakeRequest) (int, any) {
+		if req.Header.Get("ENCLAVE-KEY-ID") == "key-b" {
+			return http.StatusInternalServerError, models.GenericResponse[any]{Error: "down"}
+		}
+		return ok[any](nil)
+	})
+	ex.handle("GET "+models.V1PerpsContractsPath, func(fakeRequest) (int, any) { return ok([]models.PerpsContract{}) })
+	noOrders := func(fakeRequest) (int, any) { return http.StatusOK, models.V1PageRes[models.ApiOrder]{} }
+	ex.handle("GET "+models.V1SpotOrdersPath, noOrders)
+	ex.handle("GET "+models.V1PerpsOrdersPath, noOrders)
+
+	client := ex.client()
+	client.ForSubaccount("sub-1")
+	pool := NewClientPool(client, rate.Inf, 1)
+	pool.AddApiKey("a", "key-a", "secret")
+	pool.AddApiKey("b", "key-b", "secret")
+	pool.AddApiKey("removed", "key-removed", "secret")
+	pool.Remove("removed")
+
+	report := client.NewKillSwitch().Engage(context.Background(), KillSwitchOptions{})
+
+	var accounts []string
+	for _, account := range report.Accounts {
+		accounts = append(accounts, account.String())
+	}
+	if want := []string{"main", "main/sub-1", "a", "b"}; len(accounts) != len(want) || accounts[0] != want[0] ||
+		accounts[1] != want[1] || accounts[2] != want[2] || accounts[3] != want[3] {
+		t.Fatalf("accounts = %v, want %v", accounts, want)
+	}
+
+	type cancel struct{ key, subaccount string }
+	var cancels []cancel
+	for _, req := range ex.requests("DELETE " + models.V1SpotOrdersPath) {
+		cancels = append(cancels, cancel{req.Header.Get("ENCLAVE-KEY-ID"), req.Header.Get(subaccountHeader)})
+	}
+	for _, want := range []cancel{{"key", ""}, {"key", "sub-1"}, {"key-a", ""}, {"key-b", ""}} {
+		found := false
+		for _, c := range cancels {
+			found = found || c == want
+		}
+		if !found {
+			t.Errorf("no cancel for %v in %v", want, cancels)
+		}
+	}
+	if len(cancels) != 4 {
+		t.Errorf("sent %d cancels, want 4", len(cancels))
+	}
+
+	if len(report.Uncovered) != 1 || report.Uncovered[0] != (KillSwitchAccount{Account: "b"}) {
+		t.Fatalf("uncovered = %v, want [b]", report.Uncovered)
+	}
+	if report.Err() == nil {
+		t.Fatal("Err() = nil with an uncovered account")
+	}
+}
+
+func TestKillSwitchRegistry(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("DELETE "+models.V1SpotOrdersPath, func(fakeRequest) (int, any) { return ok[any](nil) })
+	ex.handle("GET "+models.V1PerpsContractsPath, func(fakeRequest) (int, any) { return ok([]models.PerpsContract{}) })
+	noOrders := func(fakeRequest) (int, any) { return http.StatusOK, models.V1PageRes[models.ApiOrder]{} }
+	ex.handle("GET "+models.V1SpotOrdersPath, noOrders)
+	ex.handle("GET "+models.V1PerpsOrdersPath, noOrders)
+
+	// A view made before the client has credentials is still covered once it does
+	client := NewApiClient(ex.srv.URL)
+	client.ForSubaccount("sub-1")
+	client.WithApiKey("key", "secret")
+	for i := 0; i < 100; i++ {
+		client.ForSubaccount("sub-2").WithContext(context.Background())
+	}
+	if n := len(client.shared.accounts); n != 1 {
+		t.Fatalf("%d accounts registered, want 1", n)
+	}
+	if n := len(client.shared.accounts[""].subaccounts); n != 2 {
+		t.Fatalf("%d subaccounts registered, want 2", n)
+	}
+
+	pool := NewClientPool(client, rate.Inf, 1)
+	pool.AddAccount("no-key", nil).ForSubaccount("sub-3")
+
+	report := client.NewKillSwitch().Engage(context.Background(), KillSwitchOptions{})
+
+	var cancelled []string
+	for _, req := range ex.requests("DELETE " + models.V1SpotOrdersPath) {
+		if req.Header.Get("ENCLAVE-SIGN") == "" {
+			t.Fatal("cancel sent unsigned")
+		}
+		cancelled = append(cancelled, req.Header.Get(subaccountHeader))
+	}
+	if len(cancelled) != 3 {
+		t.Fatalf("cancelled on %q, want the main account and two subaccounts", cancelled)
+	}
+
+	want := []KillSwitchAccount{{Account: "no-key"}, {Account: "no-key", Subaccount: "sub-3"}}
+	if len(report.Uncovered) != 2 || report.Uncovered[0] != want[0] || report.Uncovered[1] != want[1] {
+		t.Fatalf("uncovered = %v, want %v", report.Uncovered, want)
+	}
+	if err := report.Err(); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("Err() = %v, want ErrNoCredentials", err)
+	}
+}
@@ -0,0 +1,173 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// LogLevels sets the minimum level logged by each subsystem. They can be changed while the client is in use.
+type LogLevels struct {
+	Rest      slog.LevelVar
+	Websocket slog.LevelVar
+}
+
+type clientLogging struct {
+	levels    *LogLevels
+	rest      *slog.Logger
+	websocket *slog.Logger
+}
+
+// WithLogger logs REST requests and websocket events to logger. Successful requests and routine websocket events
+// are logged at debug level, so by default only failures are logged; lower the levels from LogLevels to see more.
+func (c *ApiClient) WithLogger(logger *slog.Logger) *ApiClient {
+	levels := &LogLevels{}
+	levels.Rest.Set(slog.LevelInfo)
+	levels.Websocket.Set(slog.LevelInfo)
+
+	c.logging = &clientLogging{
+		levels:    levels,
+		rest:      slog.New(&levelHandler{min: &levels.Rest, handler: logger.Handler()}).With("subsystem", "rest"),
+		websocket: slog.New(&levelHandler{min: &levels.Websocket, handler: logger.Handler()}).With("subsystem", "websocket"),
+	}
+	return c
+}
+
+// LogLevels returns the per subsystem levels of the logger set with WithLogger, or nil if there is none
+func (c *ApiClient) LogLevels() *LogLevels {
+	if c.logging == nil {
+		return nil
+	}
+	return c.logging.levels
+}
+
+func (l *clientLogging) restLogger() *slog.Logger {
+	if l == nil {
+		return discardLogger
+	}
+	return l.rest
+}
+
+func (l *clientLogging) websocketLogger() *slog.Logger {
+	if l == nil {
+		return discardLogger
+	}
+	return l.websocket
+}
+
+var discardLogger = slog.New(discardHandler{})
+
+const requestIdHeader = "X-Request-Id"
+
+// logRequest logs a completed REST request: failures at warn, or error if no response was received, and successes
+// at debug along with the redacted request and response bodies
+func (l *clientLogging) logRequest(method, path string, status int, latency time.Duration, requestId string, req any, res any, err error) {
+	logger := l.restLogger()
+
+	level := slog.LevelDebug
+	switch {
+	case err != nil && status == 0:
+		level = slog.LevelError
+	case err != nil:
+		level = slog.LevelWarn
+	}
+	ctx := context.Background()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("path", path),
+		slog.Int("status", status),
+		slog.Duration("latency", latency),
+	}
+	if requestId != "" {
+		attrs = append(attrs, slog.String("requestId", requestId))
+	}
+	if logger.Enabled(ctx, slog.LevelDebug) {
+		attrs = append(attrs, slog.String("request", redactedJSON(req)), slog.String("response", redactedJSON(res)))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	logger.LogAttrs(ctx, level, "rest request", attrs...)
+}
+
+// levelHandler drops records below a subsystem's minimum level before they reach the wrapped handler
+type levelHandler struct {
+	min     slog.Leveler
+	handler slog.Handler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.min.Level() && h.handler.Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{min: h.min, handler: h.handler.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{min: h.min, handler: h.handler.WithGroup(name)}
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// Values of these JSON keys are replaced before bodies are logged
+var redactedKeys = map[string]bool{
+	"token":      true,
+	"sign":       true,
+	"signature":  true,
+	"secret":     true,
+	"keysecret":  true,
+	"password":   true,
+	"passphrase": true,
+}
+
+// redactedJSON marshals v with the values of sensitive keys replaced
+func redactedJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "<unmarshalable>"
+	}
+
+	var parsed any
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return string(data)
+	}
+	redacted, err := json.Marshal(redact(parsed))
+	if err != nil {
+		return string(data)
+	}
+	return string(redacted)
+}
+
+func redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, field := range v {
+			if redactedKeys[strings.ToLower(k)] {
+				v[k] = "REDACTED"
+			} else {
+				v[k] = redact(field)
+			}
+		}
+	case []any:
+		for i, elem := range v {
+			v[i] = redact(elem)
+		}
+	}
+	return v
+}
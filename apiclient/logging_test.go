@@ -0,0 +1,107 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+)
+
+// logRecords decodes the records written by a slog.JSONHandler
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("bad log line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRestLogging(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(requestIdHeader, "req-1")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(int(status.Load()))
+		w.Write([]byte(`{"success":true,"result":{"symbol":"AVAX","totalBalance":"1"}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	var buf bytes.Buffer
+	client := NewApiClient(srv.URL).WithApiKey("key", "secret").WithLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	client.GetBalance(models.GetBalanceReq{Symbol: "AVAX"})
+	if buf.Len() != 0 {
+		t.Fatalf("successful request logged at the default level:\n%s", buf.String())
+	}
+
+	client.LogLevels().Rest.Set(slog.LevelDebug)
+	client.GetBalance(models.GetBalanceReq{Symbol: "AVAX"})
+	records := logRecords(t, &buf)
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1:\n%s", len(records), buf.String())
+	}
+	record := records[0]
+	for key, want := range map[string]any{
+		"level":     "DEBUG",
+		"subsystem": "rest",
+		"method":    "POST",
+		"path":      "/v0/get_balance",
+		"status":    float64(http.StatusOK),
+		"requestId": "req-1",
+		"request":   `{"symbol":"AVAX"}`,
+	} {
+		if record[key] != want {
+			t.Errorf("%s = %v, want %v", key, record[key], want)
+		}
+	}
+	if _, ok := record["latency"]; !ok {
+		t.Error("record has no latency")
+	}
+
+	// Failures are logged at warn even when the subsystem is back at the default level
+	buf.Reset()
+	client.LogLevels().Rest.Set(slog.LevelInfo)
+	status.Store(http.StatusBadRequest)
+	client.GetBalance(models.GetBalanceReq{Symbol: "AVAX"})
+	records = logRecords(t, &buf)
+	if len(records) != 1 || records[0]["level"] != "WARN" || records[0]["error"] == nil {
+		t.Fatalf("records = %v, want one warning with the error", records)
+	}
+	if _, ok := records[0]["request"]; ok {
+		t.Fatal("bodies logged above debug level")
+	}
+
+	// The websocket level is independent of the REST level
+	client.LogLevels().Rest.Set(slog.LevelDebug)
+	if client.logging.websocketLogger().Enabled(context.Background(), slog.LevelDebug) {
+		t.Fatal("raising the REST level enabled websocket debug logs")
+	}
+}
+
+func TestRedactedJSON(t *testing.T) {
+	got := redactedJSON(map[string]any{
+		"token":  "jwt",
+		"market": "AVAX-USDC",
+		"args":   []any{map[string]any{"Sign": "abc", "key": "id"}},
+	})
+	want := `{"args":[{"Sign":"REDACTED","key":"id"}],"market":"AVAX-USDC","token":"REDACTED"}`
+	if got != want {
+		t.Fatalf("redactedJSON() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,257 @@
+package apiclient
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+// LotMethod chooses which open lots a disposal is matched against
+type LotMethod int
+
+const (
+	// First in, first out: the oldest lot is disposed of first
+	FIFO LotMethod = iota
+
+	// Last in, first out: the newest lot is disposed of first
+	LIFO
+
+	// All acquisitions in a market are pooled into one lot at their average cost
+	AverageCost
+)
+
+func (m LotMethod) String() string {
+	switch m {
+	case FIFO:
+		return "fifo"
+	case LIFO:
+		return "lifo"
+	case AverageCost:
+		return "average"
+	default:
+		return "unknown"
+	}
+}
+
+func ParseLotMethod(s string) (LotMethod, error) {
+	switch s {
+	case "fifo":
+		return FIFO, nil
+	case "lifo":
+		return LIFO, nil
+	case "average":
+		return AverageCost, nil
+	default:
+		return 0, fmt.Errorf("unknown lot method: %s", s)
+	}
+}
+
+// Lot is a holding bought by one spot fill, or by several when pooled at average cost. Its Value is its cost
+// including fees.
+type Lot struct {
+	Market   models.Market   `json:"market"`
+	FillID   models.FillID   `json:"fillId"`
+	OpenedAt time.Time       `json:"openedAt"`
+	Size     decimal.Decimal `json:"size"`
+	Value    decimal.Decimal `json:"value"`
+}
+
+// Disposal is the part of a sell that closed an open lot, with the gain it realized
+type Disposal struct {
+	Market models.Market `json:"market"`
+	FillID models.FillID `json:"fillId"`
+	Time   time.Time     `json:"time"`
+
+	LotFillID   models.FillID `json:"lotFillId"`
+	LotOpenedAt time.Time     `json:"lotOpenedAt"`
+
+	Size decimal.Decimal `json:"size"`
+
+	// Sale value net of fees, and purchase cost including fees
+	Proceeds  decimal.Decimal `json:"proceeds"`
+	CostBasis decimal.Decimal `json:"costBasis"`
+	Gain      decimal.Decimal `json:"gain"`
+}
+
+// LotMatcher matches spot sells against the lots opened by earlier buys per market, and records a Disposal for
+// every lot, or part of one, that a sell closes. Fees are taken to be in the quote currency and are added to the
+// cost of buys and taken from the proceeds of sells. Perps fills aren't matched: the exchange reports their
+// realized PnL on each fill.
+type LotMatcher struct {
+	method    LotMethod
+	lots      map[models.Market][]*Lot
+	disposals []Disposal
+}
+
+func NewLotMatcher(method LotMethod) *LotMatcher {
+	return &LotMatcher{method: method, lots: map[models.Market][]*Lot{}}
+}
+
+// Add matches a spot fill, returning the disposals it made. Fills must be added oldest first. A sell larger than
+// the open lots is an error, as its cost basis is unknown: it usually means the fills don't start from the first
+// purchase of what was sold. The part of the sell that could be matched is still recorded.
+func (m *LotMatcher) Add(fill *models.ApiFill) ([]Disposal, error) {
+	if !fill.Size.IsPositive() {
+		return nil, nil
+	}
+	if fill.UnknownSide != "" {
+		return nil, fmt.Errorf("fill %s has side %q, which this version of the SDK doesn't know", fill.FillID, fill.UnknownSide)
+	}
+
+	fee := netFee(fill)
+	if fill.Side == models.Bid {
+		m.open(&Lot{
+			Market:   fill.Market,
+			FillID:   fill.FillID,
+			OpenedAt: fill.CreatedAt,
+			Size:     fill.Size,
+			Value:    fill.Cost.Add(fee),
+		})
+		return nil, nil
+	}
+
+	proceeds := fill.Cost.Sub(fee)
+	var disposals []Disposal
+	remaining := fill.Size
+	for remaining.IsPositive() {
+		lot := m.next(fill.Market)
+		if lot == nil {
+			break
+		}
+		size := decimal.Min(remaining, lot.Size)
+		costBasis := lot.Value.Mul(size).Div(lot.Size)
+
+		disposal := Disposal{
+			Market:      fill.Market,
+			FillID:      fill.FillID,
+			Time:        fill.CreatedAt,
+			LotFillID:   lot.FillID,
+			LotOpenedAt: lot.OpenedAt,
+			Size:        size,
+			Proceeds:    proceeds.Mul(size).Div(fill.Size),
+			CostBasis:   costBasis,
+		}
+		disposal.Gain = disposal.Proceeds.Sub(disposal.CostBasis)
+		disposals = append(disposals, disposal)
+
+		lot.Size = lot.Size.Sub(size)
+		lot.Value = lot.Value.Sub(costBasis)
+		if !lot.Size.IsPositive() {
+			m.remove(fill.Market, lot)
+		}
+		remaining = remaining.Sub(size)
+	}
+
+	m.disposals = append(m.disposals, disposals...)
+	if remaining.IsPositive() {
+		return disposals, fmt.Errorf("fill %s sells %s more %s than was bought in the fills before it", fill.FillID, remaining, fill.Market)
+	}
+	return disposals, nil
+}
+
+// next returns the lot that a sell on market closes next, nil if there is none
+func (m *LotMatcher) next(market models.Market) *Lot {
+	lots := m.lots[market]
+	if len(lots) == 0 {
+		return nil
+	}
+	if m.method == LIFO {
+		return lots[len(lots)-1]
+	}
+	return lots[0]
+}
+
+func (m *LotMatcher) remove(market models.Market, lot *Lot) {
+	lots := m.lots[market]
+	for i, l := range lots {
+		if l == lot {
+			m.lots[market] = append(lots[:i:i], lots[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *LotMatcher) open(lot *Lot) {
+	lots := m.lots[lot.Market]
+	if m.method == AverageCost && len(lots) == 1 {
+		lots[0].Size = lots[0].Size.Add(lot.Size)
+		lots[0].Value = lots[0].Value.Add(lot.Value)
+		return
+	}
+	m.lots[lot.Market] = append(lots, lot)
+}
+
+// OpenLots returns copies of the lots still open in market, oldest first
+func (m *LotMatcher) OpenLots(market models.Market) []Lot {
+	lots := make([]Lot, 0, len(m.lots[market]))
+	for _, lot := range m.lots[market] {
+		lots = append(lots, *lot)
+	}
+	return lots
+}
+
+// Disposals returns every disposal made so far, in the order of the fills that made them
+func (m *LotMatcher) Disposals() []Disposal {
+	return append([]Disposal(nil), m.disposals...)
+}
+
+// RealizedGain returns the total gain of the disposals made in market
+func (m *LotMatcher) RealizedGain(market models.Market) decimal.Decimal {
+	gain := decimal.Zero
+	for _, disposal := range m.disposals {
+		if disposal.Market == market {
+			gain = gain.Add(disposal.Gain)
+		}
+	}
+	return gain
+}
+
+// MatchLots matches the spot fills, oldest first, and returns the disposals and the matcher holding the open lots.
+// Perps fills are skipped. It fails on the first sell that can't be matched completely; fills must start from the
+// account's first purchase of every market they sell in.
+func MatchLots(fills []ProductFill, method LotMethod) ([]Disposal, *LotMatcher, error) {
+	matcher := NewLotMatcher(method)
+	for _, fill := range fills {
+		if fill.Product != ProductSpot {
+			continue
+		}
+		if _, err := matcher.Add(fill.ApiFill); err != nil {
+			return nil, nil, err
+		}
+	}
+	return matcher.Disposals(), matcher, nil
+}
+
+var disposalCSVHeader = []string{
+	"time", "market", "fillId", "lotFillId", "lotOpenedAt", "size", "proceeds", "costBasis", "gain",
+}
+
+// WriteDisposalsCSV writes disposals as CSV with a header row
+func WriteDisposalsCSV(w io.Writer, disposals []Disposal) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(disposalCSVHeader); err != nil {
+		return err
+	}
+	for _, disposal := range disposals {
+		err := cw.Write([]string{
+			disposal.Time.UTC().Format(time.RFC3339Nano),
+			string(disposal.Market),
+			string(disposal.FillID),
+			string(disposal.LotFillID),
+			disposal.LotOpenedAt.UTC().Format(time.RFC3339Nano),
+			disposal.Size.String(),
+			disposal.Proceeds.String(),
+			disposal.CostBasis.String(),
+			disposal.Gain.String(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
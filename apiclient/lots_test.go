@@ -0,0 +1,110 @@
+package apiclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+func lotFill(product Product, id string, side models.BidAsk, size, price string) ProductFill {
+	s, p := decimal.RequireFromString(size), decimal.RequireFromString(price)
+	return ProductFill{Product: product, ApiFill: &models.ApiFill{FillID: models.FillID(id), Market: "AVAX-USDC",
+		Side: side, Size: s, Price: p, Cost: s.Mul(p), CreatedAt: time.Unix(int64(len(id)), 0)}}
+}
+
+func TestMatchLots(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    LotMethod
+		fills     []ProductFill
+		wantGains []string
+		wantOpen  string
+		wantErr   bool
+	}{
+		{
+			name:   "fifo",
+			method: FIFO,
+			fills: []ProductFill{
+				lotFill(ProductSpot, "b1", models.Bid, "1", "10"), lotFill(ProductSpot, "b2", models.Bid, "1", "20"),
+				lotFill(ProductSpot, "s1", models.Ask, "1.5", "30"),
+			},
+			wantGains: []string{"20", "5"}, wantOpen: "0.5",
+		},
+		{
+			name:   "lifo",
+			method: LIFO,
+			fills: []ProductFill{
+				lotFill(ProductSpot, "b1", models.Bid, "1", "10"), lotFill(ProductSpot, "b2", models.Bid, "1", "20"),
+				lotFill(ProductSpot, "s1", models.Ask, "1.5", "30"),
+			},
+			wantGains: []string{"10", "10"}, wantOpen: "0.5",
+		},
+		{
+			name:   "average cost",
+			method: AverageCost,
+			fills: []ProductFill{
+				lotFill(ProductSpot, "b1", models.Bid, "1", "10"), lotFill(ProductSpot, "b2", models.Bid, "1", "20"),
+				lotFill(ProductSpot, "s1", models.Ask, "1", "30"),
+			},
+			wantGains: []string{"15"}, wantOpen: "1",
+		},
+		{
+			name:   "perps fills are skipped",
+			method: FIFO,
+			fills: []ProductFill{
+				lotFill(ProductSpot, "b1", models.Bid, "1", "10"), lotFill(ProductPerps, "p1", models.Ask, "1", "50"),
+				lotFill(ProductPerps, "p2", models.Bid, "2", "40"), lotFill(ProductSpot, "s1", models.Ask, "1", "30"),
+			},
+			wantGains: []string{"20"}, wantOpen: "0",
+		},
+		{
+			name:    "sell without a lot",
+			method:  FIFO,
+			fills:   []ProductFill{lotFill(ProductSpot, "s1", models.Ask, "1", "30")},
+			wantErr: true,
+		},
+		{
+			name:   "fill with an unknown side",
+			method: FIFO,
+			fills: []ProductFill{
+				lotFill(ProductSpot, "b1", models.Bid, "1", "10"),
+				{Product: ProductSpot, ApiFill: &models.ApiFill{FillID: "x1", Market: "AVAX-USDC", Size: decimal.NewFromInt(1), UnknownSide: "short"}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "sell larger than the lots",
+			method:  FIFO,
+			fills:   []ProductFill{lotFill(ProductSpot, "b1", models.Bid, "1", "10"), lotFill(ProductSpot, "s1", models.Ask, "2", "30")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disposals, matcher, err := MatchLots(tt.fills, tt.method)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(disposals) != len(tt.wantGains) {
+				t.Fatalf("%d disposals, want %d", len(disposals), len(tt.wantGains))
+			}
+			for i, want := range tt.wantGains {
+				if !disposals[i].Gain.Equal(decimal.RequireFromString(want)) {
+					t.Fatalf("disposal %d gained %s, want %s", i, disposals[i].Gain, want)
+				}
+			}
+			open := decimal.Zero
+			for _, lot := range matcher.OpenLots("AVAX-USDC") {
+				open = open.Add(lot.Size)
+			}
+			if !open.Equal(decimal.RequireFromString(tt.wantOpen)) {
+				t.Fatalf("open lots hold %s, want %s", open, tt.wantOpen)
+			}
+		})
+	}
+}
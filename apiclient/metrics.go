@@ -0,0 +1,76 @@
+package apiclient
+
+import (
+	"strings"
+	"time"
+)
+
+// Metrics receives measurements of the client's behaviour. NewPrometheusMetrics provides an implementation that
+// can be served in the Prometheus text format.
+type Metrics interface {
+	// ObserveRequest is called after every REST request. status is 0 if no response was received.
+	ObserveRequest(method string, endpoint string, status int, latency time.Duration)
+
+	// ObserveOrderSubmit is called with the time from sending an order until it was acknowledged or rejected
+	ObserveOrderSubmit(product Product, latency time.Duration, accepted bool)
+
+	// ObserveRateLimitWait is called with the time a request waited for the client's rate limiter
+	ObserveRateLimitWait(wait time.Duration)
+
+	// IncWebsocketMessages is called for every update received on a websocket channel
+	IncWebsocketMessages(channel ChannelType)
+
+	// IncWebsocketReconnects is called every time a websocket connection is re-established
+	IncWebsocketReconnects()
+
+	// ObservePingRTT is called with the time between sending a websocket ping and receiving its pong
+	ObservePingRTT(rtt time.Duration)
+
+	// IncRiskRejections is called when a RiskEngine rejects an order
+	IncRiskRejections(check RiskCheck)
+}
+
+// Product distinguishes spot and perps trading
+type Product string
+
+const (
+	ProductSpot  Product = "spot"
+	ProductPerps Product = "perps"
+)
+
+// WithMetrics reports the client's requests, orders and websocket activity to m
+func (c *ApiClient) WithMetrics(m Metrics) *ApiClient {
+	c.metrics = m
+	return c
+}
+
+func (c *ApiClient) getMetrics() Metrics {
+	if c.metrics == nil {
+		return noopMetrics{}
+	}
+	return c.metrics
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveRequest(string, string, int, time.Duration) {}
+func (noopMetrics) ObserveOrderSubmit(Product, time.Duration, bool)   {}
+func (noopMetrics) ObserveRateLimitWait(time.Duration)                {}
+func (noopMetrics) IncWebsocketMessages(ChannelType)                  {}
+func (noopMetrics) IncWebsocketReconnects()                           {}
+func (noopMetrics) ObservePingRTT(time.Duration)                      {}
+func (noopMetrics) IncRiskRejections(RiskCheck)                       {}
+
+// endpointLabel strips the query from path and replaces order IDs with a placeholder, so that metrics have one
+// series per endpoint rather than per order
+func endpointLabel(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+
+	segments := strings.Split(path, "/")
+	for i := 1; i < len(segments); i++ {
+		if segments[i-1] == "orders" && segments[i] != "batch" {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,133 @@
+package apiclient
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+)
+
+// Call is a REST call passing through the interceptor chain
+type Call struct {
+	Context context.Context
+	Method  string
+	Path    string
+
+	// The ClientPool account and subaccount the call is made for, both empty for the client's main account
+	Account    models.AccountID
+	Subaccount models.SubaccountID
+
+	// Request headers. Interceptors may add headers. The innermost handler waits for the client's rate limiter and
+	// adds the auth headers each time the call is sent, so a call sent again by an interceptor is signed afresh.
+	Headers map[string]string
+
+	// The request body, to be treated as read only
+	Request any
+
+	// Set once the next handler returns: the decoded reply, a non-nil pointer to the method's response type or nil,
+	// and the HTTP status, 0 if no response was received
+	Response   any
+	StatusCode int
+}
+
+// GenericResponse returns the decoded reply if it reports success in its body, as GenericResponse does
+func (c *Call) GenericResponse() (models.Response, bool) {
+	r, ok := c.Response.(models.Response)
+	return r, ok
+}
+
+// Handler performs a call
+type Handler func(call *Call) error
+
+// Interceptor wraps every REST call made by a client. It must call next to continue the chain, and may inspect
+// or change the call before, and the response and error after.
+type Interceptor func(call *Call, next Handler) error
+
+// interceptorChain is a client's interceptors. Each view of a client gets its own copy, so interceptors added to a
+// view don't apply to the client it was created from.
+type interceptorChain struct {
+	mu           sync.RWMutex
+	interceptors []Interceptor
+}
+
+// clone returns a copy of the chain, or an empty chain if c is nil
+func (c *interceptorChain) clone() *interceptorChain {
+	if c == nil {
+		return &interceptorChain{}
+	}
+	return &interceptorChain{interceptors: c.list()}
+}
+
+func (c *interceptorChain) list() []Interceptor {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	// Appends always copy, so the slice is never written to once read
+	return c.interceptors
+}
+
+// Use appends interceptors to the client's chain. The first interceptor added is the outermost. It is safe to call
+// while the client is sending requests, which use the chain as it was when they started.
+func (c *ApiClient) Use(interceptors ...Interceptor) *ApiClient {
+	if c.interceptors == nil {
+		c.interceptors = &interceptorChain{}
+	}
+	chain := c.interceptors
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	list := make([]Interceptor, 0, len(chain.interceptors)+len(interceptors))
+	list = append(list, chain.interceptors...)
+	chain.interceptors = append(list, interceptors...)
+	return c
+}
+
+// runInterceptors passes call through the chain, ending in transport
+func (c *ApiClient) runInterceptors(call *Call, transport Handler) error {
+	interceptors := c.interceptors.list()
+	h := transport
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], h
+		h = func(call *Call) error {
+			return interceptor(call, next)
+		}
+	}
+	return h(call)
+}
+
+// HeaderInterceptor adds a header to every request
+func HeaderInterceptor(key, value string) Interceptor {
+	return func(call *Call, next Handler) error {
+		call.Headers[key] = value
+		return next(call)
+	}
+}
+
+// RetryInterceptor retries GET requests that failed without a response or with a 5xx status, up to maxAttempts
+// attempts in total, doubling the wait after each one starting from backoff. Other methods are never retried
+// because they may have taken effect.
+func RetryInterceptor(maxAttempts int, backoff time.Duration) Interceptor {
+	return func(call *Call, next Handler) error {
+		if call.Method != "GET" {
+			return next(call)
+		}
+
+		wait := backoff
+		for attempt := 1; ; attempt++ {
+			err := next(call)
+			retryable := err != nil && (call.StatusCode == 0 || call.StatusCode >= 500)
+			if !retryable || attempt >= maxAttempts {
+				return err
+			}
+
+			select {
+			case <-call.Context.Done():
+				return err
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+	}
+}
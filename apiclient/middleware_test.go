@@ -0,0 +1,66 @@
+package apiclient
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"golang.org/x/time/rate"
+)
+
+func TestRetryInterceptorSignsAndWaitsForEachAttempt(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("GET "+models.V1MarketsPath, func(fakeRequest) (int, any) {
+		return http.StatusServiceUnavailable, models.GenericResponse[any]{}
+	})
+	signer := &countingSigner{}
+	limiter := rate.NewLimiter(rate.Every(time.Hour), 3)
+	client := NewApiClient(ex.srv.URL).WithSigner(signer).WithRateLimiter(limiter).Use(RetryInterceptor(3, time.Millisecond))
+
+	if _, err := client.Markets(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := len(ex.requests("GET " + models.V1MarketsPath)); n != 3 {
+		t.Fatalf("sent %d requests, want 3", n)
+	}
+	if n := signer.signed.Load(); n != 3 {
+		t.Fatalf("signed %d times, want 3", n)
+	}
+	if tokens := limiter.Tokens(); tokens >= 1 {
+		t.Fatalf("%v limiter tokens left, want every attempt to take one", tokens)
+	}
+}
+
+func TestUseWhileSending(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("GET "+models.V1MarketsPath, func(fakeRequest) (int, any) { return ok(models.V1GetMarketsResult{}) })
+	client := ex.client()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := client.Markets(); err != nil {
+					t.Error(err)
+				}
+				client.Use(HeaderInterceptor("X-Test", "1"))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestUseOnViewDoesNotChangeClient(t *testing.T) {
+	client := NewApiClient("http://localhost").Use(HeaderInterceptor("A", "1"))
+	sub := client.ForSubaccount("sub-1").Use(HeaderInterceptor("B", "1"))
+	if n := len(client.interceptors.list()); n != 1 {
+		t.Fatalf("client has %d interceptors, want 1", n)
+	}
+	if n := len(sub.interceptors.list()); n != 2 {
+		t.Fatalf("view has %d interceptors, want 2", n)
+	}
+}
@@ -0,0 +1,156 @@
+package apiclient
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+// TrackedOrder is an open order known to an OrderTracker
+type TrackedOrder struct {
+	Product Product
+	*models.ApiOrder
+}
+
+// OrderTracker keeps the account's open orders in memory: seeded from the exchange with Sync or
+// AccountState.ApplyTo, added to with Track as orders are placed, and updated from fills until they are completely
+// filled or removed with Remove on cancel.
+type OrderTracker struct {
+	mu     sync.RWMutex
+	orders map[models.OrderID]*TrackedOrder
+}
+
+func NewOrderTracker() *OrderTracker {
+	return &OrderTracker{orders: map[models.OrderID]*TrackedOrder{}}
+}
+
+// Track adds an order placed by the process, unless it is no longer open
+func (t *OrderTracker) Track(product Product, order *models.ApiOrder) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.track(product, order)
+}
+
+// track is called with t.mu held
+func (t *OrderTracker) track(product Product, order *models.ApiOrder) {
+	if order.State != models.New && order.State != models.Open {
+		delete(t.orders, order.OrderID)
+		return
+	}
+	copied := *order
+	t.orders[order.OrderID] = &TrackedOrder{Product: product, ApiOrder: &copied}
+}
+
+// Remove stops tracking an order, e.g. once it is cancelled
+func (t *OrderTracker) Remove(id models.OrderID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.orders, id)
+}
+
+// Order returns a copy of a tracked order
+func (t *OrderTracker) Order(id models.OrderID) (TrackedOrder, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	order, ok := t.orders[id]
+	if !ok {
+		return TrackedOrder{}, false
+	}
+	copied := *order.ApiOrder
+	return TrackedOrder{Product: order.Product, ApiOrder: &copied}, true
+}
+
+// Orders returns copies of the tracked orders for product
+func (t *OrderTracker) Orders(product Product) []TrackedOrder {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var orders []TrackedOrder
+	for _, order := range t.orders {
+		if order.Product == product {
+			copied := *order.ApiOrder
+			orders = append(orders, TrackedOrder{Product: product, ApiOrder: &copied})
+		}
+	}
+	return orders
+}
+
+// Count returns the number of tracked orders
+func (t *OrderTracker) Count() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.orders)
+}
+
+// OpenQuantity returns the unfilled quantity of the tracked orders on side of market
+func (t *OrderTracker) OpenQuantity(market models.Market, side models.BidAsk) decimal.Decimal {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	quantity := decimal.Zero
+	for _, order := range t.orders {
+		if order.Market == market && order.Side == side && order.UnknownSide == "" {
+			quantity = quantity.Add(order.OrderQuantity.Sub(order.FilledQuantity))
+		}
+	}
+	return quantity
+}
+
+// OnFill adds a fill to its order's filled quantity and cost, and stops tracking the order once it is completely
+// filled
+func (t *OrderTracker) OnFill(fill *models.ApiFill) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	order, ok := t.orders[fill.OrderID]
+	if !ok {
+		return
+	}
+	order.FilledQuantity = order.FilledQuantity.Add(fill.Size)
+	order.FilledCost = order.FilledCost.Add(fill.Cost)
+	order.Fee = order.Fee.Add(fill.Fee)
+	if order.FilledQuantity.GreaterThanOrEqual(order.OrderQuantity) {
+		delete(t.orders, fill.OrderID)
+	}
+}
+
+// HandleEvent passes fills from a dispatcher event to OnFill
+func (t *OrderTracker) HandleEvent(event Event) {
+	if fill, ok := event.Data.(*models.ApiFill); ok {
+		t.OnFill(fill)
+	}
+}
+
+// Attach feeds the tracker from d's spot and perps fills channels, which still need to be subscribed
+func (t *OrderTracker) Attach(d *Dispatcher) {
+	d.Handle(FillsSpot(), "", HandlerOptions{Policy: Block}, t.HandleEvent)
+	d.Handle(FillsPerps(), "", HandlerOptions{Policy: Block}, t.HandleEvent)
+}
+
+// Sync replaces the tracked orders with the account's open orders
+func (t *OrderTracker) Sync(ctx context.Context, client *ApiClient) error {
+	client = client.WithContext(ctx)
+	open := models.OrderParams{Status: models.Open.String()}
+	spot, err := listAllOrders(client.GetSpotOrders, open)
+	if err != nil {
+		return err
+	}
+	perps, err := listAllOrders(client.GetPerpsOrders, open)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reset(map[Product][]*models.ApiOrder{ProductSpot: spot, ProductPerps: perps})
+	return nil
+}
+
+// reset replaces the tracked orders, called with t.mu held
+func (t *OrderTracker) reset(orders map[Product][]*models.ApiOrder) {
+	t.orders = map[models.OrderID]*TrackedOrder{}
+	for product, list := range orders {
+		for _, order := range list {
+			t.track(product, order)
+		}
+	}
+}
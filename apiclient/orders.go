@@ -0,0 +1,102 @@
+package apiclient
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+)
+
+// NewClientOrderID returns a random client order ID starting with prefix
+func NewClientOrderID(prefix string) models.OrderID {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate client order id: %v", err))
+	}
+	return models.OrderID(prefix + hex.EncodeToString(b))
+}
+
+// orderRejectedError is returned by addOrder when the exchange answered but reported the order unsuccessful
+type orderRejectedError struct {
+	err error
+}
+
+func (e *orderRejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *orderRejectedError) Unwrap() error {
+	return e.err
+}
+
+// definitelyNotPlaced reports whether a failed order submission certainly didn't reach the order book
+func definitelyNotPlaced(err error) bool {
+	if errors.Is(err, ErrKillSwitchEngaged) || errors.Is(err, ErrRiskLimit) {
+		return true
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 400 && statusErr.StatusCode < 500 && statusErr.StatusCode != http.StatusRequestTimeout
+	}
+	var rejected *orderRejectedError
+	return errors.As(err, &rejected)
+}
+
+// addOrder sends an order for product
+func (client *ApiClient) addOrder(product Product, req models.AddOrderReq) (*models.ApiOrder, error) {
+	add := client.AddSpotOrder
+	if product == ProductPerps {
+		add = client.AddPerpsOrder
+	}
+	res, err := add(req)
+	if err != nil {
+		if res != nil && !res.Success {
+			return nil, &orderRejectedError{err: err}
+		}
+		return nil, err
+	}
+	return &res.Result, nil
+}
+
+// orderClosed reports whether an order was cancelled or rejected, so it is no longer on the book and won't fill
+// any further
+func orderClosed(order *models.ApiOrder) bool {
+	return order.State == models.Canceled || order.State == models.Rejected
+}
+
+// cancelOrderByClientID cancels an order for product by its client order ID
+func (client *ApiClient) cancelOrderByClientID(product Product, clientOrderId models.OrderID) error {
+	if product != ProductPerps {
+		_, err := client.CancelSpotOrderByClientID(clientOrderId)
+		return err
+	}
+
+	res, err := client.CancelPerpsOrdersByClientId([]models.ClientOrderID{models.ClientOrderID(clientOrderId)})
+	if err != nil {
+		return err
+	}
+	if len(res.Result.FailedCancels) > 0 {
+		return fmt.Errorf("bad request perps cancel order by client id %s: %s", clientOrderId, res.Result.FailedCancels[0].Error)
+	}
+	return nil
+}
+
+// getOrderByClientID looks up an order for product by its client order ID
+func (client *ApiClient) getOrderByClientID(product Product, clientOrderId models.OrderID) (*models.ApiOrder, error) {
+	if product != ProductPerps {
+		res, err := client.GetSpotOrderByClientID(clientOrderId)
+		if err != nil {
+			return nil, err
+		}
+		return &res.Result, nil
+	}
+
+	res, err := client.GetPerpsOrderByClientID(models.ClientOrderID(clientOrderId))
+	if err != nil {
+		return nil, err
+	}
+	return &res.Result, nil
+}
@@ -0,0 +1,359 @@
+package apiclient
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+// PegReference is the price a pegged order follows
+type PegReference int
+
+const (
+	// The best bid for buy orders and the best ask for sell orders
+	PegBest PegReference = iota
+
+	// The middle of the best bid and ask
+	PegMid
+)
+
+// PegOrder is a post-only limit order kept at a price relative to the top of book
+type PegOrder struct {
+	Product Product
+	Market  models.Market
+	Side    models.BidAsk
+	Size    decimal.Decimal
+
+	Reference PegReference
+
+	// Distance from the reference away from the other side of the book: below it for buys, above it for sells
+	Offset decimal.Decimal
+
+	// The market's QuoteIncrement. Buy prices are rounded down to it and sell prices up.
+	QuoteIncrement decimal.Decimal
+}
+
+// Peg keeps a post-only order at its pegged price, cancelling and replacing it when the top of book moves. A
+// replacement is only sized from what is left once every earlier order has either filled or been confirmed
+// cancelled, so fills of a replaced order that arrive late never make the peg overfill.
+type Peg struct {
+	client *ApiClient
+	order  PegOrder
+
+	mu        sync.Mutex
+	current   models.OrderID
+	children  map[models.OrderID]*pegChild
+	price     decimal.Decimal
+	filled    decimal.Decimal
+	repricing bool
+	stopped   bool
+	err       error
+	done      chan struct{}
+}
+
+// pegChild is one order placed by a peg
+type pegChild struct {
+	size   decimal.Decimal
+	filled decimal.Decimal
+
+	// The order's filled quantity once it is confirmed cancelled, nil until then
+	final *decimal.Decimal
+}
+
+// outstanding returns how much more of the child may still fill
+func (c *pegChild) outstanding() decimal.Decimal {
+	if c.final != nil {
+		return decimal.Max(c.final.Sub(c.filled), decimal.Zero)
+	}
+	return decimal.Max(c.size.Sub(c.filled), decimal.Zero)
+}
+
+// NewPeg starts pegging order. The first order is placed on the first top of book passed to OnBook.
+func (c *ApiClient) NewPeg(order PegOrder) (*Peg, error) {
+	if !order.Size.IsPositive() {
+		return nil, fmt.Errorf("bad peg: size %s must be positive", order.Size)
+	}
+	if !order.QuoteIncrement.IsPositive() {
+		return nil, fmt.Errorf("bad peg: quote increment %s must be positive", order.QuoteIncrement)
+	}
+	return &Peg{client: c, order: order, children: map[models.OrderID]*pegChild{}, done: make(chan struct{})}, nil
+}
+
+// targetPrice returns the pegged price for book, never crossing the other side so the order stays post-only
+func (p *Peg) targetPrice(book *models.ApiBookSnapshot) (decimal.Decimal, bool) {
+	if len(book.Bids) == 0 || len(book.Asks) == 0 {
+		return decimal.Zero, false
+	}
+	bid, ask := book.Bids[0].Price, book.Asks[0].Price
+	increment := p.order.QuoteIncrement
+
+	reference := bid.Add(ask).Div(decimal.NewFromInt(2))
+	if p.order.Reference == PegBest {
+		reference = ask
+		if p.order.Side == models.Bid {
+			reference = bid
+		}
+	}
+
+	if p.order.Side == models.Bid {
+		price := reference.Sub(p.order.Offset).Div(increment).Floor().Mul(increment)
+		return decimal.Min(price, ask.Sub(increment)), true
+	}
+	price := reference.Add(p.order.Offset).Div(increment).Ceil().Mul(increment)
+	return decimal.Max(price, bid.Add(increment)), true
+}
+
+// inFlight returns the quantity that orders other than the current one may still fill, called with p.mu held
+func (p *Peg) inFlight() decimal.Decimal {
+	total := decimal.Zero
+	for id, child := range p.children {
+		if id != p.current {
+			total = total.Add(child.outstanding())
+		}
+	}
+	return total
+}
+
+// unconfirmed returns the replaced orders not yet confirmed cancelled, called with p.mu held
+func (p *Peg) unconfirmed() []models.OrderID {
+	var ids []models.OrderID
+	for id, child := range p.children {
+		if id != p.current && child.final == nil && child.outstanding().IsPositive() {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// OnBook reprices the order if the pegged price has moved. Orders are cancelled and placed without holding the
+// peg's lock, and a book update that arrives while a reprice is in progress is skipped.
+func (p *Peg) OnBook(book *models.ApiBookSnapshot) {
+	p.mu.Lock()
+	if p.stopped || p.repricing || book.Market != p.order.Market {
+		p.mu.Unlock()
+		return
+	}
+	price, ok := p.targetPrice(book)
+	if !ok || !price.IsPositive() {
+		p.mu.Unlock()
+		return
+	}
+	current, pending := p.current, p.unconfirmed()
+	// A current order that is already completely filled is replaced too, in case the replaced orders it was sized
+	// around turned out to fill less
+	if current != "" && price.Equal(p.price) && len(pending) == 0 && p.children[current].outstanding().IsPositive() {
+		p.mu.Unlock()
+		return
+	}
+	p.repricing = true
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		p.repricing = false
+		p.mu.Unlock()
+	}()
+
+	// Cancel before replacing so the order is never on the book twice. If the cancel can't be confirmed, e.g.
+	// because of a network error, the next book update tries again.
+	if current != "" {
+		if err := p.settle(current); err != nil {
+			p.setErr(err)
+			return
+		}
+		p.mu.Lock()
+		if p.current == current {
+			p.current = ""
+		}
+		p.mu.Unlock()
+	}
+	for _, id := range pending {
+		if err := p.settle(id); err != nil {
+			p.setErr(err)
+		}
+	}
+
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	size := p.order.Size.Sub(p.filled).Sub(p.inFlight())
+	if !size.IsPositive() {
+		p.mu.Unlock()
+		return
+	}
+	req := models.AddOrderReq{
+		Side:          p.order.Side,
+		Price:         price,
+		Size:          size,
+		Market:        p.order.Market,
+		ClientOrderID: NewClientOrderID("peg-"),
+		Type:          models.OrderTypeLimit,
+		PostOnly:      true,
+	}
+	// Record the order first: its fills can arrive before it is acknowledged
+	p.children[req.ClientOrderID] = &pegChild{size: size, filled: decimal.Zero}
+	p.current = req.ClientOrderID
+	p.mu.Unlock()
+
+	placed, err := p.client.addOrder(p.order.Product, req)
+
+	p.mu.Lock()
+	stopped := p.stopped
+	switch {
+	case err == nil && orderClosed(placed):
+		// Accepted but not left on the book, e.g. cancelled for crossing: the next book update places another
+		final := placed.FilledQuantity
+		p.children[req.ClientOrderID].final = &final
+		if p.current == req.ClientOrderID {
+			p.current = ""
+		}
+		p.err = fmt.Errorf("pegged order %s was %s", req.ClientOrderID, placed.State)
+	case err == nil:
+		p.price, p.err = price, nil
+	case definitelyNotPlaced(err):
+		delete(p.children, req.ClientOrderID)
+		p.err = err
+	default:
+		// The order may have been placed: treat it as replaced so it is settled before the next one is sized
+		p.err = err
+	}
+	if p.current == req.ClientOrderID && err != nil {
+		p.current = ""
+	}
+	p.mu.Unlock()
+
+	// Cancel raced with the add and may have missed the order
+	if stopped && err == nil {
+		p.setErr(p.settle(req.ClientOrderID))
+	}
+}
+
+// settle cancels an order and confirms its final filled quantity. The cancel itself may fail because the order
+// already filled or was never placed, so the order is looked up either way.
+func (p *Peg) settle(id models.OrderID) error {
+	cancelErr := p.client.cancelOrderByClientID(p.order.Product, id)
+
+	order, err := p.client.getOrderByClientID(p.order.Product, id)
+	final := decimal.Zero
+	switch {
+	case IsNotFound(err):
+	case err != nil:
+		return errors.Join(cancelErr, err)
+	case order.State == models.New || order.State == models.Open:
+		return fmt.Errorf("pegged order %s is still open: %w", id, cancelErr)
+	default:
+		final = order.FilledQuantity
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if child, ok := p.children[id]; ok {
+		child.final = &final
+	}
+	return nil
+}
+
+func (p *Peg) setErr(err error) {
+	if err == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+// OnFill counts fills of the pegged orders, including those of replaced orders that arrive after they were
+// cancelled, and stops once the order is completely filled
+func (p *Peg) OnFill(fill *models.ApiFill) {
+	p.mu.Lock()
+	child, ok := p.children[fill.ClientOrderID]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	child.filled = child.filled.Add(fill.Size)
+	p.filled = p.filled.Add(fill.Size)
+	var current models.OrderID
+	if !p.stopped && !p.filled.LessThan(p.order.Size) {
+		current = p.stop()
+	}
+	p.mu.Unlock()
+
+	if current != "" {
+		p.setErr(p.settle(current))
+	}
+}
+
+// HandleEvent passes a dispatcher event to OnBook or OnFill
+func (p *Peg) HandleEvent(event Event) {
+	switch data := event.Data.(type) {
+	case *models.ApiBookSnapshot:
+		p.OnBook(data)
+	case *models.ApiFill:
+		p.OnFill(data)
+	}
+}
+
+// Attach feeds the peg from d's top of book and fills channels for its market, which still need to be subscribed
+func (p *Peg) Attach(d *Dispatcher) {
+	books, fills := TopOfBooksSpot(), FillsSpot()
+	if p.order.Product == ProductPerps {
+		books, fills = TopOfBooksPerps(), FillsPerps()
+	}
+	d.Handle(books, p.order.Market, HandlerOptions{Policy: ConflateLatest}, p.HandleEvent)
+	d.Handle(fills, p.order.Market, HandlerOptions{Policy: Block}, p.HandleEvent)
+}
+
+// Cancel cancels the pegged order and stops repricing
+func (p *Peg) Cancel() error {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return nil
+	}
+	current := p.stop()
+	p.mu.Unlock()
+
+	if current == "" {
+		return nil
+	}
+	return p.settle(current)
+}
+
+// stop stops repricing and returns the live order that the caller must cancel once it has released p.mu. It is
+// called with p.mu held.
+func (p *Peg) stop() models.OrderID {
+	current := p.current
+	p.stopped = true
+	close(p.done)
+	return current
+}
+
+// Done is closed when the order is completely filled or cancelled
+func (p *Peg) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err returns the error of the last failed reprice, if the order hasn't been placed since
+func (p *Peg) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *Peg) Price() decimal.Decimal {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.price
+}
+
+func (p *Peg) Filled() decimal.Decimal {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.filled
+}
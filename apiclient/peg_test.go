@@ -0,0 +1,219 @@
+package apiclient
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+// fakeSpotOrders serves spot order placement, cancel and lookup by client order ID. Cancelled orders report the
+// filled quantity set in filled.
+type fakeSpotOrders struct {
+	mu        sync.Mutex
+	placed    []models.AddOrderReq
+	cancelled map[models.OrderID]bool
+	filled    map[models.OrderID]decimal.Decimal
+}
+
+func newFakeSpotOrders(t *testing.T, ex *fakeExchange) *fakeSpotOrders {
+	o := &fakeSpotOrders{cancelled: map[models.OrderID]bool{}, filled: map[models.OrderID]decimal.Decimal{}}
+	ex.handle("POST "+models.V1SpotOrdersPath, func(req fakeRequest) (int, any) {
+		var add models.AddOrderReq
+		req.decode(t, &add)
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		o.placed = append(o.placed, add)
+		return ok(models.ApiOrder{OrderID: "x" + add.ClientOrderID, ClientOrderID: add.ClientOrderID, Market: add.Market, OrderQuantity: add.Size, State: models.Open})
+	})
+	prefix := models.V1SpotOrdersPath + "/" + models.V1SpotClientOrderIDPrefix
+	ex.handle("DELETE "+prefix+"*", func(req fakeRequest) (int, any) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		o.cancelled[models.OrderID(strings.TrimPrefix(req.Path, prefix))] = true
+		return ok[any](nil)
+	})
+	ex.handle("GET "+prefix+"*", func(req fakeRequest) (int, any) {
+		id := models.OrderID(strings.TrimPrefix(req.Path, prefix))
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		state := models.Open
+		if o.cancelled[id] {
+			state = models.Canceled
+		}
+		return ok(models.ApiOrder{ClientOrderID: id, State: state, FilledQuantity: o.filled[id]})
+	})
+	return o
+}
+
+func (o *fakeSpotOrders) orders() []models.AddOrderReq {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]models.AddOrderReq(nil), o.placed...)
+}
+
+func (o *fakeSpotOrders) setFilled(id models.OrderID, filled string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.filled[id] = decimal.RequireFromString(filled)
+}
+
+func (o *fakeSpotOrders) wasCancelled(id models.OrderID) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.cancelled[id]
+}
+
+func fillOf(id models.OrderID, size string) *models.ApiFill {
+	return &models.ApiFill{ClientOrderID: id, Market: "AVAX-USDC", Size: decimal.RequireFromString(size)}
+}
+
+func TestPegNeverOverfills(t *testing.T) {
+	tests := []struct {
+		name string
+		// Filled quantity the exchange reports for the first order when it is cancelled, and the late fills of it
+		// delivered after the replacement is placed
+		replacedFilled string
+		wantSecondSize string
+	}{
+		{name: "unfilled", replacedFilled: "0", wantSecondSize: "10"},
+		{name: "partly filled before cancel", replacedFilled: "4", wantSecondSize: "6"},
+		{name: "completely filled before cancel", replacedFilled: "10", wantSecondSize: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newFakeExchange(t)
+			orders := newFakeSpotOrders(t, ex)
+			peg, err := ex.client().NewPeg(PegOrder{Product: ProductSpot, Market: "AVAX-USDC", Side: models.Bid,
+				Size: decimal.NewFromInt(10), QuoteIncrement: decimal.RequireFromString("0.01")})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			peg.OnBook(book("AVAX-USDC", "40", "41"))
+			placed := orders.orders()
+			if len(placed) != 1 || !placed[0].Size.Equal(decimal.NewFromInt(10)) {
+				t.Fatalf("first order = %+v, want one order of 10", placed)
+			}
+			first := placed[0].ClientOrderID
+			orders.setFilled(first, tt.replacedFilled)
+
+			peg.OnBook(book("AVAX-USDC", "40.5", "41"))
+			if !orders.wasCancelled(first) {
+				t.Fatal("replaced order was not cancelled")
+			}
+			placed = orders.orders()
+			if tt.wantSecondSize == "" {
+				if len(placed) != 1 {
+					t.Fatalf("placed a replacement %+v although the first order filled completely", placed[1:])
+				}
+			} else if len(placed) != 2 || !placed[1].Size.Equal(decimal.RequireFromString(tt.wantSecondSize)) {
+				t.Fatalf("orders = %+v, want a replacement of %s", placed, tt.wantSecondSize)
+			}
+
+			// The replaced order's fills arrive late, then the replacement fills
+			if tt.replacedFilled != "0" {
+				peg.OnFill(fillOf(first, tt.replacedFilled))
+			}
+			if tt.wantSecondSize != "" {
+				peg.OnFill(fillOf(placed[1].ClientOrderID, tt.wantSecondSize))
+			}
+
+			select {
+			case <-peg.Done():
+			default:
+				t.Fatal("peg not done after filling its size")
+			}
+			if !peg.Filled().Equal(decimal.NewFromInt(10)) {
+				t.Fatalf("filled %s, want 10", peg.Filled())
+			}
+		})
+	}
+}
+
+func TestPegStopCancelsLiveOrder(t *testing.T) {
+	ex := newFakeExchange(t)
+	orders := newFakeSpotOrders(t, ex)
+	peg, err := ex.client().NewPeg(PegOrder{Product: ProductSpot, Market: "AVAX-USDC", Side: models.Ask,
+		Size: decimal.NewFromInt(5), QuoteIncrement: decimal.RequireFromString("0.01")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peg.OnBook(book("AVAX-USDC", "40", "41"))
+	first := orders.orders()[0].ClientOrderID
+	// The replaced order reports nothing filled when it is cancelled, then fills completely anyway
+	peg.OnBook(book("AVAX-USDC", "40", "40.5"))
+	second := orders.orders()[1].ClientOrderID
+	peg.OnFill(fillOf(first, "5"))
+
+	select {
+	case <-peg.Done():
+	default:
+		t.Fatal("peg not done")
+	}
+	if !orders.wasCancelled(second) {
+		t.Fatal("live replacement left on the book after the peg filled")
+	}
+}
+
+func TestPegCancel(t *testing.T) {
+	ex := newFakeExchange(t)
+	orders := newFakeSpotOrders(t, ex)
+	peg, err := ex.client().NewPeg(PegOrder{Product: ProductSpot, Market: "AVAX-USDC", Side: models.Bid,
+		Size: decimal.NewFromInt(1), QuoteIncrement: decimal.RequireFromString("0.01")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peg.OnBook(book("AVAX-USDC", "40", "41"))
+	if err := peg.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if !orders.wasCancelled(orders.orders()[0].ClientOrderID) {
+		t.Fatal("order not cancelled")
+	}
+	peg.OnBook(book("AVAX-USDC", "39", "41"))
+	if n := len(orders.orders()); n != 1 {
+		t.Fatalf("placed %d orders after Cancel", n)
+	}
+}
+
+func TestPegReplacesOrderCancelledOnPlacement(t *testing.T) {
+	ex := newFakeExchange(t)
+	orders := newFakeSpotOrders(t, ex)
+	var placed []models.AddOrderReq
+	ex.handle("POST "+models.V1SpotOrdersPath, func(req fakeRequest) (int, any) {
+		var add models.AddOrderReq
+		req.decode(t, &add)
+		placed = append(placed, add)
+		// The first order would have crossed and is cancelled instead of resting
+		state := models.Open
+		if len(placed) == 1 {
+			state = models.Canceled
+		}
+		return ok(models.ApiOrder{ClientOrderID: add.ClientOrderID, State: state})
+	})
+	peg, err := ex.client().NewPeg(PegOrder{Product: ProductSpot, Market: "AVAX-USDC", Side: models.Bid,
+		Size: decimal.NewFromInt(10), QuoteIncrement: decimal.RequireFromString("0.01")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peg.OnBook(book("AVAX-USDC", "40", "41"))
+	if peg.Err() == nil {
+		t.Fatal("no error for the cancelled order")
+	}
+	// The same book again: nothing rests, so the order is placed again
+	peg.OnBook(book("AVAX-USDC", "40", "41"))
+	if len(placed) != 2 || !placed[1].Size.Equal(decimal.NewFromInt(10)) {
+		t.Fatalf("orders = %+v, want a replacement of 10", placed)
+	}
+	if orders.wasCancelled(placed[0].ClientOrderID) {
+		t.Fatal("cancelled an order that was already gone")
+	}
+	if peg.Err() != nil {
+		t.Fatalf("Err() = %v after the replacement rests", peg.Err())
+	}
+}
@@ -2,7 +2,9 @@ package apiclient
 
 import (
 	"fmt"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/Enclave-Markets/enclave-go/models"
 )
@@ -10,8 +12,9 @@ import (
 func (client *ApiClient) AddPerpsOrder(req models.AddOrderReq) (*models.GenericResponse[models.ApiOrder], error) {
 	path := models.V1PerpsOrdersPath
 
-	res, err := NewHttpJsonClient[models.AddOrderReq, models.GenericResponse[models.ApiOrder]](
-		client.ApiEndpoint + path).SetHeaders(client.getHeaders("POST", path, req)).Post(req)
+	sent := time.Now()
+	res, err := send[models.AddOrderReq, models.GenericResponse[models.ApiOrder]](client, "POST", path, req)
+	client.getMetrics().ObserveOrderSubmit(ProductPerps, time.Since(sent), err == nil && res.Success)
 	if err != nil {
 		return res, fmt.Errorf("error with http req in perp add order: %w", err)
 	}
@@ -25,8 +28,9 @@ func (client *ApiClient) AddPerpsOrder(req models.AddOrderReq) (*models.GenericR
 func (client *ApiClient) AddPerpsBatchOrders(req models.BatchAddOrderReq) (*models.GenericResponse[models.BatchAddOrderRes], error) {
 	path := models.V1PerpsBatchOrdersPath
 
-	res, err := NewHttpJsonClient[models.BatchAddOrderReq, models.GenericResponse[models.BatchAddOrderRes]](
-		client.ApiEndpoint + path).SetHeaders(client.getHeaders("POST", path, req)).Post(req)
+	sent := time.Now()
+	res, err := send[models.BatchAddOrderReq, models.GenericResponse[models.BatchAddOrderRes]](client, "POST", path, req)
+	client.getMetrics().ObserveOrderSubmit(ProductPerps, time.Since(sent), err == nil && res.Success)
 	if err != nil {
 		return res, fmt.Errorf("error with http req in perps batch order: %w", err)
 	}
@@ -41,12 +45,11 @@ func (client *ApiClient) CancelPerpsOrdersByClientId(clientIds []models.ClientOr
 
 	ids := make([]string, 0, len(clientIds))
 	for _, id := range clientIds {
-		ids = append(ids, "client:"+string(id))
+		ids = append(ids, "client:"+url.QueryEscape(string(id)))
 	}
 
 	path := models.V1PerpsBatchOrdersPath + "?orderIDs=" + strings.Join(ids, ",")
-	res, err := NewHttpJsonClient[any, models.GenericResponse[models.BatchCancelRes]](
-		client.ApiEndpoint + path).SetHeaders(client.getHeaders("DELETE", path, nil)).Delete(nil)
+	res, err := send[any, models.GenericResponse[models.BatchCancelRes]](client, "DELETE", path, nil)
 	if err != nil {
 		return res, fmt.Errorf("error in http req perps delete batch: %w", err)
 	}
@@ -57,11 +60,24 @@ func (client *ApiClient) CancelPerpsOrdersByClientId(clientIds []models.ClientOr
 	return res, nil
 }
 
+func (client *ApiClient) GetPerpsOrderByClientID(clientOrderId models.ClientOrderID) (*models.GenericResponse[models.ApiOrder], error) {
+	path := models.V1PerpsOrdersPath + "/client:" + url.PathEscape(string(clientOrderId))
+
+	res, err := send[any, models.GenericResponse[models.ApiOrder]](client, "GET", path, nil)
+	if err != nil {
+		return res, fmt.Errorf("error in http req perps get order by client id: %w", err)
+	}
+	if !res.Success {
+		return res, fmt.Errorf("bad request perps get order by client id %s: %v", clientOrderId, res.Error)
+	}
+
+	return res, nil
+}
+
 func (client *ApiClient) CancelAllPerpsOrdersOnMarket(market models.Market) error {
-	path := models.V1PerpsOrdersPath + "?market=" + string(market)
+	path := models.V1PerpsOrdersPath + "?market=" + url.QueryEscape(string(market))
 
-	res, err := NewHttpJsonClient[any, models.GenericResponse[any]](
-		client.ApiEndpoint + path).SetHeaders(client.getHeaders("DELETE", path, nil)).Delete(nil)
+	res, err := send[any, models.GenericResponse[any]](client, "DELETE", path, nil)
 	if err != nil {
 		return fmt.Errorf("error in http req perps delete all orders: %w", err)
 	}
@@ -76,8 +92,7 @@ func (client *ApiClient) CancelAllPerpsOrdersOnMarket(market models.Market) erro
 func (client *ApiClient) GetPerpsContracts() (*models.GenericResponse[[]models.PerpsContract], error) {
 	path := models.V1PerpsContractsPath
 
-	res, err := NewHttpJsonClient[any, models.GenericResponse[[]models.PerpsContract]](
-		client.ApiEndpoint + path).SetHeaders(client.getHeaders("GET", path, nil)).Get(nil)
+	res, err := send[any, models.GenericResponse[[]models.PerpsContract]](client, "GET", path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute perps contracts request: %w", err)
 	}
@@ -88,3 +103,41 @@ func (client *ApiClient) GetPerpsContracts() (*models.GenericResponse[[]models.P
 
 	return res, nil
 }
+
+func (client *ApiClient) GetPerpsOrders(params models.OrderParams) (*models.V1PageRes[models.ApiOrder], error) {
+	path := models.V1PerpsOrdersPath
+	path += params.GetOrderPathParams()
+
+	res, err := send[any, models.V1PageRes[models.ApiOrder]](client, "GET", path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error in http req perps get orders: %w", err)
+	}
+
+	return res, err
+}
+
+func (client *ApiClient) GetPerpsFills(params models.FillParams) (*models.V1PageRes[models.ApiFill], error) {
+	path := models.V1PerpsFillsPath
+	path += params.GetFillPathParams()
+
+	res, err := send[any, models.V1PageRes[models.ApiFill]](client, "GET", path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error in http req perps get fills: %w", err)
+	}
+
+	return res, err
+}
+
+func (client *ApiClient) GetPerpsPositions() (*models.GenericResponse[[]models.ApiPosition], error) {
+	path := models.V1PerpsPositionsPath
+
+	res, err := send[any, models.GenericResponse[[]models.ApiPosition]](client, "GET", path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error in http req perps get positions: %w", err)
+	}
+	if !res.Success {
+		return res, fmt.Errorf("bad request perps get positions: %v", res.Error)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,257 @@
+package apiclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+	"golang.org/x/time/rate"
+)
+
+// ClientPool holds one client per account. All clients share the base client's connection settings and a single
+// http.Transport, and each is rate limited on its own.
+type ClientPool struct {
+	base  *ApiClient
+	limit rate.Limit
+	burst int
+
+	mu      sync.RWMutex
+	clients map[models.AccountID]*ApiClient
+}
+
+// NewClientPool creates a pool whose clients copy the endpoint and TLS settings of base and are each allowed limit
+// requests per second with bursts of burst.
+func NewClientPool(base *ApiClient, limit rate.Limit, burst int) *ClientPool {
+	shared := *base
+	shared.interceptors = base.interceptors.clone()
+	if shared.httpClient == nil {
+		shared.httpClient = &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+	}
+
+	return &ClientPool{
+		base:    &shared,
+		limit:   limit,
+		burst:   burst,
+		clients: map[models.AccountID]*ApiClient{},
+	}
+}
+
+// AddAccount adds a client for account that signs with signer, replacing any existing one
+func (p *ClientPool) AddAccount(account models.AccountID, signer Signer) *ApiClient {
+	client := *p.base
+	client.Headers = make(map[string]string, len(p.base.Headers))
+	for k, v := range p.base.Headers {
+		client.Headers[k] = v
+	}
+	client.clock = &serverClock{enabled: p.base.clock != nil && p.base.clock.enabled}
+	client.interceptors = p.base.interceptors.clone()
+	client.jwt = nil
+	client.WithSigner(signer)
+	client.WithRateLimiter(rate.NewLimiter(p.limit, p.burst))
+	client.account = account
+	client.shared.register(&client)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clients[account] = &client
+	return &client
+}
+
+// AddApiKey adds a client for account that signs with an in-memory API key
+func (p *ClientPool) AddApiKey(account models.AccountID, keyId, keySecret string) *ApiClient {
+	return p.AddAccount(account, NewHmacSigner(keyId, keySecret))
+}
+
+func (p *ClientPool) Remove(account models.AccountID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.clients, account)
+	p.base.shared.unregister(account)
+}
+
+func (p *ClientPool) Get(account models.AccountID) (*ApiClient, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	client, ok := p.clients[account]
+	return client, ok
+}
+
+// Accounts returns the accounts in the pool in sorted order
+func (p *ClientPool) Accounts() []models.AccountID {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	accounts := make([]models.AccountID, 0, len(p.clients))
+	for account := range p.clients {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i] < accounts[j] })
+	return accounts
+}
+
+// fanOut calls f concurrently for every account in the pool
+func fanOut[T any](p *ClientPool, f func(client *ApiClient) (T, error)) (map[models.AccountID]T, map[models.AccountID]error) {
+	p.mu.RLock()
+	clients := make(map[models.AccountID]*ApiClient, len(p.clients))
+	for account, client := range p.clients {
+		clients[account] = client
+	}
+	p.mu.RUnlock()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := map[models.AccountID]T{}
+	errs := map[models.AccountID]error{}
+	for account, client := range clients {
+		wg.Add(1)
+		go func(account models.AccountID, client *ApiClient) {
+			defer wg.Done()
+			res, err := f(client)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[account] = err
+				return
+			}
+			results[account] = res
+		}(account, client)
+	}
+	wg.Wait()
+
+	return results, errs
+}
+
+// PoolErrors collects the errors of the accounts that failed in a fan-out query
+type PoolErrors map[models.AccountID]error
+
+func (e PoolErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	errs := make([]error, 0, len(e))
+	for account, err := range e {
+		errs = append(errs, fmt.Errorf("account %s: %w", account, err))
+	}
+	return errors.Join(errs...)
+}
+
+type PoolBalances struct {
+	Symbol    models.Symbol
+	ByAccount map[models.AccountID]models.V0GetBalanceRes
+
+	// Sums over every account that responded
+	Total    decimal.Decimal
+	Reserved decimal.Decimal
+	Free     decimal.Decimal
+
+	Errors PoolErrors
+}
+
+// Balances queries the balance of symbol on every account
+func (p *ClientPool) Balances(symbol models.Symbol) *PoolBalances {
+	results, errs := fanOut(p, func(client *ApiClient) (models.V0GetBalanceRes, error) {
+		res, err := client.GetBalance(models.GetBalanceReq{Symbol: symbol})
+		if err != nil {
+			return models.V0GetBalanceRes{}, err
+		}
+		return res.Result, nil
+	})
+
+	view := &PoolBalances{Symbol: symbol, ByAccount: results, Errors: errs}
+	for account, balance := range results {
+		total, err1 := decimal.NewFromString(balance.TotalBalance)
+		reserved, err2 := decimal.NewFromString(balance.ReservedBalance)
+		free, err3 := decimal.NewFromString(balance.FreeBalance)
+		if err := errors.Join(err1, err2, err3); err != nil {
+			view.Errors[account] = fmt.Errorf("invalid balance: %w", err)
+			delete(view.ByAccount, account)
+			continue
+		}
+		view.Total = view.Total.Add(total)
+		view.Reserved = view.Reserved.Add(reserved)
+		view.Free = view.Free.Add(free)
+	}
+	return view
+}
+
+type PoolOrders struct {
+	Spot  map[models.AccountID][]*models.ApiOrder
+	Perps map[models.AccountID][]*models.ApiOrder
+
+	Errors PoolErrors
+}
+
+// OpenOrders lists every open spot and perps order on every account
+func (p *ClientPool) OpenOrders() *PoolOrders {
+	type orders struct{ spot, perps []*models.ApiOrder }
+	results, errs := fanOut(p, func(client *ApiClient) (orders, error) {
+		spot, err := listAllOrders(client.GetSpotOrders, models.OrderParams{Status: models.Open.String()})
+		if err != nil {
+			return orders{}, err
+		}
+		perps, err := listAllOrders(client.GetPerpsOrders, models.OrderParams{Status: models.Open.String()})
+		if err != nil {
+			return orders{}, err
+		}
+		return orders{spot: spot, perps: perps}, nil
+	})
+
+	view := &PoolOrders{
+		Spot:   make(map[models.AccountID][]*models.ApiOrder, len(results)),
+		Perps:  make(map[models.AccountID][]*models.ApiOrder, len(results)),
+		Errors: errs,
+	}
+	for account, res := range results {
+		view.Spot[account] = res.spot
+		view.Perps[account] = res.perps
+	}
+	return view
+}
+
+// listAllOrders follows cursors until every page of orders matching params has been fetched
+func listAllOrders(list func(models.OrderParams) (*models.V1PageRes[models.ApiOrder], error), params models.OrderParams) ([]*models.ApiOrder, error) {
+	var orders []*models.ApiOrder
+	for {
+		res, err := list(params)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, res.Result...)
+		if res.PageInfo.NextCursor == "" {
+			return orders, nil
+		}
+		params.Cursor = res.PageInfo.NextCursor
+	}
+}
+
+type PoolPositions struct {
+	ByAccount map[models.AccountID][]models.ApiPosition
+
+	// Signed quantity per market summed over every account that responded, negative for net short
+	NetQuantity map[models.Market]decimal.Decimal
+
+	Errors PoolErrors
+}
+
+// Positions queries the perps positions of every account
+func (p *ClientPool) Positions() *PoolPositions {
+	results, errs := fanOut(p, func(client *ApiClient) ([]models.ApiPosition, error) {
+		res, err := client.GetPerpsPositions()
+		if err != nil {
+			return nil, err
+		}
+		return res.Result, nil
+	})
+
+	view := &PoolPositions{ByAccount: results, NetQuantity: map[models.Market]decimal.Decimal{}, Errors: errs}
+	for _, positions := range results {
+		for _, position := range positions {
+			view.NetQuantity[position.Market] = view.NetQuantity[position.Market].Add(position.SignedQuantity())
+		}
+	}
+	return view
+}
@@ -0,0 +1,135 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+	"golang.org/x/time/rate"
+)
+
+func TestClientPoolSharesTransportAndLimitsEachKey(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("GET "+models.V1MarketsPath, func(fakeRequest) (int, any) {
+		return ok(models.V1GetMarketsResult{})
+	})
+
+	pool := NewClientPool(NewApiClient(ex.srv.URL), rate.Every(time.Hour), 1)
+	a := pool.AddApiKey("a", "key-a", "secret-a")
+	b := pool.AddApiKey("b", "key-b", "secret-b")
+	if a.httpClient != b.httpClient {
+		t.Fatal("pool clients don't share an http.Client")
+	}
+
+	if _, err := a.Markets(); err != nil {
+		t.Fatalf("a: %v", err)
+	}
+	// a has used its burst but b has its own limiter
+	if _, err := b.Markets(); err != nil {
+		t.Fatalf("b: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := a.WithContext(ctx).Markets(); err == nil {
+		t.Fatal("expected a's second request to be rate limited")
+	}
+
+	var keys []string
+	for _, req := range ex.requests("GET " + models.V1MarketsPath) {
+		keys = append(keys, req.Header.Get("ENCLAVE-KEY-ID"))
+	}
+	if len(keys) != 2 || keys[0] != "key-a" || keys[1] != "key-b" {
+		t.Fatalf("requests signed with %v, want [key-a key-b]", keys)
+	}
+}
+
+func TestClientPoolBalances(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("POST "+models.V0GetBalancePath, func(req fakeRequest) (int, any) {
+		switch req.Header.Get("ENCLAVE-KEY-ID") {
+		case "key-a":
+			return ok(models.V0GetBalanceRes{Symbol: "USDC", TotalBalance: "10", ReservedBalance: "4", FreeBalance: "6"})
+		case "key-b":
+			return ok(models.V0GetBalanceRes{Symbol: "USDC", TotalBalance: "5", ReservedBalance: "0", FreeBalance: "5"})
+		case "key-c":
+			return ok(models.V0GetBalanceRes{Symbol: "USDC", TotalBalance: "bad"})
+		default:
+			return http.StatusInternalServerError, models.GenericResponse[any]{Error: "down"}
+		}
+	})
+
+	pool := NewClientPool(NewApiClient(ex.srv.URL), rate.Inf, 1)
+	pool.AddApiKey("a", "key-a", "secret")
+	pool.AddApiKey("b", "key-b", "secret")
+	pool.AddApiKey("c", "key-c", "secret")
+	pool.AddApiKey("d", "key-d", "secret")
+
+	balances := pool.Balances("USDC")
+	if !balances.Total.Equal(decimal.NewFromInt(15)) || !balances.Reserved.Equal(decimal.NewFromInt(4)) || !balances.Free.Equal(decimal.NewFromInt(11)) {
+		t.Fatalf("totals = %s/%s/%s, want 15/4/11", balances.Total, balances.Reserved, balances.Free)
+	}
+	if len(balances.ByAccount) != 2 {
+		t.Fatalf("balances for %d accounts, want 2", len(balances.ByAccount))
+	}
+	if len(balances.Errors) != 2 || balances.Errors["c"] == nil || balances.Errors["d"] == nil {
+		t.Fatalf("errors = %v, want errors for c and d", balances.Errors)
+	}
+	if balances.Errors.Err() == nil {
+		t.Fatal("Err() = nil with failed accounts")
+	}
+}
+
+func TestClientPoolOpenOrdersAndPositions(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("GET "+models.V1SpotOrdersPath, func(req fakeRequest) (int, any) {
+		if req.Query == "status=open" {
+			return http.StatusOK, models.V1PageRes[models.ApiOrder]{
+				Result:   []*models.ApiOrder{{OrderID: models.OrderID(req.Header.Get("ENCLAVE-KEY-ID") + "-1")}},
+				PageInfo: models.APIPageInfo{NextCursor: "next"},
+			}
+		}
+		return http.StatusOK, models.V1PageRes[models.ApiOrder]{
+			Result: []*models.ApiOrder{{OrderID: models.OrderID(req.Header.Get("ENCLAVE-KEY-ID") + "-2")}},
+		}
+	})
+	ex.handle("GET "+models.V1PerpsOrdersPath, func(fakeRequest) (int, any) {
+		return http.StatusOK, models.V1PageRes[models.ApiOrder]{}
+	})
+	ex.handle("GET "+models.V1PerpsPositionsPath, func(req fakeRequest) (int, any) {
+		if req.Header.Get("ENCLAVE-KEY-ID") == "key-a" {
+			return ok([]models.ApiPosition{{Market: "BTC-USD.P", Direction: "long", NetQuantity: decimal.NewFromInt(3)}})
+		}
+		return ok([]models.ApiPosition{{Market: "BTC-USD.P", Direction: "short", NetQuantity: decimal.NewFromInt(1)}})
+	})
+
+	pool := NewClientPool(NewApiClient(ex.srv.URL), rate.Inf, 1)
+	pool.AddApiKey("a", "key-a", "secret")
+	pool.AddApiKey("b", "key-b", "secret")
+
+	orders := pool.OpenOrders()
+	if err := orders.Errors.Err(); err != nil {
+		t.Fatalf("OpenOrders: %v", err)
+	}
+	for account, key := range map[models.AccountID]string{"a": "key-a", "b": "key-b"} {
+		spot := orders.Spot[account]
+		if len(spot) != 2 || spot[0].OrderID != models.OrderID(key+"-1") || spot[1].OrderID != models.OrderID(key+"-2") {
+			t.Fatalf("spot orders for %s = %v, want both pages", account, spot)
+		}
+	}
+
+	positions := pool.Positions()
+	if err := positions.Errors.Err(); err != nil {
+		t.Fatalf("Positions: %v", err)
+	}
+	if net := positions.NetQuantity["BTC-USD.P"]; !net.Equal(decimal.NewFromInt(2)) {
+		t.Fatalf("net quantity = %s, want 2", net)
+	}
+
+	pool.Remove("b")
+	if accounts := pool.Accounts(); len(accounts) != 1 || accounts[0] != "a" {
+		t.Fatalf("accounts after Remove = %v, want [a]", accounts)
+	}
+}
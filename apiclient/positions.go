@@ -0,0 +1,122 @@
+package apiclient
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+// PositionTracker keeps the signed position per market, negative for short: perps positions from the positions
+// channel, and spot inventory from fills added to a starting quantity set with Set
+type PositionTracker struct {
+	mu        sync.RWMutex
+	positions map[models.Market]decimal.Decimal
+	perps     map[models.Market]bool
+}
+
+func NewPositionTracker() *PositionTracker {
+	return &PositionTracker{positions: map[models.Market]decimal.Decimal{}, perps: map[models.Market]bool{}}
+}
+
+// Position returns the position in market, zero if none
+func (t *PositionTracker) Position(market models.Market) decimal.Decimal {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.positions[market]
+}
+
+// Positions returns every non-zero position
+func (t *PositionTracker) Positions() map[models.Market]decimal.Decimal {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	positions := make(map[models.Market]decimal.Decimal, len(t.positions))
+	for market, quantity := range t.positions {
+		if !quantity.IsZero() {
+			positions[market] = quantity
+		}
+	}
+	return positions
+}
+
+// SpotPositions returns the inventory of every market that isn't a perps market, e.g. to save in a Checkpoint
+func (t *PositionTracker) SpotPositions() map[models.Market]decimal.Decimal {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	positions := map[models.Market]decimal.Decimal{}
+	for market, quantity := range t.positions {
+		if !t.perps[market] {
+			positions[market] = quantity
+		}
+	}
+	return positions
+}
+
+func (t *PositionTracker) Set(market models.Market, quantity decimal.Decimal) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.positions[market] = quantity
+}
+
+func (t *PositionTracker) OnPosition(position *models.ApiPosition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.positions[position.Market] = position.SignedQuantity()
+	t.perps[position.Market] = true
+}
+
+// OnSpotFill adds a spot fill to the inventory of its market. A fill with a side this version of the SDK doesn't know
+// is ignored.
+func (t *PositionTracker) OnSpotFill(fill *models.ApiFill) {
+	if fill.UnknownSide != "" {
+		return
+	}
+	change := fill.Size
+	if fill.Side == models.Ask {
+		change = change.Neg()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.positions[fill.Market] = t.positions[fill.Market].Add(change)
+}
+
+// HandleEvent passes perps position updates to OnPosition and spot fills to OnSpotFill. Perps fills are ignored
+// because the positions channel already reflects them.
+func (t *PositionTracker) HandleEvent(event Event) {
+	switch data := event.Data.(type) {
+	case *models.ApiPosition:
+		t.OnPosition(data)
+	case *models.ApiFill:
+		if event.Channel == FillsSpot() {
+			t.OnSpotFill(data)
+		}
+	}
+}
+
+// Attach feeds the tracker from d's perps positions and spot fills channels, which still need to be subscribed
+func (t *PositionTracker) Attach(d *Dispatcher) {
+	d.Handle(PerpsPositions(), "", HandlerOptions{Policy: ConflateLatest}, t.HandleEvent)
+	d.Handle(FillsSpot(), "", HandlerOptions{Policy: Block}, t.HandleEvent)
+}
+
+// Sync replaces the perps positions with the account's current ones. Perps markets that no longer have a position
+// are set to zero.
+func (t *PositionTracker) Sync(ctx context.Context, client *ApiClient) error {
+	res, err := client.WithContext(ctx).GetPerpsPositions()
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for market := range t.perps {
+		t.positions[market] = decimal.Zero
+	}
+	for _, position := range res.Result {
+		t.positions[position.Market] = position.SignedQuantity()
+		t.perps[position.Market] = true
+	}
+	return nil
+}
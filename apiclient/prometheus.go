@@ -0,0 +1,202 @@
+package apiclient
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PrometheusMetrics collects client metrics in memory and serves them in the Prometheus text exposition format,
+// without depending on a Prometheus client library. Mount it on an http.ServeMux to expose it for scraping.
+type PrometheusMetrics struct {
+	mu         sync.Mutex
+	counters   []*counterVec
+	histograms []*histogramVec
+
+	requests         *counterVec
+	requestDuration  *histogramVec
+	orderSubmit      *histogramVec
+	rateLimitWait    *histogramVec
+	websocketMsgs    *counterVec
+	websocketReconns *counterVec
+	pingRTT          *histogramVec
+	riskRejections   *counterVec
+}
+
+// Latency buckets in seconds
+var defaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func NewPrometheusMetrics() *PrometheusMetrics {
+	m := &PrometheusMetrics{}
+	m.requests = m.newCounter("enclave_requests_total", "REST requests by endpoint and status.", "method", "endpoint", "status")
+	m.requestDuration = m.newHistogram("enclave_request_duration_seconds", "REST request latency.", "method", "endpoint")
+	m.orderSubmit = m.newHistogram("enclave_order_submit_duration_seconds", "Time from sending an order to its acknowledgement.", "product", "outcome")
+	m.rateLimitWait = m.newHistogram("enclave_rate_limit_wait_seconds", "Time requests waited for the rate limiter.")
+	m.websocketMsgs = m.newCounter("enclave_websocket_messages_total", "Websocket updates received by channel.", "channel")
+	m.websocketReconns = m.newCounter("enclave_websocket_reconnects_total", "Websocket reconnections.")
+	m.pingRTT = m.newHistogram("enclave_websocket_ping_rtt_seconds", "Websocket ping round trip time.")
+	m.riskRejections = m.newCounter("enclave_risk_rejections_total", "Orders rejected by pre-trade risk checks.", "check")
+	return m
+}
+
+func (m *PrometheusMetrics) ObserveRequest(method string, endpoint string, status int, latency time.Duration) {
+	m.requests.inc(m, method, endpoint, strconv.Itoa(status))
+	m.requestDuration.observe(m, latency.Seconds(), method, endpoint)
+}
+
+func (m *PrometheusMetrics) ObserveOrderSubmit(product Product, latency time.Duration, accepted bool) {
+	outcome := "rejected"
+	if accepted {
+		outcome = "accepted"
+	}
+	m.orderSubmit.observe(m, latency.Seconds(), string(product), outcome)
+}
+
+func (m *PrometheusMetrics) ObserveRateLimitWait(wait time.Duration) {
+	m.rateLimitWait.observe(m, wait.Seconds())
+}
+
+func (m *PrometheusMetrics) IncWebsocketMessages(channel ChannelType) {
+	m.websocketMsgs.inc(m, string(channel))
+}
+
+func (m *PrometheusMetrics) IncWebsocketReconnects() {
+	m.websocketReconns.inc(m)
+}
+
+func (m *PrometheusMetrics) ObservePingRTT(rtt time.Duration) {
+	m.pingRTT.observe(m, rtt.Seconds())
+}
+
+func (m *PrometheusMetrics) IncRiskRejections(check RiskCheck) {
+	m.riskRejections.inc(m, string(check))
+}
+
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.Write(w)
+}
+
+// Write writes every metric in the Prometheus text exposition format
+func (m *PrometheusMetrics) Write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range m.counters {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, key := range sortedKeys(c.values) {
+			fmt.Fprintf(bw, "%s%s %s\n", c.name, formatLabels(c.labels, key, ""), formatFloat(c.values[key]))
+		}
+	}
+	for _, h := range m.histograms {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+		for _, key := range sortedKeys(h.values) {
+			v := h.values[key]
+			for i, bound := range defaultBuckets {
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, formatFloat(bound)), v.buckets[i])
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "+Inf"), v.count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, ""), formatFloat(v.sum))
+			fmt.Fprintf(bw, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, ""), v.count)
+		}
+	}
+	return bw.Flush()
+}
+
+type counterVec struct {
+	name, help string
+	labels     []string
+	values     map[string]float64
+}
+
+type histogramVec struct {
+	name, help string
+	labels     []string
+	values     map[string]*histogram
+}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// Label values are joined with a separator that cannot appear in them unescaped
+const labelSeparator = "\xff"
+
+func (m *PrometheusMetrics) newCounter(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+	m.counters = append(m.counters, c)
+	return c
+}
+
+func (m *PrometheusMetrics) newHistogram(name, help string, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, values: map[string]*histogram{}}
+	m.histograms = append(m.histograms, h)
+	return h
+}
+
+func (c *counterVec) inc(m *PrometheusMetrics, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c.values[strings.Join(labelValues, labelSeparator)]++
+}
+
+func (h *histogramVec) observe(m *PrometheusMetrics, v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := strings.Join(labelValues, labelSeparator)
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{buckets: make([]uint64, len(defaultBuckets))}
+		h.values[key] = hist
+	}
+	for i, bound := range defaultBuckets {
+		if v <= bound {
+			hist.buckets[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelValueEscaper escapes a label value as the text exposition format requires: only backslash, double quote and
+// newline. Other characters, including non-ASCII ones, are written as they are.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders {name="value",...} for the label values joined in key, plus le if not empty
+func formatLabels(names []string, key string, le string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, value := range strings.Split(key, labelSeparator) {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, names[i], labelValueEscaper.Replace(value)))
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("le=%q", le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
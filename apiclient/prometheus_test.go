@@ -0,0 +1,120 @@
+package apiclient
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestFormatLabelsEscapesOnlyWhatTheFormatRequires(t *testing.T) {
+	got := formatLabels([]string{"market"}, "café \"x\"\\\n", "")
+	want := `{market="café \"x\"\\\n"}`
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestPrometheusWrite(t *testing.T) {
+	m := NewPrometheusMetrics()
+	m.ObserveRequest("GET", "/v1/markets", 200, 30*time.Millisecond)
+	m.ObserveRequest("GET", "/v1/markets", 200, 12*time.Second)
+	m.IncWebsocketMessages(TopOfBooksSpot())
+
+	var out strings.Builder
+	if err := m.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP enclave_requests_total REST requests by endpoint and status.
+# TYPE enclave_requests_total counter
+enclave_requests_total{method="GET",endpoint="/v1/markets",status="200"} 2
+# HELP enclave_websocket_messages_total Websocket updates received by channel.
+# TYPE enclave_websocket_messages_total counter
+enclave_websocket_messages_total{channel="topOfBooksSpot"} 1
+# HELP enclave_websocket_reconnects_total Websocket reconnections.
+# TYPE enclave_websocket_reconnects_total counter
+# HELP enclave_risk_rejections_total Orders rejected by pre-trade risk checks.
+# TYPE enclave_risk_rejections_total counter
+# HELP enclave_request_duration_seconds REST request latency.
+# TYPE enclave_request_duration_seconds histogram
+enclave_request_duration_seconds_bucket{method="GET",endpoint="/v1/markets",le="0.001"} 0
+enclave_request_duration_seconds_bucket{method="GET",endpoint="/v1/markets",le="0.0025"} 0
+enclave_request_duration_seconds_bucket{method="GET",endpoint="/v1/markets",le="0.005"} 0
+enclave_request_duration_seconds_bucket{method="GET",endpoint="/v1/markets",le="0.01"} 0
+enclave_request_duration_seconds_bucket{method="GET",endpoint="/v1/markets",le="0.025"} 0
+enclave_request_duration_seconds_bucket{method="GET",endpoint="/v1/markets",le="0.05"} 1
+enclave_request_duration_seconds_bucket{method="GET",endpoint="/v1/markets",le="0.1"} 1
+enclave_request_duration_seconds_bucket{method="GET",endpoint="/v1/markets",le="0.25"} 1
+enclave_request_duration_seconds_bucket{method="GET",endpoint="/v1/markets",le="0.5"} 1
+enclave_request_duration_seconds_bucket{method="GET",endpoint="/v1/markets",le="1"} 1
+enclave_request_duration_seconds_bucket{method="GET",endpoint="/v1/markets",le="2.5"} 1
+enclave_request_duration_seconds_bucket{method="GET",endpoint="/v1/markets",le="5"} 1
+enclave_request_duration_seconds_bucket{method="GET",endpoint="/v1/markets",le="10"} 1
+enclave_request_duration_seconds_bucket{method="GET",endpoint="/v1/markets",le="+Inf"} 2
+enclave_request_duration_seconds_sum{method="GET",endpoint="/v1/markets"} 12.03
+enclave_request_duration_seconds_count{method="GET",endpoint="/v1/markets"} 2
+# HELP enclave_order_submit_duration_seconds Time from sending an order to its acknowledgement.
+# TYPE enclave_order_submit_duration_seconds histogram
+# HELP enclave_rate_limit_wait_seconds Time requests waited for the rate limiter.
+# TYPE enclave_rate_limit_wait_seconds histogram
+# HELP enclave_websocket_ping_rtt_seconds Websocket ping round trip time.
+# TYPE enclave_websocket_ping_rtt_seconds histogram
+`
+	if out.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestMetricsHooksFire(t *testing.T) {
+	m := NewPrometheusMetrics()
+
+	ex := newFakeExchange(t)
+	ex.handle("GET "+models.V1MarketsPath, func(fakeRequest) (int, any) { return ok(models.V1GetMarketsResult{}) })
+	ex.handle("POST "+models.V1SpotOrdersPath, func(fakeRequest) (int, any) { return ok(models.ApiOrder{OrderID: "o1"}) })
+	client := ex.client().WithMetrics(m)
+	if _, err := client.Markets(); err != nil {
+		t.Fatal(err)
+	}
+	order := models.AddOrderReq{Market: "AVAX-USDC", Side: models.Bid, Price: decimal.NewFromInt(10), Size: decimal.NewFromInt(1)}
+	if _, err := client.AddSpotOrder(order); err != nil {
+		t.Fatal(err)
+	}
+
+	ws := newFakeWebsocket(t)
+	d := NewApiClient(ws.srv.URL).WithApiKey("key", "secret").WithMetrics(m).NewDispatcher()
+	books := make(chan Event, 1)
+	d.Handle(TopOfBooksSpot(), "", HandlerOptions{}, func(e Event) { books <- e })
+	if err := d.Subscribe(TopOfBooksSpot(), "AVAX-USDC"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	ws.next(t, Subscribe, TopOfBooksSpot(), time.Second)
+	ws.broadcast(map[string]any{"type": Update, "channel": TopOfBooksSpot(), "data": []models.ApiBookSnapshot{{Market: "AVAX-USDC"}}})
+	select {
+	case <-books:
+	case <-time.After(time.Second):
+		t.Fatal("book update not delivered")
+	}
+
+	var out strings.Builder
+	if err := m.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`enclave_requests_total{method="GET",endpoint="/v1/markets",status="200"} 1`,
+		`enclave_requests_total{method="POST",endpoint="/v1/orders",status="200"} 1`,
+		`enclave_order_submit_duration_seconds_count{product="spot",outcome="accepted"} 1`,
+		`enclave_websocket_messages_total{channel="topOfBooksSpot"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("missing %s in\n%s", line, out.String())
+		}
+	}
+}
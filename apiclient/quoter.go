@@ -0,0 +1,446 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+// QuoteLevel is one rung of a quoting ladder
+type QuoteLevel struct {
+	// Distance from the fair price
+	Offset decimal.Decimal
+	Size   decimal.Decimal
+}
+
+type QuoteConfig struct {
+	Product Product
+	Market  models.Market
+
+	Bids []QuoteLevel
+	Asks []QuoteLevel
+
+	// The market's increments. Bid prices are rounded down and ask prices up to QuoteIncrement, and sizes down to
+	// BaseIncrement.
+	QuoteIncrement decimal.Decimal
+	BaseIncrement  decimal.Decimal
+
+	// Price shift per unit of inventory. The fair price is the mid price less inventory times SkewPerUnit, so a long
+	// position lowers quotes to sell it down, and a short one raises them.
+	SkewPerUnit decimal.Decimal
+
+	// Stop quoting bids once inventory reaches MaxInventory, and asks once it reaches -MaxInventory. Zero means no
+	// limit.
+	MaxInventory decimal.Decimal
+
+	// Live quotes within Tolerance of their desired price are left alone rather than replaced
+	Tolerance decimal.Decimal
+
+	// Quotes are pulled if no book update arrives for this long. Defaults to DefaultStaleBookAfter.
+	StaleAfter time.Duration
+}
+
+const DefaultStaleBookAfter = 5 * time.Second
+
+// Inventory gives the position that quotes are skewed by, e.g. a PositionTracker
+type Inventory interface {
+	Position(market models.Market) decimal.Decimal
+}
+
+// Quoter maintains a ladder of post-only bids and asks around the mid price of one market. On every book update it
+// diffs the desired ladder against the live quotes, cancels only those that moved, and adds the rest in one batch.
+// Orders are cancelled and added without holding the quoter's lock, so fills are counted while a requote is sent.
+type Quoter struct {
+	client    *ApiClient
+	cfg       QuoteConfig
+	inventory Inventory
+
+	// Held while quotes are being cancelled or added, so requotes and pulls never interleave
+	sending sync.Mutex
+
+	mu       sync.Mutex
+	live     map[models.OrderID]*liveQuote
+	lastBook time.Time
+	pulled   bool
+	err      error
+}
+
+type liveQuote struct {
+	side      models.BidAsk
+	price     decimal.Decimal
+	size      decimal.Decimal
+	remaining decimal.Decimal
+
+	// The batch that added the quote failed in a way that leaves it unknown whether the quote was placed. It is
+	// never kept by a requote, so it is cancelled on the next one.
+	unconfirmed bool
+}
+
+type desiredQuote struct {
+	side  models.BidAsk
+	price decimal.Decimal
+	size  decimal.Decimal
+}
+
+func (c *ApiClient) NewQuoter(cfg QuoteConfig, inventory Inventory) (*Quoter, error) {
+	if !cfg.QuoteIncrement.IsPositive() || !cfg.BaseIncrement.IsPositive() {
+		return nil, errors.New("bad quote config: quote and base increments must be positive")
+	}
+	if cfg.StaleAfter <= 0 {
+		cfg.StaleAfter = DefaultStaleBookAfter
+	}
+	return &Quoter{client: c, cfg: cfg, inventory: inventory, live: map[models.OrderID]*liveQuote{}}, nil
+}
+
+// OnBook requotes around the new top of book
+func (q *Quoter) OnBook(book *models.ApiBookSnapshot) {
+	if book.Market != q.cfg.Market {
+		return
+	}
+
+	q.sending.Lock()
+	defer q.sending.Unlock()
+	q.mu.Lock()
+	q.lastBook = time.Now()
+	q.pulled = false
+	cancel, add := q.diff(q.desired(book))
+	q.mu.Unlock()
+
+	err := q.cancel(cancel)
+	if err == nil {
+		err = q.add(add)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.err = err
+}
+
+// desired computes the ladder for book, called with q.mu held
+func (q *Quoter) desired(book *models.ApiBookSnapshot) []desiredQuote {
+	if len(book.Bids) == 0 || len(book.Asks) == 0 {
+		return nil
+	}
+	bid, ask := book.Bids[0].Price, book.Asks[0].Price
+	increment := q.cfg.QuoteIncrement
+
+	inventory := decimal.Zero
+	if q.inventory != nil {
+		inventory = q.inventory.Position(q.cfg.Market)
+	}
+	fair := bid.Add(ask).Div(decimal.NewFromInt(2)).Sub(inventory.Mul(q.cfg.SkewPerUnit))
+	limited := q.cfg.MaxInventory.IsPositive()
+
+	var quotes []desiredQuote
+	if !limited || inventory.LessThan(q.cfg.MaxInventory) {
+		for _, level := range q.cfg.Bids {
+			price := fair.Sub(level.Offset).Div(increment).Floor().Mul(increment)
+			price = decimal.Min(price, ask.Sub(increment))
+			quotes = append(quotes, desiredQuote{side: models.Bid, price: price, size: q.roundSize(level.Size)})
+		}
+	}
+	if !limited || inventory.GreaterThan(q.cfg.MaxInventory.Neg()) {
+		for _, level := range q.cfg.Asks {
+			price := fair.Add(level.Offset).Div(increment).Ceil().Mul(increment)
+			price = decimal.Max(price, bid.Add(increment))
+			quotes = append(quotes, desiredQuote{side: models.Ask, price: price, size: q.roundSize(level.Size)})
+		}
+	}
+	return quotes
+}
+
+func (q *Quoter) roundSize(size decimal.Decimal) decimal.Decimal {
+	return size.Div(q.cfg.BaseIncrement).Floor().Mul(q.cfg.BaseIncrement)
+}
+
+// diff returns the live quotes that don't match a desired one, to cancel, and the desired quotes that aren't live,
+// to add once the cancels have succeeded. It is called with q.mu held.
+func (q *Quoter) diff(desired []desiredQuote) ([]models.OrderID, []*models.AddOrderReq) {
+	kept := map[models.OrderID]bool{}
+	var add []*models.AddOrderReq
+	for _, want := range desired {
+		if !want.size.IsPositive() || !want.price.IsPositive() {
+			continue
+		}
+		if id, ok := q.match(want, kept); ok {
+			kept[id] = true
+			continue
+		}
+		add = append(add, &models.AddOrderReq{
+			Side:          want.side,
+			Price:         want.price,
+			Size:          want.size,
+			Market:        q.cfg.Market,
+			ClientOrderID: NewClientOrderID("mm-"),
+			Type:          models.OrderTypeLimit,
+			PostOnly:      true,
+		})
+	}
+
+	var cancel []models.OrderID
+	for id := range q.live {
+		if !kept[id] {
+			cancel = append(cancel, id)
+		}
+	}
+	return cancel, add
+}
+
+// match finds an unfilled, confirmed live quote close enough to want that isn't already kept
+func (q *Quoter) match(want desiredQuote, kept map[models.OrderID]bool) (models.OrderID, bool) {
+	for id, live := range q.live {
+		if kept[id] || live.unconfirmed || live.side != want.side || !live.remaining.Equal(want.size) {
+			continue
+		}
+		if live.price.Sub(want.price).Abs().LessThanOrEqual(q.cfg.Tolerance) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// cancel cancels quotes by client order ID. A quote stays tracked if its cancel fails, unless the exchange says
+// it no longer exists or it is found to be filled or cancelled already. It is called with q.sending held and q.mu
+// not held.
+func (q *Quoter) cancel(ids []models.OrderID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var gone []models.OrderID
+	var errs []error
+	defer func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for _, id := range gone {
+			delete(q.live, id)
+		}
+	}()
+
+	if q.cfg.Product == ProductPerps {
+		clientIds := make([]models.ClientOrderID, 0, len(ids))
+		for _, id := range ids {
+			clientIds = append(clientIds, models.ClientOrderID(id))
+		}
+		res, err := q.client.CancelPerpsOrdersByClientId(clientIds)
+		if err != nil {
+			return err
+		}
+		failed := map[models.OrderID]string{}
+		for _, cancel := range res.Result.FailedCancels {
+			failed[models.OrderID(strings.TrimPrefix(cancel.OrderID, models.V1SpotClientOrderIDPrefix))] = cancel.Error
+		}
+		for _, id := range ids {
+			if reason, ok := failed[id]; ok {
+				if err := q.cancelFailed(id, fmt.Errorf("quote %s failed to cancel: %s", id, reason)); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+			}
+			gone = append(gone, id)
+		}
+		return errors.Join(errs...)
+	}
+
+	for _, id := range ids {
+		if _, err := q.client.CancelSpotOrderByClientID(id); err != nil {
+			if err := q.cancelFailed(id, err); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		gone = append(gone, id)
+	}
+	return errors.Join(errs...)
+}
+
+// cancelFailed looks up a quote whose cancel failed. It returns err if the quote may still be live, and nil if it
+// is gone from the book.
+func (q *Quoter) cancelFailed(id models.OrderID, err error) error {
+	if IsNotFound(err) {
+		return nil
+	}
+	order, lookupErr := q.client.getOrderByClientID(q.cfg.Product, id)
+	switch {
+	case IsNotFound(lookupErr):
+	case lookupErr != nil:
+		return errors.Join(err, lookupErr)
+	case order.State == models.New || order.State == models.Open:
+		return err
+	}
+	return nil
+}
+
+// add adds quotes in one batch. It is called with q.sending held and q.mu not held.
+func (q *Quoter) add(orders []*models.AddOrderReq) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	// Track the quotes before sending: their fills can arrive before the batch is acknowledged
+	q.mu.Lock()
+	for _, order := range orders {
+		q.live[order.ClientOrderID] = &liveQuote{side: order.Side, price: order.Price, size: order.Size, remaining: order.Size}
+	}
+	q.mu.Unlock()
+
+	add := q.client.AddSpotBatchOrders
+	if q.cfg.Product == ProductPerps {
+		add = q.client.AddPerpsBatchOrders
+	}
+	res, err := add(models.BatchAddOrderReq{Orders: orders})
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err != nil {
+		for _, order := range orders {
+			if definitelyNotPlaced(err) {
+				delete(q.live, order.ClientOrderID)
+			} else if live, ok := q.live[order.ClientOrderID]; ok {
+				// The quotes may be on the book: keep them until a cancel confirms they are gone
+				live.unconfirmed = true
+			}
+		}
+		return err
+	}
+
+	// Failed orders may not say which quote they were, so drop every quote the batch didn't add to the book
+	added := map[models.OrderID]bool{}
+	for _, order := range res.Result.AddedOrders {
+		if order != nil && !orderClosed(order) {
+			added[order.ClientOrderID] = true
+		}
+	}
+	missing := 0
+	for _, order := range orders {
+		if !added[order.ClientOrderID] {
+			delete(q.live, order.ClientOrderID)
+			missing++
+		}
+	}
+	switch {
+	case missing == 0:
+		return nil
+	case len(res.Result.FailedOrders) > 0:
+		return fmt.Errorf("%d quotes failed to add: %s", missing, res.Result.FailedOrders[0].ErrorMessage)
+	default:
+		return fmt.Errorf("%d quotes failed to add", missing)
+	}
+}
+
+// OnFill reduces the remaining size of a live quote
+func (q *Quoter) OnFill(fill *models.ApiFill) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	live, ok := q.live[fill.ClientOrderID]
+	if !ok {
+		return
+	}
+	live.remaining = live.remaining.Sub(fill.Size)
+	if !live.remaining.IsPositive() {
+		delete(q.live, fill.ClientOrderID)
+	}
+}
+
+// Pull cancels the quoter's orders and stops quoting until the next book update. Other orders on the market are
+// left alone.
+func (q *Quoter) Pull() error {
+	q.sending.Lock()
+	defer q.sending.Unlock()
+	return q.pull()
+}
+
+// pull is called with q.sending held and q.mu not held
+func (q *Quoter) pull() error {
+	q.mu.Lock()
+	q.pulled = true
+	ids := make([]models.OrderID, 0, len(q.live))
+	for id := range q.live {
+		ids = append(ids, id)
+	}
+	q.mu.Unlock()
+	return q.cancel(ids)
+}
+
+// pullAndRecord pulls quotes and records the result as the quoter's error. It is called with q.sending held and
+// q.mu not held.
+func (q *Quoter) pullAndRecord() {
+	err := q.pull()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.err = err
+}
+
+// Err returns the error of the last requote, if it failed
+func (q *Quoter) Err() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+// LiveQuotes returns the number of quotes believed to be on the book
+func (q *Quoter) LiveQuotes() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.live)
+}
+
+// HandleEvent passes a dispatcher event to OnBook or OnFill
+func (q *Quoter) HandleEvent(event Event) {
+	switch data := event.Data.(type) {
+	case *models.ApiBookSnapshot:
+		q.OnBook(data)
+	case *models.ApiFill:
+		q.OnFill(data)
+	}
+}
+
+// Attach feeds the quoter from d's top of book and fills channels for its market, which still need to be
+// subscribed, and pulls quotes whenever d disconnects
+func (q *Quoter) Attach(d *Dispatcher) {
+	books, fills := TopOfBooksSpot(), FillsSpot()
+	if q.cfg.Product == ProductPerps {
+		books, fills = TopOfBooksPerps(), FillsPerps()
+	}
+	d.Handle(books, q.cfg.Market, HandlerOptions{Policy: ConflateLatest}, q.HandleEvent)
+	d.Handle(fills, q.cfg.Market, HandlerOptions{Policy: Block}, q.HandleEvent)
+	d.OnConnectionChange(func(connected bool) {
+		if !connected {
+			q.sending.Lock()
+			defer q.sending.Unlock()
+			q.pullAndRecord()
+		}
+	})
+}
+
+// Run pulls quotes whenever the book has not been updated for StaleAfter, until ctx is done, and then pulls them
+// a final time
+func (q *Quoter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(q.cfg.StaleAfter / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := q.Pull(); err != nil {
+				return err
+			}
+			return ctx.Err()
+		case <-ticker.C:
+			q.sending.Lock()
+			q.mu.Lock()
+			// Pulling again retries quotes that failed to cancel
+			stale := !q.lastBook.IsZero() && time.Since(q.lastBook) > q.cfg.StaleAfter
+			pull := stale && (!q.pulled || len(q.live) > 0)
+			q.mu.Unlock()
+			if pull {
+				q.pullAndRecord()
+			}
+			q.sending.Unlock()
+		}
+	}
+}
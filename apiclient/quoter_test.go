@@ -0,0 +1,251 @@
+package apiclient
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestQuoterPullTracksFailedCancels(t *testing.T) {
+	tests := []struct {
+		name         string
+		cancelStatus int
+		// State the quote is found in after a failed cancel
+		lookupState models.OrderState
+		wantLive    int
+		wantErr     bool
+	}{
+		{name: "cancelled", cancelStatus: http.StatusOK, wantLive: 0},
+		{name: "not found", cancelStatus: http.StatusNotFound, wantLive: 0},
+		{name: "failed but already filled", cancelStatus: http.StatusInternalServerError, lookupState: models.FullyFilled, wantLive: 0},
+		{name: "failed and still open", cancelStatus: http.StatusInternalServerError, lookupState: models.Open, wantLive: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newFakeExchange(t)
+			ex.handle("POST "+models.V1SpotBatchOrdersPath, func(req fakeRequest) (int, any) {
+				var batch models.BatchAddOrderReq
+				req.decode(t, &batch)
+				var added []*models.ApiOrder
+				for _, order := range batch.Orders {
+					added = append(added, &models.ApiOrder{ClientOrderID: order.ClientOrderID, State: models.Open})
+				}
+				return ok(models.BatchAddOrderRes{AddedOrders: added})
+			})
+			prefix := models.V1SpotOrdersPath + "/" + models.V1SpotClientOrderIDPrefix
+			ex.handle("DELETE "+prefix+"*", func(fakeRequest) (int, any) {
+				return tt.cancelStatus, models.GenericResponse[any]{Success: tt.cancelStatus == http.StatusOK}
+			})
+			ex.handle("GET "+prefix+"*", func(req fakeRequest) (int, any) {
+				return ok(models.ApiOrder{ClientOrderID: models.OrderID(strings.TrimPrefix(req.Path, prefix)), State: tt.lookupState})
+			})
+
+			quoter, err := ex.client().NewQuoter(QuoteConfig{Product: ProductSpot, Market: "AVAX-USDC",
+				Bids:           []QuoteLevel{{Offset: decimal.NewFromInt(1), Size: decimal.NewFromInt(1)}},
+				Asks:           []QuoteLevel{{Offset: decimal.NewFromInt(1), Size: decimal.NewFromInt(1)}},
+				QuoteIncrement: decimal.RequireFromString("0.01"), BaseIncrement: decimal.RequireFromString("0.01")}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			quoter.OnBook(book("AVAX-USDC", "40", "41"))
+			if n := quoter.LiveQuotes(); n != 2 {
+				t.Fatalf("%d live quotes, want 2", n)
+			}
+
+			err = quoter.Pull()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if n := quoter.LiveQuotes(); n != tt.wantLive {
+				t.Fatalf("%d live quotes after pull, want %d", n, tt.wantLive)
+			}
+			if n := len(ex.requests("DELETE " + models.V1SpotOrdersPath)); n != 0 {
+				t.Fatalf("pull cancelled every order on the market %d times", n)
+			}
+		})
+	}
+}
+
+func TestQuoterPerpsCancelKeepsFailedQuotes(t *testing.T) {
+	ex := newFakeExchange(t)
+	var clientIDs []models.OrderID
+	ex.handle("POST "+models.V1PerpsBatchOrdersPath, func(req fakeRequest) (int, any) {
+		var batch models.BatchAddOrderReq
+		req.decode(t, &batch)
+		var added []*models.ApiOrder
+		for _, order := range batch.Orders {
+			clientIDs = append(clientIDs, order.ClientOrderID)
+			added = append(added, &models.ApiOrder{ClientOrderID: order.ClientOrderID, State: models.Open})
+		}
+		return ok(models.BatchAddOrderRes{AddedOrders: added})
+	})
+	ex.handle("DELETE "+models.V1PerpsBatchOrdersPath, func(fakeRequest) (int, any) {
+		return ok(models.BatchCancelRes{FailedCancels: []*models.CancelError{{OrderID: "client:" + string(clientIDs[0]), Error: "busy"}}})
+	})
+	ex.handle("GET "+models.V1PerpsOrdersPath+"/client:*", func(fakeRequest) (int, any) {
+		return ok(models.ApiOrder{State: models.Open})
+	})
+
+	quoter, err := ex.client().NewQuoter(QuoteConfig{Product: ProductPerps, Market: "ETH-USD.P",
+		Bids:           []QuoteLevel{{Offset: decimal.NewFromInt(1), Size: decimal.NewFromInt(1)}},
+		Asks:           []QuoteLevel{{Offset: decimal.NewFromInt(1), Size: decimal.NewFromInt(1)}},
+		QuoteIncrement: decimal.RequireFromString("0.01"), BaseIncrement: decimal.RequireFromString("0.01")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quoter.OnBook(book("ETH-USD.P", "3000", "3001"))
+	if err := quoter.Pull(); err == nil {
+		t.Fatal("no error for a failed cancel")
+	}
+	if n := quoter.LiveQuotes(); n != 1 {
+		t.Fatalf("%d live quotes after pull, want the one that failed to cancel", n)
+	}
+}
+
+func TestQuoterKeepsQuotesThatMayHaveBeenAdded(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		wantLive int
+	}{
+		{name: "may have been placed", status: http.StatusInternalServerError, wantLive: 2},
+		{name: "rejected", status: http.StatusBadRequest, wantLive: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newFakeExchange(t)
+			sending, release := make(chan struct{}), make(chan struct{})
+			ex.handle("POST "+models.V1SpotBatchOrdersPath, func(fakeRequest) (int, any) {
+				close(sending)
+				<-release
+				return tt.status, models.GenericResponse[any]{Error: "failed"}
+			})
+			prefix := models.V1SpotOrdersPath + "/" + models.V1SpotClientOrderIDPrefix
+			ex.handle("DELETE "+prefix+"*", func(fakeRequest) (int, any) { return ok[any](nil) })
+
+			quoter, err := ex.client().NewQuoter(QuoteConfig{Product: ProductSpot, Market: "AVAX-USDC",
+				Bids:           []QuoteLevel{{Offset: decimal.NewFromInt(1), Size: decimal.NewFromInt(1)}},
+				Asks:           []QuoteLevel{{Offset: decimal.NewFromInt(1), Size: decimal.NewFromInt(1)}},
+				QuoteIncrement: decimal.RequireFromString("0.01"), BaseIncrement: decimal.RequireFromString("0.01")}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan struct{})
+			go func() {
+				quoter.OnBook(book("AVAX-USDC", "40", "41"))
+				close(done)
+			}()
+
+			// The lock isn't held while the batch is being sent
+			<-sending
+			counted := make(chan int)
+			go func() { counted <- quoter.LiveQuotes() }()
+			select {
+			case <-counted:
+			case <-time.After(time.Second):
+				t.Fatal("LiveQuotes blocked while quotes were being added")
+			}
+			close(release)
+			<-done
+
+			if quoter.Err() == nil {
+				t.Fatal("no error reported for the failed batch")
+			}
+			if n := quoter.LiveQuotes(); n != tt.wantLive {
+				t.Fatalf("%d live quotes, want %d", n, tt.wantLive)
+			}
+
+			// Quotes that may be on the book are cancelled by the next requote rather than kept
+			ex.handle("POST "+models.V1SpotBatchOrdersPath, func(req fakeRequest) (int, any) {
+				var batch models.BatchAddOrderReq
+				req.decode(t, &batch)
+				var added []*models.ApiOrder
+				for _, order := range batch.Orders {
+					added = append(added, &models.ApiOrder{ClientOrderID: order.ClientOrderID, State: models.Open})
+				}
+				return ok(models.BatchAddOrderRes{AddedOrders: added})
+			})
+			quoter.OnBook(book("AVAX-USDC", "40", "41"))
+			if err := quoter.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(ex.requests("DELETE " + prefix + "*")); n != tt.wantLive {
+				t.Fatalf("cancelled %d quotes, want %d", n, tt.wantLive)
+			}
+			if n := quoter.LiveQuotes(); n != 2 {
+				t.Fatalf("%d live quotes after requoting, want 2", n)
+			}
+		})
+	}
+}
+
+func TestQuoterDropsQuotesTheBatchDidNotAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		// How the batch reports the second quote, which isn't added
+		failed    bool
+		withOrder bool
+	}{
+		{name: "failed with its order", failed: true, withOrder: true},
+		{name: "failed without its order", failed: true},
+		{name: "missing from the response"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newFakeExchange(t)
+			var added []models.OrderID
+			ex.handle("POST "+models.V1SpotBatchOrdersPath, func(req fakeRequest) (int, any) {
+				var batch models.BatchAddOrderReq
+				req.decode(t, &batch)
+				var res models.BatchAddOrderRes
+				first := len(added) == 0
+				for i, order := range batch.Orders {
+					if first && i > 0 {
+						if tt.failed {
+							failed := &models.ErroredAddOrderReq{ErrorMessage: "insufficient balance"}
+							if tt.withOrder {
+								failed.Order = order
+							}
+							res.FailedOrders = append(res.FailedOrders, failed)
+						}
+						continue
+					}
+					added = append(added, order.ClientOrderID)
+					res.AddedOrders = append(res.AddedOrders, &models.ApiOrder{ClientOrderID: order.ClientOrderID, State: models.Open})
+				}
+				return ok(res)
+			})
+
+			quoter, err := ex.client().NewQuoter(QuoteConfig{Product: ProductSpot, Market: "AVAX-USDC",
+				Bids:           []QuoteLevel{{Offset: decimal.NewFromInt(1), Size: decimal.NewFromInt(1)}},
+				Asks:           []QuoteLevel{{Offset: decimal.NewFromInt(1), Size: decimal.NewFromInt(1)}},
+				QuoteIncrement: decimal.RequireFromString("0.01"), BaseIncrement: decimal.RequireFromString("0.01")}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			quoter.OnBook(book("AVAX-USDC", "40", "41"))
+			if quoter.Err() == nil {
+				t.Fatal("no error reported for the quote that wasn't added")
+			}
+			if n := quoter.LiveQuotes(); n != 1 {
+				t.Fatalf("%d live quotes, want only the one added", n)
+			}
+
+			// The next requote places the missing quote rather than treating it as live
+			quoter.OnBook(book("AVAX-USDC", "40", "41"))
+			if err := quoter.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if n := quoter.LiveQuotes(); n != 2 {
+				t.Fatalf("%d live quotes after requoting, want 2", n)
+			}
+			if len(added) != 2 {
+				t.Fatalf("%d quotes added, want the missing one added again", len(added))
+			}
+		})
+	}
+}
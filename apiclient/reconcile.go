@@ -0,0 +1,451 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+// Checkpoint is the account state a process expected at a point in time. Save one periodically so that the
+// account can be reconciled against it after a restart.
+type Checkpoint struct {
+	// When the state was read. The next Reconcile counts the fills from this time on.
+	Time        time.Time                           `json:"time"`
+	SpotOrders  map[models.OrderID]*models.ApiOrder `json:"spotOrders"`
+	PerpsOrders map[models.OrderID]*models.ApiOrder `json:"perpsOrders"`
+	Positions   map[models.Market]decimal.Decimal   `json:"positions"`
+	Balances    map[models.Symbol]decimal.Decimal   `json:"balances"`
+
+	// Spot inventory per market, as kept by a PositionTracker
+	SpotPositions map[models.Market]decimal.Decimal `json:"spotPositions,omitempty"`
+
+	// Spot fills around or after Time that Balances and SpotPositions already include, skipped by the next
+	// Reconcile
+	CountedFills []models.FillID `json:"countedFills,omitempty"`
+}
+
+// SaveCheckpoint writes cp to path, replacing any previous checkpoint atomically
+func SaveCheckpoint(path string, cp *Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".checkpoint-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadCheckpoint reads a checkpoint written by SaveCheckpoint. It returns nil without an error if there is none.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+	return &cp, nil
+}
+
+// AccountState is the account as read from the exchange
+type AccountState struct {
+	Time        time.Time
+	SpotOrders  map[models.OrderID]*models.ApiOrder
+	PerpsOrders map[models.OrderID]*models.ApiOrder
+	Positions   map[models.Market]decimal.Decimal
+	Balances    map[models.Symbol]decimal.Decimal
+
+	// The checkpoint's spot inventory plus the spot fills since
+	SpotPositions map[models.Market]decimal.Decimal
+
+	// Spot and perps fills since the checkpoint, oldest first. Spot fills the checkpoint already counted are left
+	// out.
+	Fills      []*models.ApiFill
+	PerpsFills []*models.ApiFill
+
+	// Spot fills the balances include that the next Reconcile will list again
+	CountedFills []models.FillID
+}
+
+// Checkpoint returns the state as a checkpoint to save
+func (s *AccountState) Checkpoint() *Checkpoint {
+	return &Checkpoint{
+		Time:        s.Time,
+		SpotOrders:  s.SpotOrders,
+		PerpsOrders: s.PerpsOrders,
+		Positions:   s.Positions,
+		Balances:    s.Balances,
+
+		SpotPositions: s.SpotPositions,
+		CountedFills:  s.CountedFills,
+	}
+}
+
+// ApplyTo loads the perps positions and spot inventory into a position tracker, and the open orders into an order
+// tracker. Either may be nil.
+func (s *AccountState) ApplyTo(positions *PositionTracker, orders *OrderTracker) {
+	if positions != nil {
+		positions.mu.Lock()
+		for market := range positions.perps {
+			positions.positions[market] = decimal.Zero
+		}
+		for market, quantity := range s.Positions {
+			positions.positions[market] = quantity
+			positions.perps[market] = true
+		}
+		for market, quantity := range s.SpotPositions {
+			positions.positions[market] = quantity
+		}
+		positions.mu.Unlock()
+	}
+
+	if orders != nil {
+		spot := make([]*models.ApiOrder, 0, len(s.SpotOrders))
+		for _, order := range s.SpotOrders {
+			spot = append(spot, order)
+		}
+		perps := make([]*models.ApiOrder, 0, len(s.PerpsOrders))
+		for _, order := range s.PerpsOrders {
+			perps = append(perps, order)
+		}
+		orders.mu.Lock()
+		orders.reset(map[Product][]*models.ApiOrder{ProductSpot: spot, ProductPerps: perps})
+		orders.mu.Unlock()
+	}
+}
+
+type DiscrepancyKind string
+
+const (
+	// An order open on the exchange that the checkpoint didn't know about
+	UnexpectedOrder DiscrepancyKind = "unexpectedOrder"
+
+	// An order open at the checkpoint that is no longer open and has no fills since
+	MissingOrder DiscrepancyKind = "missingOrder"
+
+	// A perps position that changed since the checkpoint
+	PositionChanged DiscrepancyKind = "positionChanged"
+
+	// A balance that differs from the checkpoint balance plus the spot fills since
+	BalanceMismatch DiscrepancyKind = "balanceMismatch"
+)
+
+type Discrepancy struct {
+	Kind    DiscrepancyKind
+	Product Product
+	OrderID models.OrderID
+	Market  models.Market
+	Symbol  models.Symbol
+
+	Expected decimal.Decimal
+	Actual   decimal.Decimal
+}
+
+func (d Discrepancy) String() string {
+	switch d.Kind {
+	case UnexpectedOrder, MissingOrder:
+		return fmt.Sprintf("%s: %s order %s on %s", d.Kind, d.Product, d.OrderID, d.Market)
+	case PositionChanged:
+		return fmt.Sprintf("%s: %s expected %s, actual %s", d.Kind, d.Market, d.Expected, d.Actual)
+	default:
+		return fmt.Sprintf("%s: %s expected %s, actual %s", d.Kind, d.Symbol, d.Expected, d.Actual)
+	}
+}
+
+type ReconcileReport struct {
+	State         *AccountState
+	Discrepancies []Discrepancy
+
+	// Balances in the checkpoint that weren't compared: the perps margin balance once perps have traded or a
+	// position was open, because realized PnL and funding change it and funding payments can't be read
+	UncheckedBalances []models.Symbol
+}
+
+// reconcileAttempts is how many times Reconcile reads the balances while spot fills keep arriving
+const reconcileAttempts = 5
+
+// countedFillSlack is how long before the state was read a fill is still recorded as counted, to allow for the
+// exchange's clock being behind
+const countedFillSlack = time.Minute
+
+// PerpsMarginSymbol is the currency perps are margined and settled in. Reconcile doesn't compare its balance when
+// perps have been traded or held since the checkpoint.
+var PerpsMarginSymbol models.Symbol = "USDC"
+
+// Reconcile reads open orders, spot and perps fills since the checkpoint, balances and positions, and compares them
+// with what the checkpoint expected. Balances are read for the symbols in the checkpoint and symbols. Spot inventory
+// is rebuilt from the checkpoint and the spot fills since. Perps positions aren't rebuilt from fills, so perps
+// position changes are reported as discrepancies to review, and the PerpsMarginSymbol balance is left unchecked
+// if perps were traded or held. Spot fills are listed before and after the balances are read, until none arrive in
+// between, so the balances and spot inventory include exactly the fills counted. With no checkpoint, only the state
+// is returned.
+func (c *ApiClient) Reconcile(ctx context.Context, checkpoint *Checkpoint, symbols ...models.Symbol) (*ReconcileReport, error) {
+	client := c.WithContext(ctx)
+	state := &AccountState{
+		Time:        time.Now(),
+		SpotOrders:  map[models.OrderID]*models.ApiOrder{},
+		PerpsOrders: map[models.OrderID]*models.ApiOrder{},
+		Positions:   map[models.Market]decimal.Decimal{},
+		Balances:    map[models.Symbol]decimal.Decimal{},
+
+		SpotPositions: map[models.Market]decimal.Decimal{},
+	}
+
+	open := models.OrderParams{Status: models.Open.String()}
+	spot, err := listAllOrders(client.GetSpotOrders, open)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range spot {
+		state.SpotOrders[order.OrderID] = order
+	}
+	perps, err := listAllOrders(client.GetPerpsOrders, open)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range perps {
+		state.PerpsOrders[order.OrderID] = order
+	}
+
+	positions, err := client.GetPerpsPositions()
+	if err != nil {
+		return nil, err
+	}
+	for _, position := range positions.Result {
+		state.Positions[position.Market] = position.SignedQuantity()
+	}
+
+	if checkpoint != nil {
+		for symbol := range checkpoint.Balances {
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	start := state.Time
+	if checkpoint != nil {
+		start = checkpoint.Time
+	}
+	spotFills, err := listAllFills(client.GetSpotFills, models.FillParams{StartTime: &start})
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		if err := readBalances(client, symbols, state.Balances); err != nil {
+			return nil, err
+		}
+		after, err := listAllFills(client.GetSpotFills, models.FillParams{StartTime: &start})
+		if err != nil {
+			return nil, err
+		}
+		if sameFills(spotFills, after) {
+			break
+		}
+		if attempt == reconcileAttempts {
+			return nil, errors.New("spot fills kept arriving while balances were read")
+		}
+		spotFills = after
+	}
+
+	counted := map[models.FillID]bool{}
+	if checkpoint != nil {
+		for _, id := range checkpoint.CountedFills {
+			counted[id] = true
+		}
+	}
+	for _, fill := range spotFills {
+		if !fill.CreatedAt.Before(state.Time.Add(-countedFillSlack)) {
+			state.CountedFills = append(state.CountedFills, fill.FillID)
+		}
+		if checkpoint != nil && !counted[fill.FillID] {
+			state.Fills = append(state.Fills, fill)
+		}
+	}
+
+	report := &ReconcileReport{State: state}
+	if checkpoint == nil {
+		return report, nil
+	}
+
+	state.PerpsFills, err = listAllFills(client.GetPerpsFills, models.FillParams{StartTime: &start})
+	if err != nil {
+		return nil, err
+	}
+	for _, fill := range state.Fills {
+		if fill.UnknownSide != "" {
+			return nil, fmt.Errorf("spot fill %s has side %q, which this version of the SDK doesn't know", fill.FillID, fill.UnknownSide)
+		}
+	}
+	for market, quantity := range checkpoint.SpotPositions {
+		state.SpotPositions[market] = quantity
+	}
+	for _, fill := range state.Fills {
+		change := fill.Size
+		if fill.Side == models.Ask {
+			change = change.Neg()
+		}
+		state.SpotPositions[fill.Market] = state.SpotPositions[fill.Market].Add(change)
+	}
+
+	report.Discrepancies = append(report.Discrepancies, diffOrders(ProductSpot, checkpoint.SpotOrders, state.SpotOrders, state.Fills)...)
+	report.Discrepancies = append(report.Discrepancies, diffOrders(ProductPerps, checkpoint.PerpsOrders, state.PerpsOrders, state.PerpsFills)...)
+	report.Discrepancies = append(report.Discrepancies, diffPositions(checkpoint.Positions, state.Positions)...)
+	var unchecked []models.Symbol
+	if _, ok := checkpoint.Balances[PerpsMarginSymbol]; ok && tradedPerps(checkpoint, state) {
+		unchecked = append(unchecked, PerpsMarginSymbol)
+	}
+	report.UncheckedBalances = unchecked
+	report.Discrepancies = append(report.Discrepancies, diffBalances(checkpoint.Balances, state.Balances, state.Fills, unchecked)...)
+	return report, nil
+}
+
+// readBalances reads the total balance of every symbol into balances
+func readBalances(client *ApiClient, symbols []models.Symbol, balances map[models.Symbol]decimal.Decimal) error {
+	read := map[models.Symbol]bool{}
+	for _, symbol := range symbols {
+		if read[symbol] {
+			continue
+		}
+		read[symbol] = true
+		res, err := client.GetBalance(models.GetBalanceReq{Symbol: symbol})
+		if err != nil {
+			return err
+		}
+		total, err := decimal.NewFromString(res.Result.TotalBalance)
+		if err != nil {
+			return fmt.Errorf("invalid balance of %s: %w", symbol, err)
+		}
+		balances[symbol] = total
+	}
+	return nil
+}
+
+// sameFills reports whether two listings contain the same fills
+func sameFills(a, b []*models.ApiFill) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	ids := make(map[models.FillID]int, len(a))
+	for _, fill := range a {
+		ids[fill.FillID]++
+	}
+	for _, fill := range b {
+		if ids[fill.FillID] == 0 {
+			return false
+		}
+		ids[fill.FillID]--
+	}
+	return true
+}
+
+// tradedPerps reports whether perps were filled since the checkpoint or a perps position was open at either end
+func tradedPerps(checkpoint *Checkpoint, state *AccountState) bool {
+	if len(state.PerpsFills) > 0 {
+		return true
+	}
+	for _, positions := range []map[models.Market]decimal.Decimal{checkpoint.Positions, state.Positions} {
+		for _, quantity := range positions {
+			if !quantity.IsZero() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func diffOrders(product Product, expected, actual map[models.OrderID]*models.ApiOrder, fills []*models.ApiFill) []Discrepancy {
+	filled := map[models.OrderID]bool{}
+	for _, fill := range fills {
+		filled[fill.OrderID] = true
+	}
+
+	var discrepancies []Discrepancy
+	for id, order := range actual {
+		if _, ok := expected[id]; !ok {
+			discrepancies = append(discrepancies, Discrepancy{Kind: UnexpectedOrder, Product: product, OrderID: id, Market: order.Market})
+		}
+	}
+	for id, order := range expected {
+		if _, ok := actual[id]; !ok && !filled[id] {
+			discrepancies = append(discrepancies, Discrepancy{Kind: MissingOrder, Product: product, OrderID: id, Market: order.Market})
+		}
+	}
+	return discrepancies
+}
+
+func diffPositions(expected, actual map[models.Market]decimal.Decimal) []Discrepancy {
+	var discrepancies []Discrepancy
+	markets := map[models.Market]bool{}
+	for market := range expected {
+		markets[market] = true
+	}
+	for market := range actual {
+		markets[market] = true
+	}
+	for market := range markets {
+		if !expected[market].Equal(actual[market]) {
+			discrepancies = append(discrepancies, Discrepancy{Kind: PositionChanged, Product: ProductPerps, Market: market, Expected: expected[market], Actual: actual[market]})
+		}
+	}
+	return discrepancies
+}
+
+// diffBalances compares balances with the checkpoint balances plus the base and quote changes of the spot fills
+// since, skipping the unchecked symbols. Fees are assumed to be charged in the quote currency.
+func diffBalances(checkpoint, actual map[models.Symbol]decimal.Decimal, fills []*models.ApiFill, unchecked []models.Symbol) []Discrepancy {
+	expected := make(map[models.Symbol]decimal.Decimal, len(checkpoint))
+	for symbol, balance := range checkpoint {
+		expected[symbol] = balance
+	}
+	for _, fill := range fills {
+		base, quote, ok := strings.Cut(string(fill.Market), "-")
+		if !ok {
+			continue
+		}
+		fee := netFee(fill)
+		if fill.Side == models.Bid {
+			expected[models.Symbol(base)] = expected[models.Symbol(base)].Add(fill.Size)
+			expected[models.Symbol(quote)] = expected[models.Symbol(quote)].Sub(fill.Cost).Sub(fee)
+		} else {
+			expected[models.Symbol(base)] = expected[models.Symbol(base)].Sub(fill.Size)
+			expected[models.Symbol(quote)] = expected[models.Symbol(quote)].Add(fill.Cost).Sub(fee)
+		}
+	}
+
+	var discrepancies []Discrepancy
+	for symbol := range checkpoint {
+		if slices.Contains(unchecked, symbol) {
+			continue
+		}
+		if !expected[symbol].Equal(actual[symbol]) {
+			discrepancies = append(discrepancies, Discrepancy{Kind: BalanceMismatch, Symbol: symbol, Expected: expected[symbol], Actual: actual[symbol]})
+		}
+	}
+	return discrepancies
+}
@@ -0,0 +1,227 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+// fakeAccount serves an account with no open orders or positions, and the given fills
+func fakeAccount(ex *fakeExchange, spotFills, perpsFills []*models.ApiFill) {
+	empty := func(fakeRequest) (int, any) { return http.StatusOK, models.V1PageRes[models.ApiOrder]{} }
+	ex.handle("GET "+models.V1SpotOrdersPath, empty)
+	ex.handle("GET "+models.V1PerpsOrdersPath, empty)
+	ex.handle("GET "+models.V1PerpsPositionsPath, func(fakeRequest) (int, any) { return ok([]models.ApiPosition{}) })
+	ex.handle("GET "+models.V1SpotFillsPath, func(fakeRequest) (int, any) {
+		return http.StatusOK, models.V1PageRes[models.ApiFill]{Result: spotFills}
+	})
+	ex.handle("GET "+models.V1PerpsFillsPath, func(fakeRequest) (int, any) {
+		return http.StatusOK, models.V1PageRes[models.ApiFill]{Result: perpsFills}
+	})
+}
+
+func TestReconcileMissingOrders(t *testing.T) {
+	tests := []struct {
+		name        string
+		spotFills   []*models.ApiFill
+		perpsFills  []*models.ApiFill
+		wantMissing []models.OrderID
+	}{
+		{name: "no fills", wantMissing: []models.OrderID{"s1", "p1"}},
+		{name: "spot order filled", spotFills: []*models.ApiFill{{OrderID: "s1"}}, wantMissing: []models.OrderID{"p1"}},
+		{name: "perps order filled", perpsFills: []*models.ApiFill{{OrderID: "p1"}}, wantMissing: []models.OrderID{"s1"}},
+		{
+			name:       "both filled",
+			spotFills:  []*models.ApiFill{{OrderID: "s1"}},
+			perpsFills: []*models.ApiFill{{OrderID: "p1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newFakeExchange(t)
+			fakeAccount(ex, tt.spotFills, tt.perpsFills)
+			checkpoint := &Checkpoint{
+				Time:        time.Now().Add(-time.Hour),
+				SpotOrders:  map[models.OrderID]*models.ApiOrder{"s1": {OrderID: "s1", Market: "AVAX-USDC"}},
+				PerpsOrders: map[models.OrderID]*models.ApiOrder{"p1": {OrderID: "p1", Market: "BTC-USD.P"}},
+			}
+
+			report, err := ex.client().Reconcile(context.Background(), checkpoint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			missing := map[models.OrderID]bool{}
+			for _, d := range report.Discrepancies {
+				if d.Kind == MissingOrder {
+					missing[d.OrderID] = true
+				}
+			}
+			if len(missing) != len(tt.wantMissing) {
+				t.Fatalf("missing orders %v, want %v", missing, tt.wantMissing)
+			}
+			for _, id := range tt.wantMissing {
+				if !missing[id] {
+					t.Fatalf("missing orders %v, want %v", missing, tt.wantMissing)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileApplyTo(t *testing.T) {
+	ex := newFakeExchange(t)
+	fakeAccount(ex, []*models.ApiFill{
+		{OrderID: "s1", Market: "AVAX-USDC", Side: models.Bid, Size: decimal.NewFromInt(3)},
+		{OrderID: "s2", Market: "AVAX-USDC", Side: models.Ask, Size: decimal.NewFromInt(1)},
+	}, nil)
+	ex.handle("GET "+models.V1SpotOrdersPath, func(fakeRequest) (int, any) {
+		return http.StatusOK, models.V1PageRes[models.ApiOrder]{Result: []*models.ApiOrder{
+			{OrderID: "s3", Market: "AVAX-USDC", OrderQuantity: decimal.NewFromInt(2), State: models.Open},
+		}}
+	})
+	checkpoint := &Checkpoint{
+		Time:          time.Now().Add(-time.Hour),
+		SpotPositions: map[models.Market]decimal.Decimal{"AVAX-USDC": decimal.NewFromInt(10)},
+	}
+
+	report, err := ex.client().Reconcile(context.Background(), checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	positions, orders := NewPositionTracker(), NewOrderTracker()
+	orders.Track(ProductSpot, &models.ApiOrder{OrderID: "stale", State: models.Open})
+	report.State.ApplyTo(positions, orders)
+
+	if position := positions.Position("AVAX-USDC"); !position.Equal(decimal.NewFromInt(12)) {
+		t.Fatalf("spot position %s, want 12", position)
+	}
+	if _, ok := orders.Order("stale"); ok {
+		t.Fatal("order from before the reconcile still tracked")
+	}
+	if _, ok := orders.Order("s3"); !ok {
+		t.Fatal("open order not tracked")
+	}
+	orders.OnFill(&models.ApiFill{OrderID: "s3", Size: decimal.NewFromInt(2)})
+	if _, ok := orders.Order("s3"); ok {
+		t.Fatal("completely filled order still tracked")
+	}
+	if saved := report.State.Checkpoint().SpotPositions["AVAX-USDC"]; !saved.Equal(decimal.NewFromInt(12)) {
+		t.Fatalf("checkpoint spot position %s, want 12", saved)
+	}
+}
+
+func TestReconcileLeavesPerpsMarginUnchecked(t *testing.T) {
+	tests := []struct {
+		name          string
+		perpsFills    []*models.ApiFill
+		wantUnchecked bool
+	}{
+		{name: "no perps fills"},
+		{name: "perps fills", perpsFills: []*models.ApiFill{{OrderID: "p1", Market: "BTC-USD.P"}},
+			wantUnchecked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newFakeExchange(t)
+			fakeAccount(ex, []*models.ApiFill{{Market: "AVAX-USDC", Side: models.Bid, Size: decimal.NewFromInt(1),
+				Cost: decimal.NewFromInt(40)}}, tt.perpsFills)
+			// The perps realized PnL moves the USDC balance by more than the spot fill explains
+			balances := map[models.Symbol]string{"AVAX": "11", PerpsMarginSymbol: "965"}
+			ex.handle("POST "+models.V0GetBalancePath, func(req fakeRequest) (int, any) {
+				var get models.GetBalanceReq
+				req.decode(t, &get)
+				return ok(models.V0GetBalanceRes{Symbol: get.Symbol, TotalBalance: balances[get.Symbol]})
+			})
+			checkpoint := &Checkpoint{Time: time.Now().Add(-time.Hour),
+				Balances: map[models.Symbol]decimal.Decimal{"AVAX": decimal.NewFromInt(10), PerpsMarginSymbol: decimal.NewFromInt(1000)}}
+
+			report, err := ex.client().Reconcile(context.Background(), checkpoint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var mismatched []models.Symbol
+			for _, d := range report.Discrepancies {
+				if d.Kind == BalanceMismatch {
+					mismatched = append(mismatched, d.Symbol)
+				}
+			}
+			if tt.wantUnchecked {
+				if len(mismatched) != 0 || len(report.UncheckedBalances) != 1 || report.UncheckedBalances[0] != PerpsMarginSymbol {
+					t.Fatalf("mismatched %v, unchecked %v, want only %s unchecked", mismatched, report.UncheckedBalances, PerpsMarginSymbol)
+				}
+				return
+			}
+			if len(mismatched) != 1 || mismatched[0] != PerpsMarginSymbol || len(report.UncheckedBalances) != 0 {
+				t.Fatalf("mismatched %v, unchecked %v, want %s mismatched", mismatched, report.UncheckedBalances, PerpsMarginSymbol)
+			}
+		})
+	}
+}
+
+func TestReconcileCountsEachSpotFillOnce(t *testing.T) {
+	ex := newFakeExchange(t)
+	// The fill lands while the first reconcile runs, so its balances include it and the next one lists it again
+	fill := &models.ApiFill{FillID: "f1", CreatedAt: time.Now(), Market: "AVAX-USDC", Side: models.Bid,
+		Size: decimal.NewFromInt(1), Cost: decimal.NewFromInt(40)}
+	fakeAccount(ex, []*models.ApiFill{fill}, nil)
+	balances := map[models.Symbol]string{"AVAX": "11", "USDC": "960"}
+	ex.handle("POST "+models.V0GetBalancePath, func(req fakeRequest) (int, any) {
+		var get models.GetBalanceReq
+		req.decode(t, &get)
+		return ok(models.V0GetBalanceRes{Symbol: get.Symbol, TotalBalance: balances[get.Symbol]})
+	})
+	checkpoint := &Checkpoint{Time: time.Now().Add(-time.Hour),
+		Balances:      map[models.Symbol]decimal.Decimal{"AVAX": decimal.NewFromInt(10), "USDC": decimal.NewFromInt(1000)},
+		SpotPositions: map[models.Market]decimal.Decimal{"AVAX-USDC": decimal.NewFromInt(10)}}
+
+	for run := 1; run <= 2; run++ {
+		report, err := ex.client().Reconcile(context.Background(), checkpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Discrepancies) != 0 {
+			t.Fatalf("run %d: discrepancies %v", run, report.Discrepancies)
+		}
+		if position := report.State.SpotPositions["AVAX-USDC"]; !position.Equal(decimal.NewFromInt(11)) {
+			t.Fatalf("run %d: spot position %s, want 11", run, position)
+		}
+		checkpoint = report.State.Checkpoint()
+	}
+}
+
+func TestReconcileRereadsBalancesWhileFillsArrive(t *testing.T) {
+	ex := newFakeExchange(t)
+	fakeAccount(ex, nil, nil)
+	first := &models.ApiFill{FillID: "f1", Market: "AVAX-USDC", Side: models.Bid, Size: decimal.NewFromInt(1), Cost: decimal.NewFromInt(40)}
+	second := &models.ApiFill{FillID: "f2", Market: "AVAX-USDC", Side: models.Bid, Size: decimal.NewFromInt(1), Cost: decimal.NewFromInt(40)}
+	listed := 0
+	ex.handle("GET "+models.V1SpotFillsPath, func(fakeRequest) (int, any) {
+		listed++
+		// The second fill lands between the first listing and the balance read
+		fills := []*models.ApiFill{first}
+		if listed > 1 {
+			fills = append(fills, second)
+		}
+		return http.StatusOK, models.V1PageRes[models.ApiFill]{Result: fills}
+	})
+	balances := map[models.Symbol]string{"AVAX": "12", "USDC": "920"}
+	ex.handle("POST "+models.V0GetBalancePath, func(req fakeRequest) (int, any) {
+		var get models.GetBalanceReq
+		req.decode(t, &get)
+		return ok(models.V0GetBalanceRes{Symbol: get.Symbol, TotalBalance: balances[get.Symbol]})
+	})
+	checkpoint := &Checkpoint{Time: time.Now().Add(-time.Hour),
+		Balances: map[models.Symbol]decimal.Decimal{"AVAX": decimal.NewFromInt(10), "USDC": decimal.NewFromInt(1000)}}
+
+	report, err := ex.client().Reconcile(context.Background(), checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.State.Fills) != 2 || len(report.Discrepancies) != 0 {
+		t.Fatalf("fills %d, discrepancies %v, want both fills counted and no discrepancies", len(report.State.Fills), report.Discrepancies)
+	}
+}
@@ -0,0 +1,566 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+// RiskLimits configures the pre-trade checks of a RiskEngine. Zero values disable a check.
+type RiskLimits struct {
+	// Largest price times size of a single order, in quote currency
+	MaxOrderNotional decimal.Decimal
+
+	// Largest absolute position per market that an order may result in, assuming it and every open order on the
+	// same side fill completely. Spot positions are the net base quantity filled since the engine was created.
+	MaxPosition map[models.Market]decimal.Decimal
+
+	// Most orders that may be open at once
+	MaxOpenOrders int
+
+	// Largest relative distance of a limit price from the reference price, e.g. 0.05 for 5%. The reference is the
+	// mark price for markets that have one, otherwise the middle of the top of book. Orders that need a reference
+	// price for a check that is enabled are rejected while there is none.
+	PriceCollar decimal.Decimal
+
+	// Largest loss from realized PnL and fees since midnight UTC, as a positive amount. Once reached only reduce-only
+	// orders are allowed.
+	DailyLossLimit decimal.Decimal
+
+	// Largest Size and QuoteSize of a single order, to catch mistyped quantities
+	MaxOrderSize      decimal.Decimal
+	MaxOrderQuoteSize decimal.Decimal
+}
+
+// RiskCheck names the check that rejected an order
+type RiskCheck string
+
+const (
+	RiskOrderNotional RiskCheck = "order_notional"
+	RiskPosition      RiskCheck = "position"
+	RiskOpenOrders    RiskCheck = "open_orders"
+	RiskPriceCollar   RiskCheck = "price_collar"
+	RiskDailyLoss     RiskCheck = "daily_loss"
+	RiskOrderSize     RiskCheck = "order_size"
+	RiskQuoteSize     RiskCheck = "quote_size"
+
+	// No mark price or top of book has been seen for the market, so a check that needs one can't be made
+	RiskReferencePrice RiskCheck = "reference_price"
+)
+
+var ErrRiskLimit = errors.New("risk limit exceeded")
+
+// RiskViolation is returned for an order that fails a pre-trade check. It matches ErrRiskLimit with errors.Is.
+type RiskViolation struct {
+	Check  RiskCheck
+	Market models.Market
+	Limit  decimal.Decimal
+	Value  decimal.Decimal
+}
+
+func (v *RiskViolation) Error() string {
+	if v.Check == RiskReferencePrice {
+		return fmt.Sprintf("risk limit exceeded: no reference price for %s", v.Market)
+	}
+	return fmt.Sprintf("risk limit exceeded: %s on %s is %s, limit %s", v.Check, v.Market, v.Value, v.Limit)
+}
+
+func (v *RiskViolation) Is(target error) bool {
+	return target == ErrRiskLimit
+}
+
+// RiskEngine checks every order the client sends against RiskLimits before it leaves the process. The limits apply
+// to each account on its own: the client's main account, each subaccount and each ClientPool account has its own
+// positions, open orders, daily loss and reservations, kept in a RiskAccount. Market data is shared. The engine is
+// fed through its On* methods, e.g. from a Dispatcher with Attach, and Sync.
+type RiskEngine struct {
+	client *ApiClient
+
+	mu         sync.Mutex
+	limits     RiskLimits
+	books      map[models.Market]*models.ApiBookSnapshot
+	markPrices map[models.Market]decimal.Decimal
+	accounts   map[KillSwitchAccount]*RiskAccount
+}
+
+// RiskAccount is the state a RiskEngine keeps for one account. Its trackers are fed by the engine and can be read,
+// but shouldn't also be attached to a dispatcher, which would count every fill twice.
+type RiskAccount struct {
+	engine  *RiskEngine
+	account KillSwitchAccount
+
+	// Perps positions from the positions channel and Sync, and the net base quantity bought on each spot market
+	// since the account was first seen, from spot fills
+	positions *PositionTracker
+
+	// Open orders counted towards MaxOpenOrders and MaxPosition. Market and immediate or cancel orders aren't
+	// tracked.
+	orders *OrderTracker
+
+	// Guarded by engine.mu
+	lossDay  time.Time
+	dailyPnl decimal.Decimal
+
+	// Capacity held by orders that passed the checks and are being sent, guarded by engine.mu
+	reservedOrders    int
+	reservedPositions map[models.Market]decimal.Decimal
+}
+
+// riskReservation is the capacity reserved by check for orders being sent
+type riskReservation struct {
+	orders    int
+	positions map[models.Market]decimal.Decimal
+}
+
+// NewRiskEngine adds a risk engine to the client's interceptor chain. It applies to the client and views created
+// from it afterwards, including ForSubaccount views and the clients of a ClientPool built from it, each of which is
+// checked against the limits separately.
+func (c *ApiClient) NewRiskEngine(limits RiskLimits) *RiskEngine {
+	r := &RiskEngine{
+		client:     c,
+		limits:     limits,
+		books:      map[models.Market]*models.ApiBookSnapshot{},
+		markPrices: map[models.Market]decimal.Decimal{},
+		accounts:   map[KillSwitchAccount]*RiskAccount{},
+	}
+	c.Use(r.intercept)
+	return r
+}
+
+// SetLimits replaces the limits
+func (r *RiskEngine) SetLimits(limits RiskLimits) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limits = limits
+}
+
+// Account returns the state kept for the account client acts on, creating it if it hasn't been seen yet
+func (r *RiskEngine) Account(client *ApiClient) *RiskAccount {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.account(client.killSwitchAccount())
+}
+
+// account is called with r.mu held
+func (r *RiskEngine) account(key KillSwitchAccount) *RiskAccount {
+	a, ok := r.accounts[key]
+	if !ok {
+		a = &RiskAccount{
+			engine:            r,
+			account:           key,
+			positions:         NewPositionTracker(),
+			orders:            NewOrderTracker(),
+			reservedPositions: map[models.Market]decimal.Decimal{},
+		}
+		r.accounts[key] = a
+	}
+	return a
+}
+
+// Positions returns the tracker of the account's positions
+func (a *RiskAccount) Positions() *PositionTracker {
+	return a.positions
+}
+
+// Orders returns the tracker of the account's open orders
+func (a *RiskAccount) Orders() *OrderTracker {
+	return a.orders
+}
+
+func (r *RiskEngine) intercept(call *Call, next Handler) error {
+	key := KillSwitchAccount{Account: call.Account, Subaccount: call.Subaccount}
+	if call.Method == "DELETE" {
+		err := next(call)
+		if err == nil {
+			if res, ok := call.GenericResponse(); ok && res.IsSuccess() {
+				r.onCancel(key, call)
+			}
+		}
+		return err
+	}
+	if call.Method != "POST" || !isOrderPath(call.Path) {
+		return next(call)
+	}
+
+	var orders []*models.AddOrderReq
+	switch req := call.Request.(type) {
+	case models.AddOrderReq:
+		orders = append(orders, &req)
+	case models.BatchAddOrderReq:
+		orders = req.Orders
+	}
+	r.mu.Lock()
+	account := r.account(key)
+	reservation, err := account.reserve(orders)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = next(call)
+
+	// Accepted orders are tracked before the reservation is released, so the capacity is never free in between
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	account.release(reservation)
+	if err != nil {
+		return err
+	}
+	product := pathProduct(call.Path)
+	switch res := call.Response.(type) {
+	case *models.GenericResponse[models.ApiOrder]:
+		if res.Success {
+			account.addOpenOrder(product, &res.Result)
+		}
+	case *models.GenericResponse[models.BatchAddOrderRes]:
+		if res.Success {
+			for _, order := range res.Result.AddedOrders {
+				account.addOpenOrder(product, order)
+			}
+		}
+	}
+	return nil
+}
+
+// pathProduct returns the product an order path belongs to
+func pathProduct(path string) Product {
+	if strings.HasPrefix(path, "/v1/perps/") {
+		return ProductPerps
+	}
+	return ProductSpot
+}
+
+// onCancel stops counting the orders cancelled by a successful DELETE call on account
+func (r *RiskEngine) onCancel(key KillSwitchAccount, call *Call) {
+	path, query, _ := strings.Cut(call.Path, "?")
+	product := pathProduct(path)
+
+	r.mu.Lock()
+	orders := r.account(key).orders
+	r.mu.Unlock()
+
+	switch path {
+	case models.V1SpotOrdersPath, models.V1PerpsOrdersPath:
+		// Cancel all, optionally on one market
+		values, _ := url.ParseQuery(query)
+		market := models.Market(values.Get("market"))
+		for _, order := range orders.Orders(product) {
+			if market == "" || order.Market == market {
+				orders.Remove(order.OrderID)
+			}
+		}
+	case models.V1SpotBatchOrdersPath, models.V1PerpsBatchOrdersPath:
+		if res, ok := call.Response.(*models.GenericResponse[models.BatchCancelRes]); ok {
+			for _, order := range res.Result.SuccessfulCancels {
+				orders.Remove(order.OrderID)
+			}
+		}
+	default:
+		id, ok := strings.CutPrefix(path, models.V1SpotOrdersPath+"/")
+		if product == ProductPerps {
+			id, ok = strings.CutPrefix(path, models.V1PerpsOrdersPath+"/")
+		}
+		if !ok {
+			return
+		}
+		if unescaped, err := url.PathUnescape(id); err == nil {
+			id = unescaped
+		}
+		clientOrderID, byClientID := strings.CutPrefix(id, models.V1SpotClientOrderIDPrefix)
+		for _, order := range orders.Orders(product) {
+			if order.OrderID == models.OrderID(id) || byClientID && order.ClientOrderID == models.OrderID(clientOrderID) {
+				orders.Remove(order.OrderID)
+			}
+		}
+	}
+}
+
+// addOpenOrder counts an accepted order until it is filled or cancelled. Market and immediate or cancel orders
+// never rest on the book, so they aren't counted.
+func (a *RiskAccount) addOpenOrder(product Product, order *models.ApiOrder) {
+	if order.Type == models.OrderTypeMarket || order.TimeInForce == models.OrderTimeInForceImmediateOrCancel {
+		return
+	}
+	if !order.OrderQuantity.Sub(order.FilledQuantity).IsPositive() {
+		return
+	}
+	a.orders.Track(product, order)
+}
+
+// Check evaluates an order for the engine's client against the limits without sending it
+func (r *RiskEngine) Check(req models.AddOrderReq) error {
+	return r.Account(r.client).Check(req)
+}
+
+// Check evaluates an order for the account against the limits without sending it
+func (a *RiskAccount) Check(req models.AddOrderReq) error {
+	a.engine.mu.Lock()
+	defer a.engine.mu.Unlock()
+	_, err := a.check([]*models.AddOrderReq{&req})
+	return err
+}
+
+// reserve checks orders and holds their open order and position capacity until they are released, so orders
+// checked concurrently can't together exceed a limit. It is called with engine.mu held.
+func (a *RiskAccount) reserve(orders []*models.AddOrderReq) (riskReservation, error) {
+	reservation, err := a.check(orders)
+	if err != nil {
+		return reservation, err
+	}
+	a.reservedOrders += reservation.orders
+	for market, change := range reservation.positions {
+		a.reservedPositions[market] = a.reservedPositions[market].Add(change)
+	}
+	return reservation, nil
+}
+
+// release returns the capacity held by a reservation, called with engine.mu held
+func (a *RiskAccount) release(reservation riskReservation) {
+	a.reservedOrders -= reservation.orders
+	for market, change := range reservation.positions {
+		a.reservedPositions[market] = a.reservedPositions[market].Sub(change)
+	}
+}
+
+// check evaluates orders, called with engine.mu held. It returns the capacity the orders need.
+func (a *RiskAccount) check(orders []*models.AddOrderReq) (riskReservation, error) {
+	reservation := riskReservation{positions: map[models.Market]decimal.Decimal{}}
+	openOrders := a.orders.Count()
+	for _, req := range orders {
+		if err := a.checkOrder(req, openOrders+a.reservedOrders+reservation.orders, reservation.positions); err != nil {
+			var violation *RiskViolation
+			if errors.As(err, &violation) {
+				a.engine.client.getMetrics().IncRiskRejections(violation.Check)
+			}
+			return riskReservation{}, err
+		}
+		reservation.orders++
+	}
+	return reservation, nil
+}
+
+// checkOrder is called with engine.mu held. positions accumulates the changes of earlier orders in the same batch.
+func (a *RiskAccount) checkOrder(req *models.AddOrderReq, openOrders int, positions map[models.Market]decimal.Decimal) error {
+	limits := a.engine.limits
+	violation := func(check RiskCheck, limit, value decimal.Decimal) error {
+		return &RiskViolation{Check: check, Market: req.Market, Limit: limit, Value: value}
+	}
+
+	if limits.MaxOrderSize.IsPositive() && req.Size.GreaterThan(limits.MaxOrderSize) {
+		return violation(RiskOrderSize, limits.MaxOrderSize, req.Size)
+	}
+	if limits.MaxOrderQuoteSize.IsPositive() && req.QuoteSize.GreaterThan(limits.MaxOrderQuoteSize) {
+		return violation(RiskQuoteSize, limits.MaxOrderQuoteSize, req.QuoteSize)
+	}
+	if limits.MaxOpenOrders > 0 && openOrders >= limits.MaxOpenOrders {
+		return violation(RiskOpenOrders, decimal.NewFromInt(int64(limits.MaxOpenOrders)), decimal.NewFromInt(int64(openOrders+1)))
+	}
+
+	reference, hasReference := a.engine.referencePrice(req.Market)
+	noReference := &RiskViolation{Check: RiskReferencePrice, Market: req.Market}
+	if limits.PriceCollar.IsPositive() && req.Type == models.OrderTypeLimit {
+		if !hasReference {
+			return noReference
+		}
+		distance := req.Price.Sub(reference).Abs().Div(reference)
+		if distance.GreaterThan(limits.PriceCollar) {
+			return violation(RiskPriceCollar, limits.PriceCollar, distance)
+		}
+	}
+
+	if limits.MaxOrderNotional.IsPositive() {
+		price := req.Price
+		if req.Type == models.OrderTypeMarket || price.IsZero() {
+			price = reference
+		}
+		notional := req.QuoteSize
+		if notional.IsZero() {
+			if !price.IsPositive() {
+				return noReference
+			}
+			notional = req.Size.Mul(price)
+		}
+		if notional.GreaterThan(limits.MaxOrderNotional) {
+			return violation(RiskOrderNotional, limits.MaxOrderNotional, notional)
+		}
+	}
+
+	if req.ReduceOnly {
+		return nil
+	}
+
+	a.resetDailyLoss(time.Now())
+	if limits.DailyLossLimit.IsPositive() && a.dailyPnl.Neg().GreaterThanOrEqual(limits.DailyLossLimit) {
+		return violation(RiskDailyLoss, limits.DailyLossLimit, a.dailyPnl.Neg())
+	}
+
+	if maxPosition, ok := limits.MaxPosition[req.Market]; ok {
+		change := req.Size
+		if change.IsZero() {
+			if !hasReference {
+				return noReference
+			}
+			change = req.QuoteSize.Div(reference)
+		}
+		if req.Side == models.Ask {
+			change = change.Neg()
+		}
+		// The worst case is that every open order on the same side fills too
+		resting := a.orders.OpenQuantity(req.Market, req.Side)
+		if req.Side == models.Ask {
+			resting = resting.Neg()
+		}
+		after := a.positions.Position(req.Market).Add(a.reservedPositions[req.Market]).
+			Add(positions[req.Market]).Add(resting).Add(change)
+		if after.Abs().GreaterThan(maxPosition) {
+			return violation(RiskPosition, maxPosition, after.Abs())
+		}
+		positions[req.Market] = positions[req.Market].Add(change)
+	}
+	return nil
+}
+
+// referencePrice is called with r.mu held
+func (r *RiskEngine) referencePrice(market models.Market) (decimal.Decimal, bool) {
+	if mark, ok := r.markPrices[market]; ok && mark.IsPositive() {
+		return mark, true
+	}
+	book := r.books[market]
+	if book == nil || len(book.Bids) == 0 || len(book.Asks) == 0 {
+		return decimal.Zero, false
+	}
+	// Like the mark price, a mid that isn't positive is no reference: the checks divide by it
+	mid := book.Bids[0].Price.Add(book.Asks[0].Price).Div(decimal.NewFromInt(2))
+	return mid, mid.IsPositive()
+}
+
+// resetDailyLoss is called with engine.mu held
+func (a *RiskAccount) resetDailyLoss(now time.Time) {
+	day := now.UTC().Truncate(24 * time.Hour)
+	if !day.Equal(a.lossDay) {
+		a.lossDay = day
+		a.dailyPnl = decimal.Zero
+	}
+}
+
+func (r *RiskEngine) OnBook(book *models.ApiBookSnapshot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.books[book.Market] = book
+}
+
+func (r *RiskEngine) OnMarkPrice(price *models.GetMarkPriceRes) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.markPrices[price.Market] = price.MarkPrice
+}
+
+// OnPosition sets a perps position of the engine's client
+func (r *RiskEngine) OnPosition(position *models.ApiPosition) {
+	r.Account(r.client).OnPosition(position)
+}
+
+// OnFill is RiskAccount.OnFill for the engine's client
+func (r *RiskEngine) OnFill(fill *models.ApiFill) {
+	r.Account(r.client).OnFill(fill)
+}
+
+// OnSpotFill is RiskAccount.OnSpotFill for the engine's client
+func (r *RiskEngine) OnSpotFill(fill *models.ApiFill) {
+	r.Account(r.client).OnSpotFill(fill)
+}
+
+// HandleEvent is RiskAccount.HandleEvent for the engine's client
+func (r *RiskEngine) HandleEvent(event Event) {
+	r.Account(r.client).HandleEvent(event)
+}
+
+// Attach feeds the engine from every market on d's top of book, mark price, position and fill channels, applying
+// the positions and fills to the account d's client acts on. The channels still need to be subscribed.
+func (r *RiskEngine) Attach(d *Dispatcher) {
+	r.Account(d.client).Attach(d)
+}
+
+// Sync replaces the open orders and positions of the account client acts on with its current state. Between syncs
+// orders stop being counted as open when they are cancelled through the client or completely filled according to
+// OnFill.
+func (r *RiskEngine) Sync(ctx context.Context, client *ApiClient) error {
+	return r.Account(client).Sync(ctx, client)
+}
+
+// OnPosition sets a perps position of the account
+func (a *RiskAccount) OnPosition(position *models.ApiPosition) {
+	a.positions.OnPosition(position)
+}
+
+// OnFill adds the fill's realized PnL, less fees and plus rebates, to the account's daily PnL, and stops counting the
+// order as open once it is completely filled. Perps positions are taken from OnPosition; pass spot fills to
+// OnSpotFill instead, so they also move the spot position.
+func (a *RiskAccount) OnFill(fill *models.ApiFill) {
+	a.orders.OnFill(fill)
+
+	a.engine.mu.Lock()
+	defer a.engine.mu.Unlock()
+	a.resetDailyLoss(time.Now())
+	if fill.CreatedAt.UTC().Before(a.lossDay) {
+		return
+	}
+	if fill.RealizedPNL != nil {
+		a.dailyPnl = a.dailyPnl.Add(*fill.RealizedPNL)
+	}
+	a.dailyPnl = a.dailyPnl.Sub(fill.Fee)
+	if fill.FeeRebate != nil {
+		a.dailyPnl = a.dailyPnl.Add(*fill.FeeRebate)
+	}
+}
+
+// OnSpotFill does what OnFill does and adds the fill to the account's position on its spot market
+func (a *RiskAccount) OnSpotFill(fill *models.ApiFill) {
+	a.positions.OnSpotFill(fill)
+	a.OnFill(fill)
+}
+
+// HandleEvent passes market data from a dispatcher event to the engine, and positions and fills to the account
+func (a *RiskAccount) HandleEvent(event Event) {
+	switch data := event.Data.(type) {
+	case *models.ApiBookSnapshot:
+		a.engine.OnBook(data)
+	case *models.GetMarkPriceRes:
+		a.engine.OnMarkPrice(data)
+	case *models.ApiPosition:
+		a.OnPosition(data)
+	case *models.ApiFill:
+		if event.Channel == FillsSpot() {
+			a.OnSpotFill(data)
+		} else {
+			a.OnFill(data)
+		}
+	}
+}
+
+// Attach feeds the account and the engine's market data from every market on d's top of book, mark price,
+// position and fill channels. The channels still need to be subscribed.
+func (a *RiskAccount) Attach(d *Dispatcher) {
+	latest := HandlerOptions{Policy: ConflateLatest}
+	d.Handle(TopOfBooksSpot(), "", latest, a.HandleEvent)
+	d.Handle(TopOfBooksPerps(), "", latest, a.HandleEvent)
+	d.Handle(PerpsMarkPrices(), "", latest, a.HandleEvent)
+	d.Handle(PerpsPositions(), "", latest, a.HandleEvent)
+	d.Handle(FillsSpot(), "", HandlerOptions{Policy: Block}, a.HandleEvent)
+	d.Handle(FillsPerps(), "", HandlerOptions{Policy: Block}, a.HandleEvent)
+}
+
+// Sync replaces the account's open orders and perps positions with its current state, read through client
+func (a *RiskAccount) Sync(ctx context.Context, client *ApiClient) error {
+	if err := a.orders.Sync(ctx, client); err != nil {
+		return err
+	}
+	return a.positions.Sync(ctx, client)
+}
@@ -0,0 +1,291 @@
+package apiclient
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+	"golang.org/x/time/rate"
+)
+
+func TestRiskEngineRequiresReferencePrice(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits RiskLimits
+		req    models.AddOrderReq
+		// Top of book, if there is one
+		bid, ask  string
+		wantCheck RiskCheck
+	}{
+		{
+			name:      "market order notional",
+			limits:    RiskLimits{MaxOrderNotional: decimal.NewFromInt(1000)},
+			req:       models.AddOrderReq{Market: "AVAX-USDC", Size: decimal.NewFromInt(1), Type: models.OrderTypeMarket},
+			wantCheck: RiskReferencePrice,
+		},
+		{
+			name:   "limit order notional uses its price",
+			limits: RiskLimits{MaxOrderNotional: decimal.NewFromInt(1000)},
+			req:    models.AddOrderReq{Market: "AVAX-USDC", Size: decimal.NewFromInt(1), Price: decimal.NewFromInt(40), Type: models.OrderTypeLimit},
+		},
+		{
+			name:      "price collar",
+			limits:    RiskLimits{PriceCollar: decimal.RequireFromString("0.05")},
+			req:       models.AddOrderReq{Market: "AVAX-USDC", Size: decimal.NewFromInt(1), Price: decimal.NewFromInt(40), Type: models.OrderTypeLimit},
+			wantCheck: RiskReferencePrice,
+		},
+		{
+			name:      "position from quote size",
+			limits:    RiskLimits{MaxPosition: map[models.Market]decimal.Decimal{"AVAX-USDC": decimal.NewFromInt(10)}},
+			req:       models.AddOrderReq{Market: "AVAX-USDC", QuoteSize: decimal.NewFromInt(100), Type: models.OrderTypeMarket},
+			wantCheck: RiskReferencePrice,
+		},
+		{
+			name:   "market order notional with a book",
+			limits: RiskLimits{MaxOrderNotional: decimal.NewFromInt(30)},
+			req:    models.AddOrderReq{Market: "AVAX-USDC", Size: decimal.NewFromInt(1), Type: models.OrderTypeMarket},
+			bid:    "39.9", ask: "40.1", wantCheck: RiskOrderNotional,
+		},
+		{
+			name:   "price collar with a book",
+			limits: RiskLimits{PriceCollar: decimal.RequireFromString("0.05")},
+			req:    models.AddOrderReq{Market: "AVAX-USDC", Size: decimal.NewFromInt(1), Price: decimal.NewFromInt(40), Type: models.OrderTypeLimit},
+			bid:    "39.9", ask: "40.1",
+		},
+		{
+			name:   "price collar with a zero priced book",
+			limits: RiskLimits{PriceCollar: decimal.RequireFromString("0.05")},
+			req:    models.AddOrderReq{Market: "AVAX-USDC", Size: decimal.NewFromInt(1), Price: decimal.NewFromInt(40), Type: models.OrderTypeLimit},
+			bid:    "0", ask: "0", wantCheck: RiskReferencePrice,
+		},
+		{
+			name:   "position from quote size with a zero priced book",
+			limits: RiskLimits{MaxPosition: map[models.Market]decimal.Decimal{"AVAX-USDC": decimal.NewFromInt(10)}},
+			req:    models.AddOrderReq{Market: "AVAX-USDC", QuoteSize: decimal.NewFromInt(100), Type: models.OrderTypeMarket},
+			bid:    "0", ask: "0", wantCheck: RiskReferencePrice,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			risk := NewApiClient("http://localhost").NewRiskEngine(tt.limits)
+			if tt.bid != "" {
+				risk.OnBook(book("AVAX-USDC", tt.bid, tt.ask))
+			}
+			err := risk.Check(tt.req)
+			var violation *RiskViolation
+			switch {
+			case tt.wantCheck == "" && err != nil:
+				t.Fatalf("rejected: %v", err)
+			case tt.wantCheck != "" && (!errors.As(err, &violation) || violation.Check != tt.wantCheck):
+				t.Fatalf("err = %v, want a %s violation", err, tt.wantCheck)
+			}
+		})
+	}
+}
+
+func TestRiskEngineFreesOpenOrders(t *testing.T) {
+	tests := []struct {
+		name string
+		free func(t *testing.T, ex *fakeExchange, client *ApiClient, risk *RiskEngine)
+	}{
+		{
+			name: "cancel one",
+			free: func(t *testing.T, ex *fakeExchange, client *ApiClient, risk *RiskEngine) {
+				ex.handle("DELETE "+models.V1SpotOrdersPath+"/o1", func(fakeRequest) (int, any) { return ok[any](nil) })
+				if _, err := client.CancelSpotOrder("o1"); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "cancel all",
+			free: func(t *testing.T, ex *fakeExchange, client *ApiClient, risk *RiskEngine) {
+				ex.handle("DELETE "+models.V1SpotOrdersPath, func(fakeRequest) (int, any) { return ok[any](nil) })
+				if err := client.CancelAllSpotOrdersOnMarket("AVAX-USDC"); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "complete fill",
+			free: func(t *testing.T, ex *fakeExchange, client *ApiClient, risk *RiskEngine) {
+				risk.OnFill(&models.ApiFill{OrderID: "o1", Size: decimal.NewFromInt(1)})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newFakeExchange(t)
+			acceptSpotOrders(t, ex)
+			client := ex.client()
+			risk := client.NewRiskEngine(RiskLimits{MaxOpenOrders: 1})
+			order := models.AddOrderReq{Market: "AVAX-USDC", Size: decimal.NewFromInt(1), Price: decimal.NewFromInt(40), Type: models.OrderTypeLimit}
+
+			if _, err := client.AddSpotOrder(order); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.AddSpotOrder(order); !errors.Is(err, ErrRiskLimit) {
+				t.Fatalf("second order: err = %v, want a risk limit", err)
+			}
+			tt.free(t, ex, client, risk)
+			if _, err := client.AddSpotOrder(order); err != nil {
+				t.Fatalf("order after the first was freed: %v", err)
+			}
+		})
+	}
+}
+
+func TestRiskEngineCountsSpotFillsAndOpenOrdersInPosition(t *testing.T) {
+	ex := newFakeExchange(t)
+	acceptSpotOrders(t, ex)
+	client := ex.client()
+	risk := client.NewRiskEngine(RiskLimits{MaxPosition: map[models.Market]decimal.Decimal{"AVAX-USDC": decimal.NewFromInt(3)}})
+	order := func(side models.BidAsk, size int64) models.AddOrderReq {
+		return models.AddOrderReq{Market: "AVAX-USDC", Side: side, Size: decimal.NewFromInt(size), Price: decimal.NewFromInt(40),
+			Type: models.OrderTypeLimit}
+	}
+	rejected := func(req models.AddOrderReq) bool {
+		var violation *RiskViolation
+		return errors.As(risk.Check(req), &violation) && violation.Check == RiskPosition
+	}
+
+	// A resting bid counts towards the long side only
+	if _, err := client.AddSpotOrder(order(models.Bid, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if !rejected(order(models.Bid, 2)) {
+		t.Fatal("bid accepted although it and the resting bid could go 4 long")
+	}
+	if rejected(order(models.Ask, 3)) {
+		t.Fatal("ask rejected because of the resting bid")
+	}
+
+	// Once the bid fills it is inventory rather than an open order
+	risk.HandleEvent(Event{Channel: FillsSpot(), Market: "AVAX-USDC", Data: &models.ApiFill{OrderID: "o1",
+		Market: "AVAX-USDC", Side: models.Bid, Size: decimal.NewFromInt(2)}})
+	if !rejected(order(models.Bid, 2)) {
+		t.Fatal("bid accepted although it and the filled bid make 4 long")
+	}
+	if rejected(order(models.Bid, 1)) {
+		t.Fatal("bid up to the limit rejected")
+	}
+	if !rejected(order(models.Ask, 6)) {
+		t.Fatal("ask accepted although it makes 4 short")
+	}
+
+	risk.OnSpotFill(&models.ApiFill{Market: "AVAX-USDC", Side: models.Ask, Size: decimal.NewFromInt(2)})
+	if rejected(order(models.Bid, 3)) {
+		t.Fatal("bid rejected after the inventory was sold")
+	}
+}
+
+func TestRiskEngineBatchCancelFreesOpenOrders(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("POST "+models.V1PerpsOrdersPath, func(req fakeRequest) (int, any) {
+		return ok(models.ApiOrder{OrderID: "p1", ClientOrderID: "c1", Market: "BTC-USD.P", OrderQuantity: decimal.NewFromInt(1), State: models.Open})
+	})
+	ex.handle("DELETE "+models.V1PerpsBatchOrdersPath, func(fakeRequest) (int, any) {
+		return ok(models.BatchCancelRes{SuccessfulCancels: []*models.ApiOrder{{OrderID: "p1", ClientOrderID: "c1"}}})
+	})
+	client := ex.client()
+	client.NewRiskEngine(RiskLimits{MaxOpenOrders: 1})
+	order := models.AddOrderReq{Market: "BTC-USD.P", Size: decimal.NewFromInt(1), Price: decimal.NewFromInt(90_000), Type: models.OrderTypeLimit}
+
+	if _, err := client.AddPerpsOrder(order); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CancelPerpsOrdersByClientId([]models.ClientOrderID{"c1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.AddPerpsOrder(order); err != nil {
+		t.Fatalf("order after batch cancel: %v", err)
+	}
+}
+
+func TestRiskEngineReservesCapacityWhileSending(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits RiskLimits
+	}{
+		{name: "open orders", limits: RiskLimits{MaxOpenOrders: 1}},
+		{name: "position", limits: RiskLimits{MaxPosition: map[models.Market]decimal.Decimal{"AVAX-USDC": decimal.NewFromInt(1)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newFakeExchange(t)
+			entered, release := make(chan struct{}), make(chan struct{})
+			ex.handle("POST "+models.V1SpotOrdersPath, func(req fakeRequest) (int, any) {
+				close(entered)
+				<-release
+				return ok(models.ApiOrder{OrderID: "o1", Market: "AVAX-USDC", OrderQuantity: decimal.NewFromInt(1), State: models.Open})
+			})
+			client := ex.client()
+			client.NewRiskEngine(tt.limits)
+			order := models.AddOrderReq{Market: "AVAX-USDC", Size: decimal.NewFromInt(1), Price: decimal.NewFromInt(40), Type: models.OrderTypeLimit}
+
+			first := make(chan error)
+			go func() {
+				_, err := client.AddSpotOrder(order)
+				first <- err
+			}()
+			<-entered
+			_, err := client.AddSpotOrder(order)
+			close(release)
+			if !errors.Is(err, ErrRiskLimit) {
+				t.Fatalf("concurrent order: err = %v, want a risk limit", err)
+			}
+			if err := <-first; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRiskEngineCountsRejectionsInMetrics(t *testing.T) {
+	metrics := NewPrometheusMetrics()
+	risk := NewApiClient("http://localhost").WithMetrics(metrics).NewRiskEngine(RiskLimits{MaxOrderSize: decimal.NewFromInt(1)})
+	if err := risk.Check(models.AddOrderReq{Market: "AVAX-USDC", Size: decimal.NewFromInt(2)}); !errors.Is(err, ErrRiskLimit) {
+		t.Fatalf("err = %v, want a risk limit", err)
+	}
+
+	var out strings.Builder
+	if err := metrics.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `enclave_risk_rejections_total{check="order_size"} 1`) {
+		t.Fatalf("rejection not counted:\n%s", out.String())
+	}
+}
+
+func TestRiskEngineKeepsAccountsApart(t *testing.T) {
+	ex := newFakeExchange(t)
+	acceptSpotOrders(t, ex)
+	client := ex.client()
+	risk := client.NewRiskEngine(RiskLimits{MaxOpenOrders: 1, MaxPosition: map[models.Market]decimal.Decimal{"AVAX-USDC": decimal.NewFromInt(3)}})
+	sub := client.ForSubaccount("sub-1")
+	pooled := NewClientPool(client, rate.Inf, 1).AddApiKey("account-1", "key-1", "secret")
+	order := models.AddOrderReq{Market: "AVAX-USDC", Side: models.Bid, Size: decimal.NewFromInt(2), Price: decimal.NewFromInt(40), Type: models.OrderTypeLimit}
+
+	for name, view := range map[string]*ApiClient{"main": client, "subaccount": sub, "pool": pooled} {
+		if _, err := view.AddSpotOrder(order); err != nil {
+			t.Fatalf("%s: first order rejected: %v", name, err)
+		}
+		if _, err := view.AddSpotOrder(order); !errors.Is(err, ErrRiskLimit) {
+			t.Fatalf("%s: second order err = %v, want a risk limit", name, err)
+		}
+	}
+
+	// A fill on the subaccount moves only its position
+	risk.Account(sub).OnSpotFill(&models.ApiFill{Market: "AVAX-USDC", Side: models.Bid, Size: decimal.NewFromInt(3)})
+	if got := risk.Account(sub).Positions().Position("AVAX-USDC"); !got.Equal(decimal.NewFromInt(3)) {
+		t.Fatalf("subaccount position %s, want 3", got)
+	}
+	if got := risk.Account(client).Positions().Position("AVAX-USDC"); !got.IsZero() {
+		t.Fatalf("main position %s, want 0", got)
+	}
+	if n := risk.Account(client).Orders().Count(); n != 1 {
+		t.Fatalf("main account has %d open orders, want 1", n)
+	}
+}
@@ -0,0 +1,229 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Signer signs API key authenticated requests. Implementations decide where the key secret lives.
+type Signer interface {
+	// KeyId returns the ID of the API key, sent in the clear with each request
+	KeyId() string
+
+	// Sign returns the HMAC-SHA256 of payload under the key secret
+	Sign(payload []byte) ([]byte, error)
+}
+
+// HmacSigner signs with a secret held in memory
+type HmacSigner struct {
+	keyId  string
+	secret []byte
+}
+
+func NewHmacSigner(keyId, keySecret string) *HmacSigner {
+	return &HmacSigner{keyId: keyId, secret: []byte(keySecret)}
+}
+
+func (s *HmacSigner) KeyId() string {
+	return s.keyId
+}
+
+func (s *HmacSigner) Sign(payload []byte) ([]byte, error) {
+	return generateSignature(s.secret, payload), nil
+}
+
+func generateSignature(secret []byte, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// FileSigner reads the secret from a file each time it signs, so it is only in memory for the duration of a
+// signature. The file must not be readable or writable by group or others.
+type FileSigner struct {
+	keyId string
+	path  string
+}
+
+func NewFileSigner(keyId, path string) (*FileSigner, error) {
+	s := &FileSigner{keyId: keyId, path: path}
+	if _, err := s.readSecret(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSigner) KeyId() string {
+	return s.keyId
+}
+
+func (s *FileSigner) Sign(payload []byte) ([]byte, error) {
+	secret, err := s.readSecret()
+	if err != nil {
+		return nil, err
+	}
+	return generateSignature(secret, payload), nil
+}
+
+func (s *FileSigner) readSecret() ([]byte, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat key file: %w", err)
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("key file %s has permissions %s, it must not be accessible by group or others", s.path, info.Mode().Perm())
+	}
+
+	secret, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("key file %s is empty", s.path)
+	}
+	return secret, nil
+}
+
+// CommandSigner delegates signing to a separate process, so the secret never enters this one. The command is run
+// once per signature with the payload on stdin and must write the hex encoded signature to stdout.
+type CommandSigner struct {
+	keyId   string
+	name    string
+	args    []string
+	Timeout time.Duration
+}
+
+func NewCommandSigner(keyId string, name string, args ...string) *CommandSigner {
+	return &CommandSigner{keyId: keyId, name: name, args: args, Timeout: DefaultTimeout}
+}
+
+func (s *CommandSigner) KeyId() string {
+	return s.keyId
+}
+
+func (s *CommandSigner) Sign(payload []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.name, s.args...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("signing command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	sig, err := hex.DecodeString(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		return nil, fmt.Errorf("signing command returned an invalid signature: %w", err)
+	}
+	return sig, nil
+}
+
+// Keystore is an API key secret encrypted with AES-256-GCM under a key derived from a passphrase with
+// PBKDF2-HMAC-SHA256
+type Keystore struct {
+	KeyId      string `json:"keyId"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+const keystoreIterations = 600_000
+
+// WriteKeystore encrypts keySecret with passphrase and writes it to path, readable only by the owner
+func WriteKeystore(path string, keyId string, keySecret string, passphrase string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	gcm, err := keystoreCipher(passphrase, salt, keystoreIterations)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	ks := Keystore{
+		KeyId:      keyId,
+		Iterations: keystoreIterations,
+		Salt:       hex.EncodeToString(salt),
+		Nonce:      hex.EncodeToString(nonce),
+		Ciphertext: hex.EncodeToString(gcm.Seal(nil, nonce, []byte(keySecret), []byte(keyId))),
+	}
+	data, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// NewKeystoreSigner decrypts the keystore at path. The secret is held by the returned signer only.
+func NewKeystoreSigner(path string, passphrase string) (*HmacSigner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore: %w", err)
+	}
+	var ks Keystore
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return nil, fmt.Errorf("failed to parse keystore %s: %w", path, err)
+	}
+
+	salt, err := hex.DecodeString(ks.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keystore salt: %w", err)
+	}
+	nonce, err := hex.DecodeString(ks.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keystore nonce: %w", err)
+	}
+	ciphertext, err := hex.DecodeString(ks.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keystore ciphertext: %w", err)
+	}
+
+	gcm, err := keystoreCipher(passphrase, salt, ks.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid keystore nonce length %d", len(nonce))
+	}
+	secret, err := gcm.Open(nil, nonce, ciphertext, []byte(ks.KeyId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt keystore, wrong passphrase?")
+	}
+
+	return &HmacSigner{keyId: ks.KeyId, secret: secret}, nil
+}
+
+func keystoreCipher(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	if iterations <= 0 {
+		return nil, fmt.Errorf("invalid keystore iterations %d", iterations)
+	}
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(passphrase), salt, iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,106 @@
+package apiclient
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignersAgree(t *testing.T) {
+	const keyId, secret = "key", "secret"
+	payload := []byte("1700000000000GET/v1/orders")
+	want := generateSignature([]byte(secret), payload)
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte(secret+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fileSigner, err := NewFileSigner(keyId, keyFile)
+	if err != nil {
+		t.Fatalf("NewFileSigner: %v", err)
+	}
+
+	keystore := filepath.Join(dir, "keystore.json")
+	if err := WriteKeystore(keystore, keyId, secret, "passphrase"); err != nil {
+		t.Fatalf("WriteKeystore: %v", err)
+	}
+	keystoreSigner, err := NewKeystoreSigner(keystore, "passphrase")
+	if err != nil {
+		t.Fatalf("NewKeystoreSigner: %v", err)
+	}
+
+	for name, signer := range map[string]Signer{
+		"hmac":     NewHmacSigner(keyId, secret),
+		"file":     fileSigner,
+		"keystore": keystoreSigner,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := signer.KeyId(); got != keyId {
+				t.Fatalf("KeyId() = %s, want %s", got, keyId)
+			}
+			sig, err := signer.Sign(payload)
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+			if !bytes.Equal(sig, want) {
+				t.Fatalf("Sign() = %x, want %x", sig, want)
+			}
+		})
+	}
+}
+
+func TestFileSignerRejectsSharedFiles(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileSigner("key", keyFile); err == nil {
+		t.Fatal("expected an error for a key file readable by others")
+	}
+}
+
+func TestKeystore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	if err := WriteKeystore(path, "key", "secret", "passphrase"); err != nil {
+		t.Fatalf("WriteKeystore: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("keystore permissions = %s, want -rw-------", perm)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Fatal("keystore contains the plaintext secret")
+	}
+
+	if _, err := NewKeystoreSigner(path, "wrong"); err == nil {
+		t.Fatal("expected an error for the wrong passphrase")
+	}
+}
+
+func TestCommandSigner(t *testing.T) {
+	signer := NewCommandSigner("key", "sh", "-c", `test "$(cat)" = payload && echo 0a0b`)
+	sig, err := signer.Sign([]byte("payload"))
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if !bytes.Equal(sig, []byte{0x0a, 0x0b}) {
+		t.Fatalf("Sign() = %x, want 0a0b", sig)
+	}
+
+	if _, err := NewCommandSigner("key", "sh", "-c", "echo not-hex").Sign(nil); err == nil {
+		t.Fatal("expected an error for a non-hex signature")
+	}
+	if _, err := NewCommandSigner("key", "sh", "-c", "exit 1").Sign(nil); err == nil {
+		t.Fatal("expected an error when the command fails")
+	}
+}
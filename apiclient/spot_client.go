@@ -2,6 +2,8 @@ package apiclient
 
 import (
 	"fmt"
+	"net/url"
+	"time"
 
 	"github.com/Enclave-Markets/enclave-go/models"
 )
@@ -9,8 +11,9 @@ import (
 func (client *ApiClient) AddSpotOrder(req models.AddOrderReq) (*models.GenericResponse[models.ApiOrder], error) {
 	path := models.V1SpotOrdersPath
 
-	res, err := NewHttpJsonClient[models.AddOrderReq, models.GenericResponse[models.ApiOrder]](
-		client.ApiEndpoint + path).SetHeaders(client.getHeaders("POST", path, req)).Post(req)
+	sent := time.Now()
+	res, err := send[models.AddOrderReq, models.GenericResponse[models.ApiOrder]](client, "POST", path, req)
+	client.getMetrics().ObserveOrderSubmit(ProductSpot, time.Since(sent), err == nil && res.Success)
 	if err != nil {
 		return res, fmt.Errorf("error with http req in spot add order: %w", err)
 	}
@@ -24,8 +27,9 @@ func (client *ApiClient) AddSpotOrder(req models.AddOrderReq) (*models.GenericRe
 func (client *ApiClient) AddSpotBatchOrders(req models.BatchAddOrderReq) (*models.GenericResponse[models.BatchAddOrderRes], error) {
 	path := models.V1SpotBatchOrdersPath
 
-	res, err := NewHttpJsonClient[models.BatchAddOrderReq, models.GenericResponse[models.BatchAddOrderRes]](
-		client.ApiEndpoint + path).SetHeaders(client.getHeaders("POST", path, req)).Post(req)
+	sent := time.Now()
+	res, err := send[models.BatchAddOrderReq, models.GenericResponse[models.BatchAddOrderRes]](client, "POST", path, req)
+	client.getMetrics().ObserveOrderSubmit(ProductSpot, time.Since(sent), err == nil && res.Success)
 	if err != nil {
 		return res, fmt.Errorf("error with http req in spot batch order: %w", err)
 	}
@@ -37,10 +41,9 @@ func (client *ApiClient) AddSpotBatchOrders(req models.BatchAddOrderReq) (*model
 }
 
 func (client *ApiClient) GetSpotDepthBook(market models.Market) (*models.GenericResponse[models.BookSnapshot], error) {
-	path := models.V1SpotDepthPath + "?market=" + string(market)
+	path := models.V1SpotDepthPath + "?market=" + url.QueryEscape(string(market))
 
-	res, err := NewHttpJsonClient[any, models.GenericResponse[models.BookSnapshot]](
-		client.ApiEndpoint + path).SetHeaders(client.getHeaders("GET", path, nil)).Get(nil)
+	res, err := send[any, models.GenericResponse[models.BookSnapshot]](client, "GET", path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error in http req Spot get depth book: %w", err)
 	}
@@ -52,10 +55,9 @@ func (client *ApiClient) GetSpotDepthBook(market models.Market) (*models.Generic
 }
 
 func (client *ApiClient) GetSpotOrder(orderId models.OrderID) (*models.GenericResponse[models.ApiOrder], error) {
-	path := models.V1SpotOrdersPath + "/" + string(orderId)
+	path := models.V1SpotOrdersPath + "/" + url.PathEscape(string(orderId))
 
-	res, err := NewHttpJsonClient[any, models.GenericResponse[models.ApiOrder]](
-		client.ApiEndpoint + path).SetHeaders(client.getHeaders("GET", path, nil)).Get(nil)
+	res, err := send[any, models.GenericResponse[models.ApiOrder]](client, "GET", path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error in http req spot get order: %w", err)
 	}
@@ -66,11 +68,24 @@ func (client *ApiClient) GetSpotOrder(orderId models.OrderID) (*models.GenericRe
 	return res, nil
 }
 
+func (client *ApiClient) GetSpotOrderByClientID(clientOrderId models.OrderID) (*models.GenericResponse[models.ApiOrder], error) {
+	path := models.V1SpotOrdersPath + "/" + models.V1SpotClientOrderIDPrefix + url.PathEscape(string(clientOrderId))
+
+	res, err := send[any, models.GenericResponse[models.ApiOrder]](client, "GET", path, nil)
+	if err != nil {
+		return res, fmt.Errorf("error in http req spot get order by client id: %w", err)
+	}
+	if !res.Success {
+		return res, fmt.Errorf("bad request spot get order by client id %s: %v", clientOrderId, res.Error)
+	}
+
+	return res, nil
+}
+
 func (client *ApiClient) CancelAllSpotOrders() error {
 	path := models.V1SpotOrdersPath
 
-	res, err := NewHttpJsonClient[any, models.GenericResponse[any]](
-		client.ApiEndpoint + path).SetHeaders(client.getHeaders("DELETE", path, nil)).Delete(nil)
+	res, err := send[any, models.GenericResponse[any]](client, "DELETE", path, nil)
 	if err != nil {
 		return fmt.Errorf("error in http req spot delete all orders: %w", err)
 	}
@@ -82,10 +97,9 @@ func (client *ApiClient) CancelAllSpotOrders() error {
 }
 
 func (client *ApiClient) CancelAllSpotOrdersOnMarket(market models.Market) error {
-	path := models.V1SpotOrdersPath + "?market=" + string(market)
+	path := models.V1SpotOrdersPath + "?market=" + url.QueryEscape(string(market))
 
-	res, err := NewHttpJsonClient[any, models.GenericResponse[any]](
-		client.ApiEndpoint + path).SetHeaders(client.getHeaders("DELETE", path, nil)).Delete(nil)
+	res, err := send[any, models.GenericResponse[any]](client, "DELETE", path, nil)
 	if err != nil {
 		return fmt.Errorf("error in http req spot delete all orders: %w", err)
 	}
@@ -97,10 +111,9 @@ func (client *ApiClient) CancelAllSpotOrdersOnMarket(market models.Market) error
 }
 
 func (client *ApiClient) CancelSpotOrder(orderId models.OrderID) (*models.GenericResponse[any], error) {
-	path := models.V1SpotOrdersPath + "/" + string(orderId)
+	path := models.V1SpotOrdersPath + "/" + url.PathEscape(string(orderId))
 
-	res, err := NewHttpJsonClient[any, models.GenericResponse[any]](
-		client.ApiEndpoint + path).SetHeaders(client.getHeaders("DELETE", path, nil)).Delete(nil)
+	res, err := send[any, models.GenericResponse[any]](client, "DELETE", path, nil)
 	if err != nil {
 		return res, fmt.Errorf("error in http req spot delete order: %w", err)
 	}
@@ -112,10 +125,9 @@ func (client *ApiClient) CancelSpotOrder(orderId models.OrderID) (*models.Generi
 }
 
 func (client *ApiClient) CancelSpotOrderByClientID(clientOrderId models.OrderID) (*models.GenericResponse[any], error) {
-	path := models.V1SpotOrdersPath + "/" + models.V1SpotClientOrderIDPrefix + string(clientOrderId)
+	path := models.V1SpotOrdersPath + "/" + models.V1SpotClientOrderIDPrefix + url.PathEscape(string(clientOrderId))
 
-	res, err := NewHttpJsonClient[any, models.GenericResponse[any]](
-		client.ApiEndpoint + path).SetHeaders(client.getHeaders("DELETE", path, nil)).Delete(nil)
+	res, err := send[any, models.GenericResponse[any]](client, "DELETE", path, nil)
 	if err != nil {
 		return res, fmt.Errorf("error in http req spot delete order by client id: %w", err)
 	}
@@ -130,8 +142,7 @@ func (client *ApiClient) GetSpotFills(params models.FillParams) (*models.V1PageR
 	path := models.V1SpotFillsPath
 	path += params.GetFillPathParams()
 
-	res, err := NewHttpJsonClient[any, models.V1PageRes[models.ApiFill]](
-		client.ApiEndpoint + path).SetHeaders(client.getHeaders("GET", path, nil)).Get(nil)
+	res, err := send[any, models.V1PageRes[models.ApiFill]](client, "GET", path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error in http req spot get fills: %w", err)
 	}
@@ -140,10 +151,9 @@ func (client *ApiClient) GetSpotFills(params models.FillParams) (*models.V1PageR
 }
 
 func (client *ApiClient) GetSpotFillsByOrderID(orderID models.OrderID) (*models.GenericResponse[[]models.ApiFill], error) {
-	path := models.V1SpotOrdersPath + "/" + string(orderID) + "/fills"
+	path := models.V1SpotOrdersPath + "/" + url.PathEscape(string(orderID)) + "/fills"
 
-	res, err := NewHttpJsonClient[any, models.GenericResponse[[]models.ApiFill]](
-		client.ApiEndpoint + path).SetHeaders(client.getHeaders("GET", path, nil)).Get(nil)
+	res, err := send[any, models.GenericResponse[[]models.ApiFill]](client, "GET", path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error in http req spot get fill by order ID: %w", err)
 	}
@@ -156,10 +166,9 @@ func (client *ApiClient) GetSpotFillsByOrderID(orderID models.OrderID) (*models.
 }
 
 func (client *ApiClient) GetSpotFillsByClientOrderID(orderID models.OrderID) (*models.GenericResponse[[]models.ApiFill], error) {
-	path := models.V1SpotOrdersPath + "/" + models.V1SpotClientOrderIDPrefix + string(orderID) + "/fills"
+	path := models.V1SpotOrdersPath + "/" + models.V1SpotClientOrderIDPrefix + url.PathEscape(string(orderID)) + "/fills"
 
-	res, err := NewHttpJsonClient[any, models.GenericResponse[[]models.ApiFill]](
-		client.ApiEndpoint + path).SetHeaders(client.getHeaders("GET", path, nil)).Get(nil)
+	res, err := send[any, models.GenericResponse[[]models.ApiFill]](client, "GET", path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error in http req spot get fill by client order ID: %w", err)
 	}
@@ -170,3 +179,15 @@ func (client *ApiClient) GetSpotFillsByClientOrderID(orderID models.OrderID) (*m
 
 	return res, err
 }
+
+func (client *ApiClient) GetSpotOrders(params models.OrderParams) (*models.V1PageRes[models.ApiOrder], error) {
+	path := models.V1SpotOrdersPath
+	path += params.GetOrderPathParams()
+
+	res, err := send[any, models.V1PageRes[models.ApiOrder]](client, "GET", path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error in http req spot get orders: %w", err)
+	}
+
+	return res, err
+}
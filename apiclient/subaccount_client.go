@@ -0,0 +1,34 @@
+package apiclient
+
+import (
+	"github.com/Enclave-Markets/enclave-go/models"
+)
+
+const subaccountHeader = "ENCLAVE-SUBACCOUNT"
+
+// ForSubaccount returns a view of the client scoped to a subaccount. Every REST request and websocket login made
+// through it acts on the subaccount instead of the main account. The view shares credentials and connection
+// settings with c.
+func (c *ApiClient) ForSubaccount(id models.SubaccountID) *ApiClient {
+	sub := c.subaccountView(id)
+	sub.shared.register(sub)
+	return sub
+}
+
+// subaccountView returns a copy of c scoped to a subaccount, or to the main account if id is empty, without
+// registering it with the kill switch
+func (c *ApiClient) subaccountView(id models.SubaccountID) *ApiClient {
+	sub := *c
+	sub.subaccountId = id
+	sub.interceptors = c.interceptors.clone()
+	sub.Headers = make(map[string]string, len(c.Headers))
+	for k, v := range c.Headers {
+		sub.Headers[k] = v
+	}
+	return &sub
+}
+
+// Subaccount returns the subaccount the client is scoped to, empty for the main account
+func (c *ApiClient) Subaccount() models.SubaccountID {
+	return c.subaccountId
+}
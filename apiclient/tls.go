@@ -0,0 +1,56 @@
+package apiclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+)
+
+// WithTLSConfig sets the TLS configuration used for both REST and websocket connections. A nil cfg restores the
+// defaults, undoing this and the other TLS options.
+func (c *ApiClient) WithTLSConfig(cfg *tls.Config) *ApiClient {
+	if cfg == nil {
+		c.tlsConfig = nil
+		c.httpClient = nil
+		return c
+	}
+	return c.updateTLSConfig(func(current *tls.Config) {
+		*current = *cfg.Clone()
+	})
+}
+
+// WithRootCAs trusts pool instead of the system roots when verifying the server certificate
+func (c *ApiClient) WithRootCAs(pool *x509.CertPool) *ApiClient {
+	return c.updateTLSConfig(func(cfg *tls.Config) {
+		cfg.RootCAs = pool
+	})
+}
+
+// WithClientCertificate presents cert to servers that require mutual TLS
+func (c *ApiClient) WithClientCertificate(cert tls.Certificate) *ApiClient {
+	return c.updateTLSConfig(func(cfg *tls.Config) {
+		cfg.Certificates = append(cfg.Certificates, cert)
+	})
+}
+
+// WithInsecureSkipVerify disables server certificate verification. Only use against local stacks.
+func (c *ApiClient) WithInsecureSkipVerify() *ApiClient {
+	return c.updateTLSConfig(func(cfg *tls.Config) {
+		cfg.InsecureSkipVerify = true
+	})
+}
+
+// updateTLSConfig applies update to a copy of the client's TLS configuration and rebuilds the http.Client with it
+func (c *ApiClient) updateTLSConfig(update func(cfg *tls.Config)) *ApiClient {
+	cfg := &tls.Config{}
+	if c.tlsConfig != nil {
+		cfg = c.tlsConfig.Clone()
+	}
+	update(cfg)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	c.tlsConfig = cfg
+	c.httpClient = &http.Client{Transport: transport}
+	return c
+}
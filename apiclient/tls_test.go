@@ -0,0 +1,85 @@
+package apiclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTLSStatusServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"marketStatuses":{}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTLSOptions(t *testing.T) {
+	srv := newTLSStatusServer(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	tests := []struct {
+		name    string
+		client  func() *ApiClient
+		wantErr bool
+	}{
+		{"defaults verify the certificate", func() *ApiClient { return NewApiClient(srv.URL) }, true},
+		{"root CAs", func() *ApiClient { return NewApiClient(srv.URL).WithRootCAs(roots) }, false},
+		{"insecure skip verify", func() *ApiClient { return NewApiClient(srv.URL).WithInsecureSkipVerify() }, false},
+		{"tls config", func() *ApiClient { return NewApiClient(srv.URL).WithTLSConfig(&tls.Config{RootCAs: roots}) }, false},
+		{"nil tls config restores the defaults", func() *ApiClient {
+			return NewApiClient(srv.URL).WithInsecureSkipVerify().WithRootCAs(roots).WithTLSConfig(nil)
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.client().GetPublicStatus()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetPublicStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSOptionsDoNotAlterTheCallersConfig(t *testing.T) {
+	cfg := &tls.Config{ServerName: "example.com"}
+	NewApiClient("https://localhost").WithTLSConfig(cfg).WithInsecureSkipVerify()
+	if cfg.InsecureSkipVerify {
+		t.Fatal("WithInsecureSkipVerify changed the config passed to WithTLSConfig")
+	}
+}
+
+func TestWebsocketURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		override string
+		want     string
+	}{
+		{"https", "https://api.enclave.market", "", "wss://api.enclave.market/ws"},
+		{"http keeps plaintext", "http://localhost:8080", "", "ws://localhost:8080/ws"},
+		{"path prefix", "https://example.com/exchange/", "", "wss://example.com/exchange/ws"},
+		{"explicit url", "https://api.enclave.market", "ws://localhost:9000/socket", "ws://localhost:9000/socket"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewApiClient(tt.endpoint)
+			if tt.override != "" {
+				client.WithWebsocketURL(tt.override)
+			}
+			got, err := client.getWebsocketURL()
+			if err != nil {
+				t.Fatalf("getWebsocketURL() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("getWebsocketURL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package apiclient
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+)
+
+// Tracer opens spans around API calls. It mirrors the parts of OpenTelemetry the client needs, so an adapter over
+// an OTel tracer and propagator can be plugged in without the SDK depending on OTel.
+type Tracer interface {
+	// Start opens a span as a child of any span in ctx and returns a context containing it
+	Start(ctx context.Context, name string, attrs map[string]string) (context.Context, Span)
+
+	// Inject writes the trace context of ctx into outbound request headers, e.g. as a W3C traceparent
+	Inject(ctx context.Context, headers map[string]string)
+}
+
+type Span interface {
+	SetAttribute(key string, value string)
+
+	// End closes the span, recording err if the call failed
+	End(err error)
+}
+
+// Span attribute keys
+const (
+	AttrEndpoint      = "enclave.endpoint"
+	AttrMethod        = "http.method"
+	AttrStatusCode    = "http.status_code"
+	AttrMarket        = "enclave.market"
+	AttrClientOrderID = "enclave.client_order_id"
+	AttrOrderCount    = "enclave.order_count"
+	AttrOutcome       = "enclave.outcome"
+)
+
+// WithTracer opens a span for every REST call and propagates its trace context in the request headers
+func (c *ApiClient) WithTracer(tracer Tracer) *ApiClient {
+	c.tracer = tracer
+	return c
+}
+
+// WithContext returns a view of the client whose calls use ctx: spans are parented to any span in ctx, and waits
+// for the rate limiter are abandoned when ctx is done
+func (c *ApiClient) WithContext(ctx context.Context) *ApiClient {
+	view := *c
+	view.ctx = ctx
+	view.interceptors = c.interceptors.clone()
+	return &view
+}
+
+func (c *ApiClient) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// startSpan opens a span for a REST call, or returns a no-op span if the client has no tracer
+func (c *ApiClient) startSpan(method string, path string, req any) (context.Context, Span) {
+	ctx := c.context()
+	if c.tracer == nil {
+		return ctx, noopSpan{}
+	}
+
+	endpoint := endpointLabel(path)
+	attrs := map[string]string{
+		AttrEndpoint: endpoint,
+		AttrMethod:   method,
+	}
+	switch req := req.(type) {
+	case models.AddOrderReq:
+		attrs[AttrMarket] = string(req.Market)
+		if req.ClientOrderID != "" {
+			attrs[AttrClientOrderID] = string(req.ClientOrderID)
+		}
+	case models.BatchAddOrderReq:
+		attrs[AttrOrderCount] = strconv.Itoa(len(req.Orders))
+	}
+	if _, query, ok := strings.Cut(path, "?"); ok {
+		if values, err := url.ParseQuery(query); err == nil && values.Get("market") != "" {
+			attrs[AttrMarket] = values.Get("market")
+		}
+	}
+
+	return c.tracer.Start(ctx, "enclave "+method+" "+endpoint, attrs)
+}
+
+// endSpan records the outcome of a REST call, including unsuccessful GenericResponses
+func endSpan(span Span, status int, res any, err error) {
+	if status != 0 {
+		span.SetAttribute(AttrStatusCode, strconv.Itoa(status))
+	}
+	if err == nil {
+		if r, ok := res.(models.Response); ok && !r.IsSuccess() {
+			err = r.ResponseError()
+		}
+	}
+
+	if err != nil {
+		span.SetAttribute(AttrOutcome, "error")
+	} else {
+		span.SetAttribute(AttrOutcome, "success")
+	}
+	span.End(err)
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, string) {}
+func (noopSpan) End(error)                   {}
@@ -0,0 +1,109 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+type spanKey struct{}
+
+// recordedSpan is a span opened by a recordingTracer
+type recordedSpan struct {
+	name   string
+	parent string
+	attrs  map[string]string
+	ended  bool
+	err    error
+}
+
+func (s *recordedSpan) SetAttribute(key string, value string) { s.attrs[key] = value }
+func (s *recordedSpan) End(err error)                         { s.ended, s.err = true, err }
+
+// recordingTracer records the spans it opens and injects the name of the current span as a traceparent header
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (tr *recordingTracer) Start(ctx context.Context, name string, attrs map[string]string) (context.Context, Span) {
+	span := &recordedSpan{name: name, attrs: attrs}
+	if parent, ok := ctx.Value(spanKey{}).(string); ok {
+		span.parent = parent
+	}
+	tr.mu.Lock()
+	tr.spans = append(tr.spans, span)
+	tr.mu.Unlock()
+	return context.WithValue(ctx, spanKey{}, name), span
+}
+
+func (tr *recordingTracer) Inject(ctx context.Context, headers map[string]string) {
+	if name, ok := ctx.Value(spanKey{}).(string); ok {
+		headers["traceparent"] = name
+	}
+}
+
+func TestTracerSpansOrders(t *testing.T) {
+	ex := newFakeExchange(t)
+	acceptSpotOrders(t, ex)
+
+	tracer := &recordingTracer{}
+	ctx := context.WithValue(context.Background(), spanKey{}, "strategy")
+	client := ex.client().WithTracer(tracer).WithContext(ctx)
+
+	_, err := client.AddSpotOrder(models.AddOrderReq{Market: "AVAX-USDC", Side: models.Bid, Type: models.OrderTypeLimit,
+		Price: decimal.NewFromInt(40), Size: decimal.NewFromInt(1), ClientOrderID: "c1"})
+	if err != nil {
+		t.Fatalf("AddSpotOrder: %v", err)
+	}
+
+	if len(tracer.spans) != 1 {
+		t.Fatalf("opened %d spans, want 1", len(tracer.spans))
+	}
+	span := tracer.spans[0]
+	if span.name != "enclave POST /v1/orders" || span.parent != "strategy" || !span.ended || span.err != nil {
+		t.Fatalf("span = %+v, want an ended span under strategy", span)
+	}
+	for key, want := range map[string]string{
+		AttrEndpoint:      "/v1/orders",
+		AttrMethod:        "POST",
+		AttrMarket:        "AVAX-USDC",
+		AttrClientOrderID: "c1",
+		AttrStatusCode:    "200",
+		AttrOutcome:       "success",
+	} {
+		if got := span.attrs[key]; got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+
+	reqs := ex.requests("POST " + models.V1SpotOrdersPath)
+	if got := reqs[0].Header.Get("traceparent"); got != span.name {
+		t.Fatalf("traceparent = %q, want the call's span %q", got, span.name)
+	}
+}
+
+func TestTracerRecordsFailures(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("GET "+models.V1SpotOrdersPath+"/*", func(fakeRequest) (int, any) {
+		return http.StatusNotFound, models.GenericResponse[any]{Error: "order not found"}
+	})
+
+	tracer := &recordingTracer{}
+	client := ex.client().WithTracer(tracer)
+	if _, err := client.GetSpotOrder("o1"); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	span := tracer.spans[0]
+	if span.name != "enclave GET /v1/orders/{id}" || span.err == nil {
+		t.Fatalf("span = %+v, want a failed span for the order endpoint", span)
+	}
+	if span.attrs[AttrOutcome] != "error" || span.attrs[AttrStatusCode] != "404" {
+		t.Fatalf("attrs = %v, want outcome error and status 404", span.attrs)
+	}
+}
@@ -0,0 +1,373 @@
+package apiclient
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+type TriggerKind int
+
+const (
+	StopLoss TriggerKind = iota
+	TakeProfit
+
+	// A stop loss that follows the price by TrailingDistance as it moves in the position's favour
+	TrailingStop
+)
+
+func (k TriggerKind) String() string {
+	switch k {
+	case StopLoss:
+		return "stopLoss"
+	case TakeProfit:
+		return "takeProfit"
+	case TrailingStop:
+		return "trailingStop"
+	default:
+		return "unknown"
+	}
+}
+
+// PriceSource is the price a trigger watches
+type PriceSource int
+
+const (
+	// The perps mark price. Spot markets have none, so spot triggers always watch TopOfBook.
+	MarkPrice PriceSource = iota
+
+	// The best bid for sell triggers and the best ask for buy triggers, i.e. the price the order would fill at
+	TopOfBook
+)
+
+// TriggerOrder is a market order that is sent when the price crosses a trigger
+type TriggerOrder struct {
+	Product Product
+	Market  models.Market
+
+	// Side of the order sent when triggered: Ask to close a long position, Bid to close a short one
+	Side models.BidAsk
+
+	// Size of the order. For perps, zero closes the whole position at the time the trigger fires.
+	Size decimal.Decimal
+
+	Kind   TriggerKind
+	Source PriceSource
+
+	// Price at which stop loss and take profit triggers fire
+	TriggerPrice decimal.Decimal
+
+	// Distance of a trailing stop from the best price seen since it was added
+	TrailingDistance decimal.Decimal
+
+	// When one trigger in a group fires the others are cancelled, e.g. for a stop loss and take profit bracket
+	Group string
+}
+
+// TriggerFired reports a trigger that fired and the order it sent
+type TriggerFired struct {
+	ID      string
+	Trigger TriggerOrder
+	Price   decimal.Decimal
+	Order   *models.ApiOrder
+	Err     error
+}
+
+// TriggerEngine keeps stop loss, take profit and trailing stop orders on the client side, watching mark prices and
+// top of book and sending market orders when they trigger. Perps orders are sent reduce-only. A trigger and the
+// rest of its group are only removed once its order is accepted and hasn't closed without filling. If the order is
+// rejected, or closes without filling, the group is re-armed and fires again on the next price that crosses it. If the outcome is unknown, e.g. after a timeout or a 5xx, the group
+// is re-armed but keeps the order's client order ID: when it next fires the order is looked up first, and only
+// resent, with the same client order ID, if the exchange doesn't know it. A group never has two orders in flight.
+type TriggerEngine struct {
+	client *ApiClient
+
+	mu        sync.Mutex
+	nextID    int
+	triggers  map[string]*trigger
+	positions map[models.Market]decimal.Decimal
+	onFire    []func(TriggerFired)
+}
+
+type trigger struct {
+	TriggerOrder
+
+	// Best price seen by a trailing stop: the highest for sell stops, the lowest for buy stops
+	extreme decimal.Decimal
+
+	// The trigger or another in its group has fired and its order is being sent
+	firing bool
+
+	// Client order ID of an order sent by the group whose outcome is unknown
+	unresolved models.OrderID
+}
+
+func (c *ApiClient) NewTriggerEngine() *TriggerEngine {
+	return &TriggerEngine{
+		client:    c,
+		triggers:  map[string]*trigger{},
+		positions: map[models.Market]decimal.Decimal{},
+	}
+}
+
+// OnFire calls fn after every trigger fires, with the result of sending its order
+func (e *TriggerEngine) OnFire(fn func(TriggerFired)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onFire = append(e.onFire, fn)
+}
+
+// Add starts watching a trigger and returns its ID
+func (e *TriggerEngine) Add(order TriggerOrder) (string, error) {
+	switch order.Kind {
+	case StopLoss, TakeProfit:
+		if !order.TriggerPrice.IsPositive() {
+			return "", fmt.Errorf("bad trigger: %s needs a trigger price", order.Kind)
+		}
+	case TrailingStop:
+		if !order.TrailingDistance.IsPositive() {
+			return "", errors.New("bad trigger: trailing stop needs a trailing distance")
+		}
+	default:
+		return "", fmt.Errorf("bad trigger: unknown kind %d", order.Kind)
+	}
+	if order.Product != ProductPerps {
+		if order.Size.IsZero() {
+			return "", errors.New("bad trigger: spot triggers need a size")
+		}
+		order.Source = TopOfBook
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.nextID++
+	id := strconv.Itoa(e.nextID)
+	e.triggers[id] = &trigger{TriggerOrder: order}
+	return id, nil
+}
+
+// Cancel stops watching a trigger
+func (e *TriggerEngine) Cancel(id string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.triggers, id)
+}
+
+// Triggers returns the triggers being watched by ID
+func (e *TriggerEngine) Triggers() map[string]TriggerOrder {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	triggers := make(map[string]TriggerOrder, len(e.triggers))
+	for id, t := range e.triggers {
+		triggers[id] = t.TriggerOrder
+	}
+	return triggers
+}
+
+// StopPrice returns the current trigger price of a trigger, which moves with the price for trailing stops
+func (e *TriggerEngine) StopPrice(id string) (decimal.Decimal, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t, ok := e.triggers[id]
+	if !ok {
+		return decimal.Zero, false
+	}
+	return t.stopPrice(), true
+}
+
+func (e *TriggerEngine) OnMarkPrice(price *models.GetMarkPriceRes) {
+	e.evaluate(price.Market, func(t *trigger) (decimal.Decimal, bool) {
+		return price.MarkPrice, t.Source == MarkPrice
+	})
+}
+
+func (e *TriggerEngine) OnBook(book *models.ApiBookSnapshot) {
+	e.evaluate(book.Market, func(t *trigger) (decimal.Decimal, bool) {
+		if t.Source != TopOfBook {
+			return decimal.Zero, false
+		}
+		levels := book.Bids
+		if t.Side == models.Bid {
+			levels = book.Asks
+		}
+		if len(levels) == 0 {
+			return decimal.Zero, false
+		}
+		return levels[0].Price, true
+	})
+}
+
+// OnPosition records perps positions, used to size triggers that close the whole position
+func (e *TriggerEngine) OnPosition(position *models.ApiPosition) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.positions[position.Market] = position.SignedQuantity()
+}
+
+// HandleEvent passes a dispatcher event to the matching On* method
+func (e *TriggerEngine) HandleEvent(event Event) {
+	switch data := event.Data.(type) {
+	case *models.ApiBookSnapshot:
+		e.OnBook(data)
+	case *models.GetMarkPriceRes:
+		e.OnMarkPrice(data)
+	case *models.ApiPosition:
+		e.OnPosition(data)
+	}
+}
+
+// Attach feeds the engine from every market on d's top of book, mark price and position channels. The channels
+// still need to be subscribed.
+func (e *TriggerEngine) Attach(d *Dispatcher) {
+	latest := HandlerOptions{Policy: ConflateLatest}
+	d.Handle(TopOfBooksSpot(), "", latest, e.HandleEvent)
+	d.Handle(TopOfBooksPerps(), "", latest, e.HandleEvent)
+	d.Handle(PerpsMarkPrices(), "", latest, e.HandleEvent)
+	d.Handle(PerpsPositions(), "", latest, e.HandleEvent)
+}
+
+// evaluate checks every trigger on market against the price returned by priceOf, and fires those that are crossed
+func (e *TriggerEngine) evaluate(market models.Market, priceOf func(t *trigger) (decimal.Decimal, bool)) {
+	var fired []TriggerFired
+	var req []models.AddOrderReq
+	var retry []bool
+
+	e.mu.Lock()
+	for id, t := range e.triggers {
+		if t.Market != market || t.firing {
+			continue
+		}
+		price, ok := priceOf(t)
+		if !ok || !t.update(price) {
+			continue
+		}
+
+		order := models.AddOrderReq{
+			Side:          t.Side,
+			Size:          t.Size,
+			Market:        t.Market,
+			ClientOrderID: t.unresolved,
+			Type:          models.OrderTypeMarket,
+		}
+		if order.ClientOrderID == "" {
+			order.ClientOrderID = NewClientOrderID("trigger-")
+		}
+		if t.Product == ProductPerps {
+			order.ReduceOnly = true
+			if order.Size.IsZero() {
+				order.Size = e.positions[t.Market].Abs()
+			}
+		}
+		fired = append(fired, TriggerFired{ID: id, Trigger: t.TriggerOrder, Price: price})
+		req = append(req, order)
+		retry = append(retry, t.unresolved != "")
+		for _, other := range e.group(id, t.Group) {
+			other.firing = true
+		}
+	}
+	onFire := e.onFire
+	e.mu.Unlock()
+
+	for i, f := range fired {
+		var notPlaced bool
+		f.Order, notPlaced, f.Err = e.send(f.Trigger.Product, &req[i], retry[i])
+		unresolved := req[i].ClientOrderID
+		if notPlaced {
+			unresolved = ""
+		}
+		e.settle(f.ID, f.Trigger.Group, f.Err == nil, unresolved)
+		for _, fn := range onFire {
+			fn(f)
+		}
+	}
+}
+
+// group returns the trigger with id, if it is still watched, and the others in its group by ID. It is called with
+// e.mu held.
+func (e *TriggerEngine) group(id, name string) map[string]*trigger {
+	group := map[string]*trigger{}
+	for other, t := range e.triggers {
+		if other == id || (name != "" && t.Group == name) {
+			group[other] = t
+		}
+	}
+	return group
+}
+
+// settle removes a fired trigger and its group once its order is accepted, or re-arms them otherwise. unresolved is
+// the client order ID of an order whose outcome is unknown, to look up before the group sends again.
+func (e *TriggerEngine) settle(id, group string, accepted bool, unresolved models.OrderID) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for other, t := range e.group(id, group) {
+		if accepted {
+			delete(e.triggers, other)
+		} else {
+			t.firing = false
+			t.unresolved = unresolved
+		}
+	}
+}
+
+// send sends a trigger's order and reports whether it definitely wasn't placed. A retry of an order whose outcome
+// is unknown looks it up first, and only sends it again if the exchange doesn't know it, or sends a new order if
+// the earlier one closed without filling. An order that is accepted but closes without filling, e.g. a market
+// order cancelled for lack of liquidity, counts as not placed so the trigger is re-armed.
+func (e *TriggerEngine) send(product Product, req *models.AddOrderReq, retry bool) (*models.ApiOrder, bool, error) {
+	if retry {
+		order, err := e.client.getOrderByClientID(product, req.ClientOrderID)
+		switch {
+		case err == nil && !closedUnfilled(order):
+			return order, false, nil
+		case err == nil:
+			req.ClientOrderID = NewClientOrderID("trigger-")
+		case !IsNotFound(err):
+			return nil, false, err
+		}
+	}
+	if req.Size.IsZero() {
+		return nil, !retry, fmt.Errorf("trigger on %s has no size and no position to close", req.Market)
+	}
+	order, err := e.client.addOrder(product, *req)
+	if err == nil && closedUnfilled(order) {
+		return order, true, fmt.Errorf("trigger order %s was %s without filling", req.ClientOrderID, order.State)
+	}
+	return order, err != nil && definitelyNotPlaced(err), err
+}
+
+// closedUnfilled reports whether an order was cancelled or rejected before any of it filled
+func closedUnfilled(order *models.ApiOrder) bool {
+	return orderClosed(order) && !order.FilledQuantity.IsPositive()
+}
+
+// update moves a trailing stop with price and reports whether the trigger fires at price
+func (t *trigger) update(price decimal.Decimal) bool {
+	sell := t.Side == models.Ask
+	if t.Kind == TrailingStop {
+		if t.extreme.IsZero() || (sell && price.GreaterThan(t.extreme)) || (!sell && price.LessThan(t.extreme)) {
+			t.extreme = price
+		}
+	}
+
+	stop := t.stopPrice()
+	switch {
+	case t.Kind == TakeProfit && sell, t.Kind != TakeProfit && !sell:
+		return price.GreaterThanOrEqual(stop)
+	default:
+		return price.LessThanOrEqual(stop)
+	}
+}
+
+func (t *trigger) stopPrice() decimal.Decimal {
+	if t.Kind != TrailingStop {
+		return t.TriggerPrice
+	}
+	if t.Side == models.Ask {
+		return t.extreme.Sub(t.TrailingDistance)
+	}
+	return t.extreme.Add(t.TrailingDistance)
+}
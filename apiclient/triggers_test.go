@@ -0,0 +1,210 @@
+package apiclient
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestTriggerEngineRearmsOnSendError(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		lookup       int
+		wantSent     int
+		wantSameID   bool
+		wantWatching int
+	}{
+		{name: "accepted", statuses: []int{http.StatusOK}, wantSent: 1, wantWatching: 0},
+		{name: "rejected then accepted", statuses: []int{http.StatusBadRequest, http.StatusOK}, wantSent: 2, wantWatching: 0},
+		{name: "unknown and placed", statuses: []int{http.StatusInternalServerError}, lookup: http.StatusOK, wantSent: 1, wantWatching: 0},
+		{name: "unknown and not placed", statuses: []int{http.StatusInternalServerError, http.StatusOK}, lookup: http.StatusNotFound,
+			wantSent: 2, wantSameID: true, wantWatching: 0},
+		{name: "unknown and lookup fails", statuses: []int{http.StatusInternalServerError}, lookup: http.StatusInternalServerError,
+			wantSent: 1, wantWatching: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newFakeExchange(t)
+			sent := 0
+			ex.handle("POST "+models.V1PerpsOrdersPath, func(req fakeRequest) (int, any) {
+				status := tt.statuses[min(sent, len(tt.statuses)-1)]
+				sent++
+				return status, models.GenericResponse[models.ApiOrder]{Success: status == http.StatusOK}
+			})
+			ex.handle("GET "+models.V1PerpsOrdersPath+"/client:*", func(req fakeRequest) (int, any) {
+				return tt.lookup, models.GenericResponse[models.ApiOrder]{Success: tt.lookup == http.StatusOK}
+			})
+			engine := ex.client().NewTriggerEngine()
+			engine.OnPosition(&models.ApiPosition{Market: "BTC-USD.P", Direction: "long", NetQuantity: decimal.NewFromInt(1)})
+			stop := TriggerOrder{Product: ProductPerps, Market: "BTC-USD.P", Side: models.Ask, Kind: StopLoss,
+				TriggerPrice: decimal.NewFromInt(90), Group: "bracket"}
+			engine.Add(stop)
+			takeProfit := stop
+			takeProfit.Kind, takeProfit.TriggerPrice = TakeProfit, decimal.NewFromInt(110)
+			engine.Add(takeProfit)
+
+			for i := 0; i < 3; i++ {
+				engine.OnMarkPrice(&models.GetMarkPriceRes{Market: "BTC-USD.P", MarkPrice: decimal.NewFromInt(85)})
+			}
+
+			requests := ex.requests("POST " + models.V1PerpsOrdersPath)
+			if len(requests) != tt.wantSent {
+				t.Fatalf("sent %d orders, want %d", len(requests), tt.wantSent)
+			}
+			var first, last models.AddOrderReq
+			requests[0].decode(t, &first)
+			requests[len(requests)-1].decode(t, &last)
+			if first.ClientOrderID == "" || (len(requests) > 1 && (first.ClientOrderID == last.ClientOrderID) != tt.wantSameID) {
+				t.Fatalf("client order IDs %q and %q, want the same one: %v", first.ClientOrderID, last.ClientOrderID, tt.wantSameID)
+			}
+			if n := len(engine.Triggers()); n != tt.wantWatching {
+				t.Fatalf("%d triggers watched, want %d", n, tt.wantWatching)
+			}
+		})
+	}
+}
+
+func TestTriggerEngineSpotWatchesTopOfBook(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("POST "+models.V1SpotOrdersPath, func(req fakeRequest) (int, any) {
+		return ok(models.ApiOrder{OrderID: "o1"})
+	})
+	engine := ex.client().NewTriggerEngine()
+	id, err := engine.Add(TriggerOrder{Product: ProductSpot, Market: "AVAX-USDC", Side: models.Ask, Size: decimal.NewFromInt(1),
+		Kind: StopLoss, TriggerPrice: decimal.NewFromInt(40)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source := engine.Triggers()[id].Source; source != TopOfBook {
+		t.Fatalf("spot trigger watches source %d, want TopOfBook", source)
+	}
+
+	engine.OnBook(book("AVAX-USDC", "39", "39.5"))
+	if n := len(ex.requests("POST " + models.V1SpotOrdersPath)); n != 1 {
+		t.Fatalf("sent %d orders, want 1", n)
+	}
+}
+
+func TestTriggerEngineRearmsWhenOrderClosesUnfilled(t *testing.T) {
+	tests := []struct {
+		name string
+		// State of the first attempt: in the response to a 200, or found by the lookup after a 500
+		status   int
+		state    models.OrderState
+		filled   string
+		wantSent int
+	}{
+		{name: "acknowledged and filled", status: http.StatusOK, state: models.FullyFilled, filled: "1", wantSent: 1},
+		{name: "acknowledged and cancelled unfilled", status: http.StatusOK, state: models.Canceled, filled: "0", wantSent: 2},
+		{name: "found partly filled", status: http.StatusInternalServerError, state: models.Canceled, filled: "0.5", wantSent: 1},
+		{name: "found cancelled unfilled", status: http.StatusInternalServerError, state: models.Canceled, filled: "0", wantSent: 2},
+		{name: "found rejected", status: http.StatusInternalServerError, state: models.Rejected, filled: "0", wantSent: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := newFakeExchange(t)
+			var sent []models.AddOrderReq
+			ex.handle("POST "+models.V1PerpsOrdersPath, func(req fakeRequest) (int, any) {
+				var add models.AddOrderReq
+				req.decode(t, &add)
+				sent = append(sent, add)
+				if len(sent) > 1 {
+					return ok(models.ApiOrder{ClientOrderID: add.ClientOrderID, State: models.FullyFilled, FilledQuantity: add.Size})
+				}
+				order := models.ApiOrder{ClientOrderID: add.ClientOrderID, State: tt.state, FilledQuantity: decimal.RequireFromString(tt.filled)}
+				return tt.status, models.GenericResponse[models.ApiOrder]{Success: tt.status == http.StatusOK, Result: order}
+			})
+			ex.handle("GET "+models.V1PerpsOrdersPath+"/client:*", func(req fakeRequest) (int, any) {
+				return ok(models.ApiOrder{ClientOrderID: sent[0].ClientOrderID, State: tt.state, FilledQuantity: decimal.RequireFromString(tt.filled)})
+			})
+			engine := ex.client().NewTriggerEngine()
+			engine.OnPosition(&models.ApiPosition{Market: "BTC-USD.P", Direction: "long", NetQuantity: decimal.NewFromInt(1)})
+			engine.Add(TriggerOrder{Product: ProductPerps, Market: "BTC-USD.P", Side: models.Ask, Kind: StopLoss,
+				TriggerPrice: decimal.NewFromInt(90)})
+
+			for i := 0; i < 3; i++ {
+				engine.OnMarkPrice(&models.GetMarkPriceRes{Market: "BTC-USD.P", MarkPrice: decimal.NewFromInt(85)})
+			}
+
+			if len(sent) != tt.wantSent {
+				t.Fatalf("sent %d orders, want %d", len(sent), tt.wantSent)
+			}
+			if len(sent) > 1 && sent[0].ClientOrderID == sent[1].ClientOrderID {
+				t.Fatal("resent a closed order's client order ID")
+			}
+			if n := len(engine.Triggers()); n != 0 {
+				t.Fatalf("%d triggers watched after the position was closed", n)
+			}
+		})
+	}
+}
+
+func TestTriggerEngineTrailingStop(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("POST "+models.V1PerpsOrdersPath, func(req fakeRequest) (int, any) { return ok(models.ApiOrder{State: models.FullyFilled}) })
+	engine := ex.client().NewTriggerEngine()
+	engine.OnPosition(&models.ApiPosition{Market: "BTC-USD.P", Direction: "long", NetQuantity: decimal.NewFromInt(1)})
+	id, err := engine.Add(TriggerOrder{Product: ProductPerps, Market: "BTC-USD.P", Side: models.Ask, Kind: TrailingStop,
+		TrailingDistance: decimal.NewFromInt(10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tick := range []struct {
+		price    int64
+		wantStop int64
+	}{
+		{price: 100, wantStop: 90},
+		{price: 120, wantStop: 110},
+		// Falling back doesn't lower the stop
+		{price: 115, wantStop: 110},
+	} {
+		engine.OnMarkPrice(&models.GetMarkPriceRes{Market: "BTC-USD.P", MarkPrice: decimal.NewFromInt(tick.price)})
+		if stop, ok := engine.StopPrice(id); !ok || !stop.Equal(decimal.NewFromInt(tick.wantStop)) {
+			t.Fatalf("stop at %s after %d, want %d", stop, tick.price, tick.wantStop)
+		}
+	}
+	if n := len(ex.requests("POST " + models.V1PerpsOrdersPath)); n != 0 {
+		t.Fatalf("sent %d orders before the stop was crossed", n)
+	}
+
+	engine.OnMarkPrice(&models.GetMarkPriceRes{Market: "BTC-USD.P", MarkPrice: decimal.NewFromInt(110)})
+	if n := len(ex.requests("POST " + models.V1PerpsOrdersPath)); n != 1 {
+		t.Fatalf("sent %d orders, want 1 once the price fell to the stop", n)
+	}
+}
+
+func TestTriggerEngineRemovesOnlyTheFiredGroup(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("POST "+models.V1PerpsOrdersPath, func(req fakeRequest) (int, any) { return ok(models.ApiOrder{State: models.FullyFilled}) })
+	engine := ex.client().NewTriggerEngine()
+	engine.OnPosition(&models.ApiPosition{Market: "BTC-USD.P", Direction: "long", NetQuantity: decimal.NewFromInt(1)})
+	add := func(kind TriggerKind, price int64, group string) string {
+		id, err := engine.Add(TriggerOrder{Product: ProductPerps, Market: "BTC-USD.P", Side: models.Ask, Kind: kind,
+			TriggerPrice: decimal.NewFromInt(price), Group: group})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	add(StopLoss, 90, "a")
+	add(TakeProfit, 130, "a")
+	otherStop := add(StopLoss, 80, "b")
+	otherTakeProfit := add(TakeProfit, 120, "b")
+
+	engine.OnMarkPrice(&models.GetMarkPriceRes{Market: "BTC-USD.P", MarkPrice: decimal.NewFromInt(85)})
+
+	triggers := engine.Triggers()
+	if len(triggers) != 2 {
+		t.Fatalf("%d triggers watched, want only group b", len(triggers))
+	}
+	if _, ok := triggers[otherStop]; !ok {
+		t.Fatal("stop loss of another group removed")
+	}
+	if _, ok := triggers[otherTakeProfit]; !ok {
+		t.Fatal("take profit of another group removed")
+	}
+}
@@ -2,7 +2,7 @@ package apiclient
 
 import (
 	"encoding/json"
-	"fmt"
+	"sync"
 
 	"github.com/Enclave-Markets/enclave-go/models"
 )
@@ -75,6 +75,31 @@ type WebSocketAPIResponse struct {
 	Data    any            `json:"data,omitempty"`
 }
 
+// ChannelDecoder decodes the data of an update on a channel
+type ChannelDecoder func(data json.RawMessage) (any, error)
+
+var (
+	channelDecodersMu sync.RWMutex
+	channelDecoders   = map[ChannelType]ChannelDecoder{}
+)
+
+// RegisterChannelDecoder sets how updates on channel are decoded, taking precedence over the built in decoding.
+// Updates on channels with no decoder are returned with their data as a json.RawMessage.
+func RegisterChannelDecoder(channel ChannelType, decode ChannelDecoder) {
+	channelDecodersMu.Lock()
+	defer channelDecodersMu.Unlock()
+	channelDecoders[channel] = decode
+}
+
+func getChannelDecoder(channel ChannelType) (ChannelDecoder, bool) {
+	channelDecodersMu.RLock()
+	defer channelDecodersMu.RUnlock()
+	decode, ok := channelDecoders[channel]
+	return decode, ok
+}
+
+// UnmarshalWebSocketAPIResponse decodes a websocket message. Messages of unknown types and updates on unknown
+// channels are not an error: their data is returned as a json.RawMessage so new server features don't break clients.
 func UnmarshalWebSocketAPIResponse(data []byte) (*WebSocketAPIResponse, error) {
 	type Temp struct {
 		Type    WSResponseType  `json:"type"`
@@ -111,6 +136,10 @@ func UnmarshalWebSocketAPIResponse(data []byte) (*WebSocketAPIResponse, error) {
 		err = json.Unmarshal(parsed.Data, &req)
 		result.Data = req
 	case Update:
+		if decode, ok := getChannelDecoder(parsed.Channel); ok {
+			result.Data, err = decode(parsed.Data)
+			break
+		}
 		switch parsed.Channel {
 		case TopOfBooksPerps(), TopOfBooksSpot():
 			var temp []*models.ApiBookSnapshot
@@ -129,10 +158,12 @@ func UnmarshalWebSocketAPIResponse(data []byte) (*WebSocketAPIResponse, error) {
 			err = json.Unmarshal(parsed.Data, &temp)
 			result.Data = temp
 		default:
-			return nil, fmt.Errorf("couldn't unmarshal response: unknown channel %s", parsed.Channel)
+			result.Data = parsed.Data
 		}
 	case LoggedIn:
 		result.Data = nil
+	default:
+		result.Data = parsed.Data
 	}
 
 	return result, err
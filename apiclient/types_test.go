@@ -0,0 +1,88 @@
+package apiclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Enclave-Markets/enclave-go/models"
+)
+
+func TestUnmarshalWebSocketAPIResponseUnknownValues(t *testing.T) {
+	tests := []struct {
+		name        string
+		message     string
+		raw         bool
+		unknownSide string
+	}{
+		{"known fill", `{"type":"update","channel":"fillsSpot","data":[{"market":"AVAX-USDC","side":"buy"}]}`, false, ""},
+		{"unknown side", `{"type":"update","channel":"fillsSpot","data":[{"market":"AVAX-USDC","side":"short"}]}`, false, "short"},
+		{"unknown channel", `{"type":"update","channel":"somethingNew","data":[{"side":"buy"}]}`, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := UnmarshalWebSocketAPIResponse([]byte(tt.message))
+			if err != nil {
+				t.Fatalf("UnmarshalWebSocketAPIResponse: %v", err)
+			}
+			_, raw := res.Data.(json.RawMessage)
+			if raw != tt.raw {
+				t.Fatalf("data = %T, want raw %v", res.Data, tt.raw)
+			}
+			if !raw {
+				fills := res.Data.([]*models.ApiFill)
+				if len(fills) != 1 || fills[0].Market != "AVAX-USDC" || fills[0].UnknownSide != tt.unknownSide {
+					t.Fatalf("fills = %+v", fills)
+				}
+				if tt.unknownSide == "" && fills[0].Side != models.Bid {
+					t.Fatalf("side = %v, want buy", fills[0].Side)
+				}
+			}
+		})
+	}
+
+	if _, err := UnmarshalWebSocketAPIResponse([]byte(`{"type":"update","channel":"fillsSpot","data":[{"side":1}]}`)); err == nil {
+		t.Fatal("expected an error for a malformed side")
+	}
+}
+
+func TestRESTResponsesWithUnknownSidesAndTypes(t *testing.T) {
+	ex := newFakeExchange(t)
+	ex.handle("GET "+models.V1SpotFillsPath, func(fakeRequest) (int, any) {
+		return http.StatusOK, json.RawMessage(`{"success":true,"result":[{"id":"f1","market":"AVAX-USDC","side":"short","size":"1"},{"id":"f2","market":"AVAX-USDC","side":"buy","size":"2"}]}`)
+	})
+	ex.handle("GET "+models.V1SpotOrdersPath, func(fakeRequest) (int, any) {
+		return http.StatusOK, json.RawMessage(`{"success":true,"result":[{"orderId":"o1","market":"AVAX-USDC","side":"sell","type":"stop","status":"open"}]}`)
+	})
+	client := ex.client()
+
+	fills, err := client.GetSpotFills(models.FillParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fills.Result) != 2 || fills.Result[0].UnknownSide != "short" || fills.Result[0].SideString() != "short" {
+		t.Fatalf("fills = %+v, want the first with side short", fills.Result)
+	}
+	if fills.Result[1].UnknownSide != "" || fills.Result[1].Side != models.Bid {
+		t.Fatalf("known fill = %+v", fills.Result[1])
+	}
+	data, err := json.Marshal(fills.Result[0])
+	if err != nil || !strings.Contains(string(data), `"side":"short"`) {
+		t.Fatalf("re-encoded fill %s, %v, want the side as sent", data, err)
+	}
+
+	orders, err := client.GetSpotOrders(models.OrderParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders.Result) != 1 || orders.Result[0].UnknownType != "stop" || orders.Result[0].Side != models.Ask || orders.Result[0].State != models.Open {
+		t.Fatalf("orders = %+v, want a sell with type stop", orders.Result)
+	}
+
+	positions := NewPositionTracker()
+	positions.OnSpotFill(fills.Result[0])
+	if got := positions.Position("AVAX-USDC"); !got.IsZero() {
+		t.Fatalf("position %s after a fill with an unknown side, want it ignored", got)
+	}
+}
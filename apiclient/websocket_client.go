@@ -5,9 +5,12 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -17,10 +20,22 @@ type WebsocketConn struct {
 	wsConn        *websocket.Conn
 	readDeadline  time.Duration
 	writeDeadline time.Duration
+	logger        *slog.Logger
+	metrics       Metrics
+
+	// Unix nanos of the last ping sent, to measure the round trip when its pong arrives
+	lastPing atomic.Int64
+
+	// The underlying connection supports one concurrent writer
+	writeMu sync.Mutex
 }
 
 const DefaultTimeout = 5 * time.Second
 
+// GetTlsConfig guesses whether to skip certificate verification from the endpoint host.
+//
+// Deprecated: the client no longer calls this. Use WithInsecureSkipVerify or an Environment with
+// TLSPolicyInsecure to opt in to skipping verification explicitly.
 func GetTlsConfig(apiEndpoint string) *tls.Config {
 	insecureSkipVerify := false
 	u, err := url.Parse(apiEndpoint)
@@ -45,61 +60,114 @@ func GetTlsConfig(apiEndpoint string) *tls.Config {
 }
 
 func (client *ApiClient) NewWebsocketConnection() (*WebsocketConn, error) {
-	u, err := url.Parse(client.ApiEndpoint)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse the api endpoint %s: %s", client.ApiEndpoint, err.Error())
-	}
-	host, port, err := net.SplitHostPort(u.Host)
+	spotWsEndpoint, err := client.getWebsocketURL()
 	if err != nil {
-		if u.Host != "" {
-			host = u.Host
-		} else {
-			return nil, err
-		}
-	}
-	var spotWsEndpoint string
-	if port == "" {
-		spotWsEndpoint = fmt.Sprintf("wss://%s/ws", host)
-	} else {
-		spotWsEndpoint = fmt.Sprintf("wss://%s:%s/ws", host, port)
+		return nil, err
 	}
 
 	dialer := *websocket.DefaultDialer
-	dialer.TLSClientConfig = GetTlsConfig(spotWsEndpoint)
+	dialer.TLSClientConfig = client.tlsConfig
 
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer cancel()
 
-	conn, _, err := dialer.DialContext(ctx, spotWsEndpoint, nil)
+	logger := client.logging.websocketLogger().With("url", spotWsEndpoint)
+
+	sent := time.Now()
+	conn, resp, err := dialer.DialContext(ctx, spotWsEndpoint, nil)
 	if err != nil {
+		logger.Error("websocket connect failed", "error", err)
 		return nil, err
 	}
+	logger.Debug("websocket connected", "latency", time.Since(sent))
+	// The handshake response refines the clock estimate before the login is signed
+	client.clock.observe(sent, time.Now(), resp)
 
 	wsConn := &WebsocketConn{
 		wsConn:        conn,
 		readDeadline:  DefaultTimeout,
 		writeDeadline: DefaultTimeout,
+		logger:        logger,
+		metrics:       client.getMetrics(),
 	}
 
 	err = wsConn.websocketLogin(client)
 	if err != nil {
+		logger.Error("websocket login failed", "error", err)
 		return nil, err
 	}
 
 	return wsConn, nil
 }
 
+// WithWebsocketURL sets the websocket endpoint, e.g. "ws://localhost:8080/ws" for a local stack without TLS
+func (client *ApiClient) WithWebsocketURL(websocketURL string) *ApiClient {
+	client.websocketURL = websocketURL
+	return client
+}
+
+// getWebsocketURL returns the configured websocket URL, or derives one from the api endpoint by switching the
+// scheme to ws/wss and appending /ws to its path
+func (client *ApiClient) getWebsocketURL() (string, error) {
+	if client.websocketURL != "" {
+		return client.websocketURL, nil
+	}
+
+	u, err := url.Parse(client.ApiEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the api endpoint %s: %s", client.ApiEndpoint, err.Error())
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("api endpoint %s has no host", client.ApiEndpoint)
+	}
+
+	scheme := "wss"
+	if u.Scheme == "http" {
+		scheme = "ws"
+	}
+
+	wsURL := url.URL{
+		Scheme: scheme,
+		Host:   u.Host,
+		Path:   strings.TrimSuffix(u.Path, "/") + "/ws",
+	}
+	return wsURL.String(), nil
+}
+
+// GetWebsocketLoginArgs returns the arguments for a websocket login message, or nil if the client has no
+// credentials or they can't be computed.
+//
+// Deprecated: use WebsocketLoginArgs, which returns the error.
 func (c *ApiClient) GetWebsocketLoginArgs() *RequestArgs {
-	if c.apiKeyArgs != nil {
-		timestamp, sig := c.computeApiKeyArgs("enclave_ws_login", "", nil)
-		return &RequestArgs{
-			KeyId:          c.apiKeyArgs.KeyId,
-			TimeUnixMillis: timestamp,
-			Sign:           sig,
-		}
-	} else {
+	args, err := c.WebsocketLoginArgs()
+	if err != nil {
 		return nil
 	}
+	return args
+}
+
+// WebsocketLoginArgs returns the arguments for a websocket login message, or nil if the client has no credentials
+func (c *ApiClient) WebsocketLoginArgs() (*RequestArgs, error) {
+	if c.jwt != nil {
+		token, err := c.jwt.current()
+		if err != nil {
+			return nil, err
+		}
+		return &RequestArgs{Token: token, SubaccountId: string(c.subaccountId)}, nil
+	}
+	if c.signer == nil {
+		return nil, nil
+	}
+	timestamp, sig, err := c.computeApiKeyArgs("enclave_ws_login", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &RequestArgs{
+		KeyId:          c.signer.KeyId(),
+		SubaccountId:   string(c.subaccountId),
+		TimeUnixMillis: timestamp,
+		Sign:           sig,
+	}, nil
 }
 
 func (c *WebsocketConn) SetReadDeadline(t time.Duration) {
@@ -110,7 +178,11 @@ func (c *WebsocketConn) SetWriteDeadline(t time.Duration) {
 	c.writeDeadline = t
 }
 
+// SendMessage writes req to the connection. It is safe to call from multiple goroutines.
 func (c *WebsocketConn) SendMessage(req WebSocketAPIRequest) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
 	var err error
 	if c.writeDeadline == 0 {
 		err = c.wsConn.SetWriteDeadline(time.Time{})
@@ -122,9 +194,23 @@ func (c *WebsocketConn) SendMessage(req WebSocketAPIRequest) error {
 	}
 	err = c.wsConn.WriteJSON(req)
 	_ = c.wsConn.SetWriteDeadline(time.Time{})
-	return err
+	if err != nil {
+		c.logger.Warn("websocket write failed", "op", req.Op, "channel", req.Channel, "error", err)
+		return err
+	}
+	if req.Op == Ping {
+		c.lastPing.Store(time.Now().UnixNano())
+	}
+	if req.Op == Subscribe || req.Op == Unsubscribe {
+		c.logger.Debug("websocket "+string(req.Op), "channel", req.Channel, "markets", req.Markets)
+	}
+	return nil
 }
 
+// ErrUndecodableMessage is wrapped by the error ReadMessage returns for a message it received but couldn't decode.
+// The connection is still usable.
+var ErrUndecodableMessage = errors.New("websocket message could not be decoded")
+
 func (c *WebsocketConn) ReadMessage() (*WebSocketAPIResponse, error) {
 	var err error
 	if c.readDeadline == 0 {
@@ -137,7 +223,12 @@ func (c *WebsocketConn) ReadMessage() (*WebSocketAPIResponse, error) {
 	}
 
 	_, p, err := c.wsConn.ReadMessage()
+	if IsCloseError(err) {
+		c.logger.Debug("websocket closed")
+		return nil, err
+	}
 	if err != nil {
+		c.logger.Warn("websocket read failed", "error", err)
 		return nil, err
 	}
 
@@ -148,13 +239,26 @@ func (c *WebsocketConn) ReadMessage() (*WebSocketAPIResponse, error) {
 
 	res, err := UnmarshalWebSocketAPIResponse(p)
 	if err != nil {
-		return nil, err
+		c.logger.Warn("websocket message could not be decoded", "error", err)
+		return nil, fmt.Errorf("%w: %w", ErrUndecodableMessage, err)
+	}
+	switch res.Type {
+	case Update:
+		c.metrics.IncWebsocketMessages(res.Channel)
+	case Pong:
+		if sent := c.lastPing.Swap(0); sent != 0 {
+			c.metrics.ObservePingRTT(time.Since(time.Unix(0, sent)))
+		}
+	case Error:
+		c.logger.Warn("websocket error message", "channel", res.Channel, "code", res.Code, "msg", res.Msg)
 	}
 
 	return res, nil
 }
 
 func (c *WebsocketConn) WriteCloseMessage() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
 	return c.wsConn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 }
 
@@ -177,28 +281,40 @@ func IsCloseError(err error) bool {
 }
 
 func (conn *WebsocketConn) websocketLogin(client *ApiClient) error {
-	// Skip login if no credentials are provided
-	args := client.GetWebsocketLoginArgs()
-	if args == nil {
-		return nil
-	}
-	req := WebSocketAPIRequest{Op: Login, Args: args}
-	err := conn.SendMessage(req)
-	if err != nil {
-		conn.wsConn.Close()
-		return fmt.Errorf("failed to write login message to websocket: %s", err.Error())
-	}
+	for attempt := 0; ; attempt++ {
+		// Skip login if no credentials are provided
+		args, err := client.WebsocketLoginArgs()
+		if err != nil {
+			conn.wsConn.Close()
+			return err
+		}
+		if args == nil {
+			return nil
+		}
+		req := WebSocketAPIRequest{Op: Login, Args: args}
+		err = conn.SendMessage(req)
+		if err != nil {
+			conn.wsConn.Close()
+			return fmt.Errorf("failed to write login message to websocket: %s", err.Error())
+		}
 
-	res, err := conn.ReadMessage()
-	if err != nil {
-		conn.wsConn.Close()
-		return fmt.Errorf("failed to read message from websocket: %s", err.Error())
-	}
+		res, err := conn.ReadMessage()
+		if err != nil {
+			conn.wsConn.Close()
+			return fmt.Errorf("failed to read message from websocket: %s", err.Error())
+		}
 
-	if res.Type != LoggedIn {
-		conn.wsConn.Close()
-		return fmt.Errorf("unexpected response to login message: %s %s", res.Type, res.Msg)
-	}
+		// A rejected JWT may have been revoked or expired early, so retry once with a fresh one
+		if res.Type == Error && args.IsJWTLogin() && attempt == 0 && client.jwt.invalidate(args.Token) {
+			continue
+		}
 
-	return nil
+		if res.Type != LoggedIn {
+			conn.wsConn.Close()
+			return fmt.Errorf("unexpected response to login message: %s %s", res.Type, res.Msg)
+		}
+
+		conn.logger.Debug("websocket logged in", "subaccount", args.SubaccountId)
+		return nil
+	}
 }
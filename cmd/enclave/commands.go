@@ -0,0 +1,499 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Enclave-Markets/enclave-go/apiclient"
+	"github.com/Enclave-Markets/enclave-go/models"
+	"github.com/shopspring/decimal"
+)
+
+func runMarkets(a *app, args []string) error {
+	res, err := a.client.Markets()
+	if err != nil {
+		return err
+	}
+
+	return a.out.print(res.Result, []string{"TYPE", "MARKET", "BASE INCREMENT", "QUOTE INCREMENT", "DISABLED"}, func() [][]string {
+		var rows [][]string
+		for _, pair := range res.Result.Spot.TradingPairs {
+			rows = append(rows, []string{"spot", string(pair.Market), pair.BaseIncrement.String(), pair.QuoteIncrement.String(), strconv.FormatBool(pair.Disabled)})
+		}
+		if res.Result.PerpetualFuture != nil {
+			for _, pair := range res.Result.PerpetualFuture.TradingPairs {
+				rows = append(rows, []string{"perps", string(pair.Market), pair.BaseIncrement.String(), pair.QuoteIncrement.String(), "false"})
+			}
+		}
+		return rows
+	})
+}
+
+func runContracts(a *app, args []string) error {
+	res, err := a.client.GetPerpsContracts()
+	if err != nil {
+		return err
+	}
+
+	contracts := res.Result
+	if len(args) > 0 {
+		contracts = nil
+		for _, contract := range res.Result {
+			if string(contract.Market) == args[0] {
+				contracts = append(contracts, contract)
+			}
+		}
+		if len(contracts) == 0 {
+			return fmt.Errorf("contract %s not found", args[0])
+		}
+	}
+
+	return a.out.print(contracts, []string{"MARKET", "STATUS", "LAST", "BID", "ASK", "FUNDING", "OPEN INTEREST"}, func() [][]string {
+		rows := make([][]string, 0, len(contracts))
+		for _, c := range contracts {
+			status := "open"
+			if c.IsClosed {
+				status = "closed"
+			}
+			if c.Disabled {
+				status = "disabled"
+			}
+			rows = append(rows, []string{string(c.Market), status, c.LastPrice, c.Bid, c.Ask, c.FundingRate, c.OpenInterest})
+		}
+		return rows
+	})
+}
+
+func runDepth(a *app, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	res, err := a.client.GetSpotDepthBook(models.Market(args[0]))
+	if err != nil {
+		return err
+	}
+
+	return a.out.print(res.Result, []string{"SIDE", "PRICE", "SIZE"}, func() [][]string {
+		var rows [][]string
+		for i := len(res.Result.Asks) - 1; i >= 0; i-- {
+			rows = append(rows, []string{"ask", res.Result.Asks[i].Price.String(), res.Result.Asks[i].Quantity.String()})
+		}
+		for _, level := range res.Result.Bids {
+			rows = append(rows, []string{"bid", level.Price.String(), level.Quantity.String()})
+		}
+		return rows
+	})
+}
+
+func runBalance(a *app, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	res, err := a.client.GetBalance(models.GetBalanceReq{Symbol: models.Symbol(args[0])})
+	if err != nil {
+		return err
+	}
+
+	b := res.Result
+	return a.out.print(b, []string{"SYMBOL", "TOTAL", "RESERVED", "FREE"}, func() [][]string {
+		return [][]string{{string(b.Symbol), b.TotalBalance, b.ReservedBalance, b.FreeBalance}}
+	})
+}
+
+func runOrder(a *app, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "place":
+		return runOrderPlace(a, args[1:])
+	case "get":
+		return runOrderGet(a, args[1:])
+	case "cancel":
+		return runOrderCancel(a, args[1:])
+	default:
+		return errUsage
+	}
+}
+
+func runOrderPlace(a *app, args []string) error {
+	fs := flag.NewFlagSet("order place", flag.ContinueOnError)
+	market := fs.String("market", "", "market to trade, e.g. AVAX-USDC")
+	side := fs.String("side", "", "buy or sell")
+	orderType := fs.String("type", "limit", "limit or market")
+	price := fs.String("price", "", "limit price")
+	size := fs.String("size", "", "order size in the base currency")
+	quoteSize := fs.String("quote-size", "", "order size in the quote currency, for market orders")
+	clientId := fs.String("client-id", "", "client order ID")
+	tif := fs.String("tif", "", "time in force: GTC or IOC")
+	postOnly := fs.Bool("post-only", false, "only add liquidity")
+	reduceOnly := fs.Bool("reduce-only", false, "only reduce a position (perps)")
+	perps := fs.Bool("perps", false, "place the order on a perps market")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if *market == "" {
+		return errors.New("--market is required")
+	}
+
+	req := models.AddOrderReq{
+		Market:        models.Market(*market),
+		ClientOrderID: models.OrderID(*clientId),
+		TimeInForce:   models.OrderTimeInForce(strings.ToUpper(*tif)),
+		PostOnly:      *postOnly,
+		ReduceOnly:    *reduceOnly,
+	}
+
+	switch strings.ToLower(*side) {
+	case "buy":
+		req.Side = models.Bid
+	case "sell":
+		req.Side = models.Ask
+	default:
+		return fmt.Errorf("--side must be buy or sell, got %q", *side)
+	}
+
+	switch strings.ToLower(*orderType) {
+	case "limit":
+		req.Type = models.OrderTypeLimit
+	case "market":
+		req.Type = models.OrderTypeMarket
+	default:
+		return fmt.Errorf("--type must be limit or market, got %q", *orderType)
+	}
+
+	var err error
+	if req.Price, err = parseDecimal("price", *price); err != nil {
+		return err
+	}
+	if req.Size, err = parseDecimal("size", *size); err != nil {
+		return err
+	}
+	if req.QuoteSize, err = parseDecimal("quote-size", *quoteSize); err != nil {
+		return err
+	}
+
+	var res *models.GenericResponse[models.ApiOrder]
+	if *perps {
+		res, err = a.client.AddPerpsOrder(req)
+	} else {
+		res, err = a.client.AddSpotOrder(req)
+	}
+	if err != nil {
+		return err
+	}
+
+	return a.printOrders([]models.ApiOrder{res.Result})
+}
+
+func runOrderGet(a *app, args []string) error {
+	fs := flag.NewFlagSet("order get", flag.ContinueOnError)
+	byClientId := fs.Bool("client", false, "treat the ID as a client order ID")
+	perps := fs.Bool("perps", false, "get a perps order")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	id := fs.Arg(0)
+
+	var res *models.GenericResponse[models.ApiOrder]
+	var err error
+	switch {
+	case *perps && !*byClientId:
+		return errors.New("perps orders can only be looked up by client order ID, use --client")
+	case *perps:
+		res, err = a.client.GetPerpsOrderByClientID(models.ClientOrderID(id))
+	case *byClientId:
+		res, err = a.client.GetSpotOrderByClientID(models.OrderID(id))
+	default:
+		res, err = a.client.GetSpotOrder(models.OrderID(id))
+	}
+	if err != nil {
+		return err
+	}
+
+	return a.printOrders([]models.ApiOrder{res.Result})
+}
+
+func runOrderCancel(a *app, args []string) error {
+	fs := flag.NewFlagSet("order cancel", flag.ContinueOnError)
+	byClientId := fs.Bool("client", false, "treat the IDs as client order IDs")
+	all := fs.Bool("all", false, "cancel all open orders, optionally only on --market")
+	market := fs.String("market", "", "market to cancel all orders on")
+	perps := fs.Bool("perps", false, "cancel perps orders")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	switch {
+	case *all && *perps:
+		if *market == "" {
+			return errors.New("--market is required to cancel all perps orders")
+		}
+		return a.client.CancelAllPerpsOrdersOnMarket(models.Market(*market))
+	case *all && *market != "":
+		return a.client.CancelAllSpotOrdersOnMarket(models.Market(*market))
+	case *all:
+		return a.client.CancelAllSpotOrders()
+	}
+
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+
+	if *perps {
+		if !*byClientId {
+			return errors.New("perps orders can only be cancelled by client order ID, use --client")
+		}
+		ids := make([]models.ClientOrderID, 0, fs.NArg())
+		for _, id := range fs.Args() {
+			ids = append(ids, models.ClientOrderID(id))
+		}
+		res, err := a.client.CancelPerpsOrdersByClientId(ids)
+		if err != nil {
+			return err
+		}
+		return a.out.printJSON(res.Result)
+	}
+
+	for _, id := range fs.Args() {
+		var err error
+		if *byClientId {
+			_, err = a.client.CancelSpotOrderByClientID(models.OrderID(id))
+		} else {
+			_, err = a.client.CancelSpotOrder(models.OrderID(id))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *app) printOrders(orders []models.ApiOrder) error {
+	return a.out.print(orders, []string{"ORDER ID", "CLIENT ID", "MARKET", "SIDE", "TYPE", "PRICE", "SIZE", "FILLED", "STATUS"}, func() [][]string {
+		rows := make([][]string, 0, len(orders))
+		for _, o := range orders {
+			rows = append(rows, []string{
+				string(o.OrderID), string(o.ClientOrderID), string(o.Market), o.SideString(), o.TypeString(),
+				o.Price.String(), o.OrderQuantity.String(), o.FilledQuantity.String(), o.State.String(),
+			})
+		}
+		return rows
+	})
+}
+
+func runFills(a *app, args []string) error {
+	fs := flag.NewFlagSet("fills", flag.ContinueOnError)
+	market := fs.String("market", "", "only show fills on this market")
+	limit := fs.Int("limit", 0, "page size")
+	cursor := fs.String("cursor", "", "page cursor returned by a previous call")
+	start := fs.String("start", "", "only show fills at or after this RFC3339 time")
+	end := fs.String("end", "", "only show fills before this RFC3339 time")
+	all := fs.Bool("all", false, "follow cursors until every page is fetched")
+	perps := fs.Bool("perps", false, "show perps fills")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	getFills := a.client.GetSpotFills
+	if *perps {
+		getFills = a.client.GetPerpsFills
+	}
+
+	params := models.FillParams{Market: *market, Limit: *limit, Cursor: *cursor}
+	var err error
+	if params.StartTime, err = parseTime("start", *start); err != nil {
+		return err
+	}
+	if params.EndTime, err = parseTime("end", *end); err != nil {
+		return err
+	}
+
+	var fills []*models.ApiFill
+	var nextCursor string
+	for {
+		res, err := getFills(params)
+		if err != nil {
+			return err
+		}
+		fills = append(fills, res.Result...)
+		nextCursor = res.PageInfo.NextCursor
+
+		if !*all || nextCursor == "" {
+			break
+		}
+		params.Cursor = nextCursor
+	}
+
+	if a.out.json {
+		return a.out.printJSON(models.V1PageRes[models.ApiFill]{
+			Result:   fills,
+			PageInfo: models.APIPageInfo{NextCursor: nextCursor},
+		})
+	}
+
+	err = a.out.print(fills, []string{"TIME", "FILL ID", "ORDER ID", "MARKET", "SIDE", "PRICE", "SIZE", "FEE"}, func() [][]string {
+		rows := make([][]string, 0, len(fills))
+		for _, f := range fills {
+			rows = append(rows, []string{
+				f.CreatedAt.Format(time.RFC3339), string(f.FillID), string(f.OrderID), string(f.Market),
+				f.SideString(), f.Price.String(), f.Size.String(), f.Fee.String(),
+			})
+		}
+		return rows
+	})
+	if err != nil {
+		return err
+	}
+
+	if nextCursor != "" && !*all {
+		fmt.Fprintln(os.Stderr, "next cursor:", nextCursor)
+	}
+	return nil
+}
+
+func runExport(a *app, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "csv", "file format: csv or jsonl")
+	lots := fs.String("lots", "", "export realized gains per disposal matched by fifo, lifo or average cost instead of fills")
+	market := fs.String("market", "", "only export fills on this market")
+	start := fs.String("start", "", "only export fills at or after this RFC3339 time")
+	end := fs.String("end", "", "only export fills before this RFC3339 time")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if *format != "csv" && *format != "jsonl" {
+		return fmt.Errorf("unknown export format: %s", *format)
+	}
+	if *lots != "" && *start != "" {
+		// Lots opened by buys before the start would be missing, leaving later sells without a cost basis
+		return errors.New("--lots needs the full fill history and can't be combined with --start")
+	}
+
+	params := models.FillParams{Market: *market}
+	var err error
+	if params.StartTime, err = parseTime("start", *start); err != nil {
+		return err
+	}
+	if params.EndTime, err = parseTime("end", *end); err != nil {
+		return err
+	}
+
+	fills, err := a.client.FillHistory(context.Background(), params)
+	if err != nil {
+		return err
+	}
+
+	if *lots == "" {
+		if *format == "jsonl" {
+			return apiclient.WriteFillsJSONL(a.out.w, fills)
+		}
+		return apiclient.WriteFillsCSV(a.out.w, fills)
+	}
+
+	method, err := apiclient.ParseLotMethod(*lots)
+	if err != nil {
+		return err
+	}
+	disposals, _, err := apiclient.MatchLots(fills, method)
+	if err != nil {
+		return err
+	}
+	if *format == "csv" {
+		return apiclient.WriteDisposalsCSV(a.out.w, disposals)
+	}
+	for _, disposal := range disposals {
+		if err := a.out.printLine(disposal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runWs(a *app, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	markets := make([]models.Market, 0, len(args)-1)
+	for _, m := range args[1:] {
+		markets = append(markets, models.Market(m))
+	}
+
+	conn, err := a.client.NewWebsocketConnection()
+	if err != nil {
+		return fmt.Errorf("failed to connect to websocket: %w", err)
+	}
+	defer conn.Close()
+
+	err = conn.SendMessage(apiclient.WebSocketAPIRequest{
+		Op:      apiclient.Subscribe,
+		Channel: apiclient.ChannelType(args[0]),
+		Markets: markets,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		_ = conn.WriteCloseMessage()
+		_ = conn.Close()
+	}()
+
+	// Updates can be arbitrarily far apart, so never time out reads
+	conn.SetReadDeadline(0)
+	for {
+		res, err := conn.ReadMessage()
+		if apiclient.IsCloseError(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if res.Type == apiclient.Error {
+			return fmt.Errorf("websocket error %d: %s", res.Code, res.Msg)
+		}
+		if err := a.out.printLine(res); err != nil {
+			return err
+		}
+	}
+}
+
+func parseDecimal(name string, s string) (decimal.Decimal, error) {
+	if s == "" {
+		return decimal.Zero, nil
+	}
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("invalid --%s %q: %w", name, s, err)
+	}
+	return d, nil
+}
+
+func parseTime(name string, s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s %q: %w", name, s, err)
+	}
+	return &t, nil
+}
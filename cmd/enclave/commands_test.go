@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Enclave-Markets/enclave-go/apiclient"
+)
+
+// testApp runs commands against a server that answers every path in routes with the given JSON body and records
+// the escaped path of each request.
+type testApp struct {
+	*app
+	out *bytes.Buffer
+
+	mu    sync.Mutex
+	paths []string
+}
+
+func newTestApp(t *testing.T, routes map[string]string) *testApp {
+	t.Helper()
+
+	ta := &testApp{out: &bytes.Buffer{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ta.mu.Lock()
+		ta.paths = append(ta.paths, r.URL.EscapedPath())
+		ta.mu.Unlock()
+
+		body, ok := routes[r.URL.EscapedPath()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	ta.app = &app{client: apiclient.NewApiClient(srv.URL).WithApiKey("key", "secret"), out: &printer{w: ta.out}}
+	return ta
+}
+
+func (ta *testApp) requested() []string {
+	ta.mu.Lock()
+	defer ta.mu.Unlock()
+	return append([]string(nil), ta.paths...)
+}
+
+const orderBody = `{"success":true,"result":{"orderId":"o1","clientOrderId":"my-order","market":"BTC-USD.P","side":"buy","status":"open"}}`
+
+func TestOrderGet(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		path string
+	}{
+		{"spot by order id", []string{"o1"}, "/v1/orders/o1"},
+		{"spot by client id", []string{"--client", "my-order"}, "/v1/orders/client:my-order"},
+		{"perps by client id", []string{"--perps", "--client", "my-order"}, "/v1/perps/orders/client:my-order"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ta := newTestApp(t, map[string]string{tt.path: orderBody})
+			if err := runOrder(ta.app, append([]string{"get"}, tt.args...)); err != nil {
+				t.Fatalf("order get: %v", err)
+			}
+			if got := ta.requested(); len(got) != 1 || got[0] != tt.path {
+				t.Fatalf("requested %v, want [%s]", got, tt.path)
+			}
+			if !strings.Contains(ta.out.String(), "o1") {
+				t.Fatalf("output does not contain the order:\n%s", ta.out.String())
+			}
+		})
+	}
+}
+
+func TestOrderGetPerpsRequiresClientID(t *testing.T) {
+	ta := newTestApp(t, nil)
+	if err := runOrder(ta.app, []string{"get", "--perps", "o1"}); err == nil {
+		t.Fatal("expected an error for a perps lookup by order ID")
+	}
+	if got := ta.requested(); len(got) != 0 {
+		t.Fatalf("requested %v, want no requests", got)
+	}
+}
+
+func TestOrderGetUsage(t *testing.T) {
+	ta := newTestApp(t, nil)
+	if err := runOrder(ta.app, []string{"get"}); err != errUsage {
+		t.Fatalf("got %v, want errUsage", err)
+	}
+}
+
+func TestFills(t *testing.T) {
+	fillsBody := `{"success":true,"result":[{"id":"f1","orderId":"o1","market":"BTC-USD.P","side":"buy","price":"100","size":"1","fee":"0.1"}],"pageInfo":{}}`
+
+	tests := []struct {
+		name string
+		args []string
+		path string
+	}{
+		{"spot", nil, "/v1/fills"},
+		{"perps", []string{"--perps"}, "/v1/perps/fills"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ta := newTestApp(t, map[string]string{tt.path: fillsBody})
+			if err := runFills(ta.app, tt.args); err != nil {
+				t.Fatalf("fills: %v", err)
+			}
+			if got := ta.requested(); len(got) != 1 || got[0] != tt.path {
+				t.Fatalf("requested %v, want [%s]", got, tt.path)
+			}
+			if !strings.Contains(ta.out.String(), "f1") {
+				t.Fatalf("output does not contain the fill:\n%s", ta.out.String())
+			}
+		})
+	}
+}
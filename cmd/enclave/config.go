@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Enclave-Markets/enclave-go/apiclient"
+)
+
+// config is the on-disk CLI configuration, by default stored at ~/.config/enclave/config.json:
+//
+//	{
+//	  "env": "sandbox",
+//	  "credentials": {
+//	    "sandbox": {"keyId": "...", "keySecret": "..."},
+//	    "prod": {"keyId": "...", "keyFile": "~/.config/enclave/prod.key"}
+//	  },
+//	  "environments": {
+//	    "local": {"apiEndpoint": "https://localhost:8443", "tlsPolicy": "insecure"}
+//	  }
+//	}
+type config struct {
+	Env          string                           `json:"env"`
+	Credentials  map[string]credentials           `json:"credentials"`
+	Environments map[string]apiclient.Environment `json:"environments"`
+}
+
+// credentials hold either the key secret itself or the path to a file containing it
+type credentials struct {
+	KeyId     string `json:"keyId"`
+	KeySecret string `json:"keySecret,omitempty"`
+	KeyFile   string `json:"keyFile,omitempty"`
+}
+
+func defaultConfigPath() string {
+	if path := os.Getenv("ENCLAVE_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "enclave", "config.json")
+}
+
+// loadConfig reads the config file at path. A missing file is not an error.
+func loadConfig(path string) (*config, error) {
+	cfg := &config{Credentials: map[string]credentials{}}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// newClient creates a client for env, or the configured env if empty. Credentials from the environment
+// variables take precedence over the config file.
+func (cfg *config) newClient(env string) (*apiclient.ApiClient, error) {
+	if env == "" {
+		env = cfg.Env
+	}
+	if env == "" {
+		env = "sandbox"
+	}
+
+	for name, environment := range cfg.Environments {
+		environment.Name = name
+		if err := apiclient.RegisterEnvironment(environment); err != nil {
+			return nil, err
+		}
+	}
+
+	client, err := apiclient.NewApiClientFromEnv(env)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := cfg.Credentials[env]
+	if key := os.Getenv("ENCLAVE_KEY"); key != "" {
+		creds = credentials{KeyId: key, KeySecret: os.Getenv("ENCLAVE_SECRET")}
+	}
+	switch {
+	case creds.KeyId == "":
+	case creds.KeyFile != "":
+		signer, err := apiclient.NewFileSigner(creds.KeyId, expandHome(creds.KeyFile))
+		if err != nil {
+			return nil, err
+		}
+		client.WithSigner(signer)
+	default:
+		client.WithApiKey(creds.KeyId, creds.KeySecret)
+	}
+
+	return client, nil
+}
+
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
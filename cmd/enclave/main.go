@@ -0,0 +1,114 @@
+// Command enclave is a command-line client for the Enclave Markets API.
+//
+// Usage:
+//
+//	enclave [global flags] <command> [command flags] [args]
+//
+// Credentials are read from the ENCLAVE_KEY and ENCLAVE_SECRET environment variables, falling back to the
+// config file (see --config).
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/Enclave-Markets/enclave-go/apiclient"
+	"github.com/Enclave-Markets/enclave-go/models"
+)
+
+type command struct {
+	usage string
+	run   func(app *app, args []string) error
+}
+
+var commands = map[string]command{
+	"markets":   {"markets", runMarkets},
+	"contracts": {"contracts [market]", runContracts},
+	"depth":     {"depth <market>", runDepth},
+	"balance":   {"balance <symbol>", runBalance},
+	"order":     {"order place|get|cancel ...", runOrder},
+	"fills":     {"fills [--perps] [--market m] [--limit n] [--cursor c] [--start t] [--end t] [--all]", runFills},
+	"export":    {"export [--format csv|jsonl] [--lots fifo|lifo|average] [--market m] [--start t] [--end t]", runExport},
+	"ws":        {"ws <channel> [market...]", runWs},
+}
+
+type app struct {
+	client *apiclient.ApiClient
+	out    *printer
+}
+
+var errUsage = errors.New("usage")
+
+func main() {
+	global := flag.NewFlagSet("enclave", flag.ContinueOnError)
+	env := global.String("env", "", "environment to target: sandbox, prod or one defined in the config (default from config, else sandbox)")
+	output := global.String("output", "table", "output format: table or json")
+	configPath := global.String("config", defaultConfigPath(), "path to the config file")
+	subaccount := global.String("subaccount", "", "subaccount to act on instead of the main account")
+	global.Usage = func() { usage(global) }
+
+	if err := global.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+	if global.NArg() == 0 {
+		usage(global)
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[global.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", global.Arg(0))
+		usage(global)
+		os.Exit(2)
+	}
+
+	out, err := newPrinter(*output, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	client, err := cfg.newClient(*env)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *subaccount != "" {
+		client = client.ForSubaccount(models.SubaccountID(*subaccount))
+	}
+
+	err = cmd.run(&app{client: client, out: out}, global.Args()[1:])
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "usage: enclave %s\n", cmd.usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage(global *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, "usage: enclave [global flags] <command> [args]")
+	fmt.Fprintln(os.Stderr, "\nglobal flags:")
+	global.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+}
@@ -5,5 +5,6 @@ go 1.21
 require (
 	github.com/gorilla/websocket v1.5.3
 	github.com/shopspring/decimal v1.4.0
+	golang.org/x/crypto v0.32.0
 	golang.org/x/time v0.5.0
 )
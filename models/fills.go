@@ -1,8 +1,9 @@
 package models
 
 import (
-	"fmt"
-	"strings"
+	"encoding/json"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -27,31 +28,33 @@ func (fp *FillParams) GetFillPathParams() string {
 		return ""
 	}
 
-	pathParams := "?"
+	params := url.Values{}
 
 	if fp.StartTime != nil {
-		pathParams += fmt.Sprintf("startTime=%d&", fp.StartTime.UnixMilli())
+		params.Set("startTime", strconv.FormatInt(fp.StartTime.UnixMilli(), 10))
 	}
 
 	if fp.EndTime != nil {
-		pathParams += fmt.Sprintf("endTime=%d&", fp.EndTime.UnixMilli())
+		params.Set("endTime", strconv.FormatInt(fp.EndTime.UnixMilli(), 10))
 	}
 
 	if fp.Market != "" {
-		pathParams += fmt.Sprintf("market=%s&", fp.Market)
+		params.Set("market", fp.Market)
 	}
 
 	if fp.Limit > 0 {
-		pathParams += fmt.Sprintf("limit=%d&", fp.Limit)
+		params.Set("limit", strconv.Itoa(fp.Limit))
 	}
 
 	if fp.Cursor != "" {
-		pathParams += fmt.Sprintf("cursor=%s&", fp.Cursor)
+		params.Set("cursor", fp.Cursor)
 	}
 
-	pathParams = strings.TrimSuffix(pathParams, "&")
+	if len(params) == 0 {
+		return ""
+	}
 
-	return pathParams
+	return "?" + params.Encode()
 }
 
 type ApiFill struct {
@@ -68,4 +71,44 @@ type ApiFill struct {
 	CreatedAt     time.Time        `json:"time"`
 	ADL           *bool            `json:"isADL,omitempty"`
 	RealizedPNL   *decimal.Decimal `json:"pnl,omitempty"`
+
+	// The side as sent, if it is one this version of the SDK doesn't know. Side is then Ask and must not be used.
+	UnknownSide string `json:"-"`
+}
+
+// SideString returns the side as sent, including a side this version of the SDK doesn't know
+func (f *ApiFill) SideString() string {
+	if f.UnknownSide != "" {
+		return f.UnknownSide
+	}
+	return f.Side.String()
+}
+
+// UnmarshalJSON decodes a fill, keeping a side this version of the SDK doesn't know in UnknownSide rather than
+// failing
+func (f *ApiFill) UnmarshalJSON(data []byte) error {
+	type plain ApiFill
+	v := struct {
+		*plain
+		Side json.RawMessage `json:"side"`
+	}{plain: (*plain)(f)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	var err error
+	f.UnknownSide, err = decodeEnum(v.Side, &f.Side)
+	return err
+}
+
+// MarshalJSON encodes a fill with its side as sent
+func (f ApiFill) MarshalJSON() ([]byte, error) {
+	type plain ApiFill
+	if f.UnknownSide == "" {
+		return json.Marshal(plain(f))
+	}
+	return json.Marshal(struct {
+		plain
+		Side string `json:"side"`
+	}{plain(f), f.UnknownSide})
 }
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -29,6 +31,8 @@ const (
 	V1PerpsOrdersPath      = "/v1/perps/orders"
 	V1PerpsBatchOrdersPath = "/v1/perps/orders/batch"
 	V1PerpsContractsPath   = "/v1/perps/contracts"
+	V1PerpsPositionsPath   = "/v1/perps/positions"
+	V1PerpsFillsPath       = "/v1/perps/fills"
 
 	// Cross
 	V0PricePath = "/v0/price"
@@ -56,6 +60,24 @@ type GenericResponse[T any] struct {
 	Error   string `json:"error,omitempty"`
 }
 
+// Response is implemented by API responses that report success in their body
+type Response interface {
+	IsSuccess() bool
+	// ResponseError returns the error reported by an unsuccessful response, nil otherwise
+	ResponseError() error
+}
+
+func (r *GenericResponse[T]) IsSuccess() bool {
+	return r.Success
+}
+
+func (r *GenericResponse[T]) ResponseError() error {
+	if r.Success {
+		return nil
+	}
+	return errors.New(r.Error)
+}
+
 type V0GetBalanceRes struct {
 	// the account ID of the user that made the request
 	// example:5577006791947779410
@@ -116,6 +138,14 @@ type ApiPosition struct {
 	TakeProfitTriggerPrice *decimal.Decimal `json:"takeProfitTriggerPrice,omitempty"`
 }
 
+// SignedQuantity returns the position size, negative for short positions
+func (p ApiPosition) SignedQuantity() decimal.Decimal {
+	if strings.EqualFold(p.Direction, "short") && p.NetQuantity.IsPositive() {
+		return p.NetQuantity.Neg()
+	}
+	return p.NetQuantity
+}
+
 type ApiBookSnapshots []*ApiBookSnapshot
 
 type ApiBookSnapshot struct {
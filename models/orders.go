@@ -1,7 +1,11 @@
 package models
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -61,6 +65,47 @@ type AddOrderReq struct {
 	PostOnly bool `json:"postOnly,omitempty"`
 }
 
+type OrderParams struct {
+	Market string
+	Status string
+	Limit  int
+	Cursor string
+}
+
+func (op *OrderParams) IsEmpty() bool {
+	return op.Market == "" && op.Status == "" && op.Limit == 0 && op.Cursor == ""
+}
+
+func (op *OrderParams) GetOrderPathParams() string {
+	if op.IsEmpty() {
+		return ""
+	}
+
+	params := url.Values{}
+
+	if op.Market != "" {
+		params.Set("market", op.Market)
+	}
+
+	if op.Status != "" {
+		params.Set("status", op.Status)
+	}
+
+	if op.Limit > 0 {
+		params.Set("limit", strconv.Itoa(op.Limit))
+	}
+
+	if op.Cursor != "" {
+		params.Set("cursor", op.Cursor)
+	}
+
+	if len(params) == 0 {
+		return ""
+	}
+
+	return "?" + params.Encode()
+}
+
 type BidAsk bool
 
 const (
@@ -87,6 +132,9 @@ func (b *BidAsk) UnmarshalJSON(data []byte) error {
 	case `"sell"`:
 		*b = Ask
 	default:
+		if isJSONString(data) {
+			return &UnknownValueError{Type: "bid/ask", Value: string(data)}
+		}
 		return fmt.Errorf("invalid bid/ask: %s", string(data))
 	}
 	return nil
@@ -130,11 +178,28 @@ func (o *OrderType) UnmarshalJSON(data []byte) error {
 	case `"limit"`:
 		*o = OrderTypeLimit
 	default:
+		if isJSONString(data) {
+			return &UnknownValueError{Type: "order type", Value: string(data)}
+		}
 		return fmt.Errorf("invalid order type: %s", string(data))
 	}
 	return nil
 }
 
+// UnknownValueError is returned when decoding a side or order type this version of the SDK doesn't know about.
+// BidAsk and OrderType have no room for an unknown value, so unlike the other enums they can't decode one on their
+// own. ApiOrder and ApiFill keep such a value as sent in their UnknownSide and UnknownType fields instead.
+type UnknownValueError struct {
+	Type string
+
+	// The value as sent, a JSON string
+	Value string
+}
+
+func (e *UnknownValueError) Error() string {
+	return fmt.Sprintf("unknown %s: %s", e.Type, e.Value)
+}
+
 type OrderTimeInForce string
 
 const (
@@ -162,6 +227,68 @@ type ApiOrder struct {
 	Type         OrderType        `json:"type"`
 	TimeInForce  OrderTimeInForce `json:"timeInForce,omitempty"`
 	ReduceOnly   bool             `json:"reduceOnly,omitempty"`
+
+	// The side and order type as sent, if they are ones this version of the SDK doesn't know. Side and Type are then
+	// their zero values and must not be used.
+	UnknownSide string `json:"-"`
+	UnknownType string `json:"-"`
+}
+
+// SideString returns the side as sent, including a side this version of the SDK doesn't know
+func (o *ApiOrder) SideString() string {
+	if o.UnknownSide != "" {
+		return o.UnknownSide
+	}
+	return o.Side.String()
+}
+
+// TypeString returns the order type as sent, including a type this version of the SDK doesn't know
+func (o *ApiOrder) TypeString() string {
+	if o.UnknownType != "" {
+		return o.UnknownType
+	}
+	return o.Type.String()
+}
+
+// UnmarshalJSON decodes an order, keeping a side or order type this version of the SDK doesn't know in UnknownSide
+// or UnknownType rather than failing
+func (o *ApiOrder) UnmarshalJSON(data []byte) error {
+	type plain ApiOrder
+	v := struct {
+		*plain
+		Side json.RawMessage `json:"side"`
+		Type json.RawMessage `json:"type"`
+	}{plain: (*plain)(o)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	var err error
+	if o.UnknownSide, err = decodeEnum(v.Side, &o.Side); err != nil {
+		return err
+	}
+	o.UnknownType, err = decodeEnum(v.Type, &o.Type)
+	return err
+}
+
+// MarshalJSON encodes an order with its side and order type as sent
+func (o ApiOrder) MarshalJSON() ([]byte, error) {
+	type plain ApiOrder
+	if o.UnknownSide == "" && o.UnknownType == "" {
+		return json.Marshal(plain(o))
+	}
+	v := struct {
+		plain
+		Side any `json:"side"`
+		Type any `json:"type"`
+	}{plain: plain(o), Side: o.Side, Type: o.Type}
+	if o.UnknownSide != "" {
+		v.Side = o.UnknownSide
+	}
+	if o.UnknownType != "" {
+		v.Type = o.UnknownType
+	}
+	return json.Marshal(v)
 }
 
 type CancelReason int
@@ -195,6 +322,10 @@ const (
 	CancelByAdmin
 )
 
+// UnknownCancelReason is decoded from cancel reasons this version of the SDK doesn't know about, and from a null
+// reason. A user cancel is sent as an empty reason.
+const UnknownCancelReason CancelReason = -1
+
 func (s CancelReason) String() string {
 	switch s {
 	case User:
@@ -216,7 +347,7 @@ func (s CancelReason) String() string {
 	case CancelByAdmin:
 		return "cancelByAdmin"
 	default:
-		panic(fmt.Sprintf("invalid CancelReason: %d", s))
+		return "unknown"
 	}
 }
 
@@ -226,8 +357,10 @@ func (s CancelReason) MarshalJSON() ([]byte, error) {
 
 func (s *CancelReason) UnmarshalJSON(data []byte) error {
 	switch strings.ToLower(string(data)) {
-	case "":
+	case "", `""`:
 		*s = User
+	case "null":
+		*s = UnknownCancelReason
 	case `"liquidation"`:
 		*s = Liquidation
 	case `"selfmatchprevention"`:
@@ -245,7 +378,10 @@ func (s *CancelReason) UnmarshalJSON(data []byte) error {
 	case `"cancelbyadmin"`:
 		*s = CancelByAdmin
 	default:
-		return fmt.Errorf("invalid CancelReason: %s", string(data))
+		if !isJSONString(data) {
+			return fmt.Errorf("invalid CancelReason: %s", string(data))
+		}
+		*s = UnknownCancelReason
 	}
 	return nil
 }
@@ -275,6 +411,9 @@ const (
 	Rejected
 )
 
+// UnknownOrderState is decoded from order states this version of the SDK doesn't know about
+const UnknownOrderState OrderState = -1
+
 func (s OrderState) String() string {
 	switch s {
 	case New:
@@ -331,7 +470,33 @@ func (s *OrderState) UnmarshalJSON(data []byte) error {
 	case `"rejected"`:
 		*s = Rejected
 	default:
-		return fmt.Errorf("invalid OrderState: %s", string(data))
+		if !isJSONString(data) {
+			return fmt.Errorf("invalid OrderState: %s", string(data))
+		}
+		*s = UnknownOrderState
 	}
 	return nil
 }
+
+// decodeEnum decodes data into v if it is present. A value this version of the SDK doesn't know is returned as sent,
+// leaving v unchanged, rather than as an error.
+func decodeEnum(data json.RawMessage, v json.Unmarshaler) (string, error) {
+	if data == nil {
+		return "", nil
+	}
+	err := v.UnmarshalJSON(data)
+	var unknown *UnknownValueError
+	if errors.As(err, &unknown) {
+		var value string
+		_ = json.Unmarshal(data, &value)
+		return value, nil
+	}
+	return "", err
+}
+
+// isJSONString reports whether data is a JSON string, so that new enum values sent by the server can be told apart
+// from malformed data
+func isJSONString(data []byte) bool {
+	var str string
+	return json.Unmarshal(data, &str) == nil
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCancelReasonJSON(t *testing.T) {
+	decode := []struct {
+		data string
+		want CancelReason
+	}{
+		{`""`, User},
+		{`"liquidation"`, Liquidation},
+		{`"selfMatchPrevention"`, SelfMatchPrevention},
+		{`"cancelByAdmin"`, CancelByAdmin},
+		{`null`, UnknownCancelReason},
+		{`"somethingNew"`, UnknownCancelReason},
+	}
+	for _, tt := range decode {
+		var got CancelReason
+		if err := json.Unmarshal([]byte(tt.data), &got); err != nil {
+			t.Fatalf("decode %s: %v", tt.data, err)
+		}
+		if got != tt.want {
+			t.Errorf("decode %s = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+
+	var reason CancelReason
+	if err := json.Unmarshal([]byte(`1`), &reason); err == nil {
+		t.Error("decoded a number as a cancel reason")
+	}
+
+	encode := map[CancelReason]string{
+		Liquidation:         "liquidation",
+		SelfMatchPrevention: "selfmatchprevention",
+		UnknownCancelReason: "unknown",
+	}
+	for reason, want := range encode {
+		data, err := json.Marshal(reason)
+		if err != nil {
+			t.Fatalf("encode %v: %v", reason, err)
+		}
+		if string(data) != `"`+want+`"` {
+			t.Errorf("encode %d = %s, want %q", reason, data, want)
+		}
+	}
+	if data, _ := json.Marshal(User); string(data) != `""` {
+		t.Errorf("user cancel encoded as %s, want an empty reason", data)
+	}
+}
+
+func TestBidAskAndOrderTypeJSON(t *testing.T) {
+	req := AddOrderReq{Side: Bid, Type: OrderTypeMarket, Market: "AVAX-USDC"}
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded AddOrderReq
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+	if decoded.Side != Bid || decoded.Type != OrderTypeMarket {
+		t.Fatalf("round trip of %s = %v %v", data, decoded.Side, decoded.Type)
+	}
+
+	for _, tt := range []struct {
+		data string
+		want any
+	}{
+		{`"BUY"`, Bid},
+		{`"sell"`, Ask},
+	} {
+		var side BidAsk
+		if err := json.Unmarshal([]byte(tt.data), &side); err != nil || side != tt.want {
+			t.Errorf("decode %s = %v, %v", tt.data, side, err)
+		}
+	}
+	var orderType OrderType
+	if err := json.Unmarshal([]byte(`"limit"`), &orderType); err != nil || orderType != OrderTypeLimit {
+		t.Errorf(`decode "limit" = %v, %v`, orderType, err)
+	}
+
+	var unknown *UnknownValueError
+	var side BidAsk
+	if err := json.Unmarshal([]byte(`"short"`), &side); !errors.As(err, &unknown) || unknown.Type != "bid/ask" {
+		t.Errorf(`decode "short" error = %v, want an UnknownValueError`, err)
+	}
+	if err := json.Unmarshal([]byte(`"stop"`), &orderType); !errors.As(err, &unknown) || unknown.Type != "order type" {
+		t.Errorf(`decode "stop" error = %v, want an UnknownValueError`, err)
+	}
+	if err := json.Unmarshal([]byte(`true`), &side); err == nil || errors.As(err, &unknown) {
+		t.Errorf("decode true error = %v, want an invalid bid/ask error", err)
+	}
+}
+
+func TestOrderStateJSON(t *testing.T) {
+	var state OrderState
+	if err := json.Unmarshal([]byte(`"fullyFilled"`), &state); err != nil || state != FullyFilled {
+		t.Errorf(`decode "fullyFilled" = %v, %v`, state, err)
+	}
+	if err := json.Unmarshal([]byte(`"expired"`), &state); err != nil || state != UnknownOrderState {
+		t.Errorf(`decode "expired" = %v, %v`, state, err)
+	}
+	if err := json.Unmarshal([]byte(`3`), &state); err == nil {
+		t.Error("decoded a number as an order state")
+	}
+	if data, _ := json.Marshal(FullyFilled); string(data) != `"fullyfilled"` {
+		t.Errorf("encode FullyFilled = %s", data)
+	}
+}
+
+func TestPathParamsEscapeValues(t *testing.T) {
+	orders := OrderParams{Market: "AVAX-USDC", Status: "open", Cursor: "a+b/c=="}
+	if got, want := orders.GetOrderPathParams(), "?cursor=a%2Bb%2Fc%3D%3D&market=AVAX-USDC&status=open"; got != want {
+		t.Errorf("order params = %q, want %q", got, want)
+	}
+
+	start := time.UnixMilli(1700000000000)
+	fills := FillParams{StartTime: &start, Market: "A&B", Limit: 10, Cursor: "x+y="}
+	if got, want := fills.GetFillPathParams(), "?cursor=x%2By%3D&limit=10&market=A%26B&startTime=1700000000000"; got != want {
+		t.Errorf("fill params = %q, want %q", got, want)
+	}
+
+	if got := (&OrderParams{}).GetOrderPathParams(); got != "" {
+		t.Errorf("empty order params = %q", got)
+	}
+}
+
+func TestApiOrderKeepsUnknownSideAndType(t *testing.T) {
+	var order ApiOrder
+	if err := json.Unmarshal([]byte(`{"orderId":"o1","side":"short","type":"stop","status":"open"}`), &order); err != nil {
+		t.Fatal(err)
+	}
+	if order.UnknownSide != "short" || order.UnknownType != "stop" || order.SideString() != "short" || order.TypeString() != "stop" {
+		t.Fatalf("order = %+v, want the unknown side and type kept", order)
+	}
+
+	data, err := json.Marshal(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ApiOrder
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.UnknownSide != "short" || decoded.UnknownType != "stop" || decoded.OrderID != "o1" {
+		t.Fatalf("round trip of %s = %+v, %v", data, decoded, err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"side":1}`), &order); err == nil {
+		t.Error("decoded a number as a side")
+	}
+}